@@ -21,17 +21,26 @@ import (
 // main interprets the options and args.
 func main() {
 	var startFrom, runFor int
-	var verbose bool
+	var verbose, gcs, azure bool
+	var gcsCreds, azureConnStr, azureKey string
 	var err error
 
 	flag.IntVar(&runFor, "for", 0, "number of records to use, eg 1000 ")
 	flag.IntVar(&startFrom, "from", 0, "number of records to skip, eg 100")
 	flag.BoolVar(&verbose, "v", false, "verbose")
+	flag.BoolVar(&gcs, "gcs", false, "create objects in Google Cloud Storage, url is the bucket")
+	flag.StringVar(&gcsCreds, "gcs-credentials", "",
+		"service-account file, instead of the default credentials")
+	flag.BoolVar(&azure, "azure", false, "create blobs in Azure Blob Storage, url is the container URL")
+	flag.StringVar(&azureConnStr, "azure-connection-string", "",
+		"set connection string when using azure")
+	flag.StringVar(&azureKey, "azure-key", "",
+		"set account key when using azure")
 	iniflags.Parse()
 	log.SetFlags(log.Lshortfile | log.Ldate | log.Ltime) // show file:line in logs
 
 	if flag.NArg() < 1 {
-		fmt.Fprint(os.Stderr, "Usage: mkLoadTestFiles [-v][--from N --for N] load-file.csv url\n") //nolint
+		fmt.Fprint(os.Stderr, "Usage: mkLoadTestFiles [-v][--from N --for N][-gcs|-azure] load-file.csv url\n") //nolint
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
@@ -49,11 +58,21 @@ func main() {
 	}
 	defer f.Close() // nolint
 
+	protocol := loadTesting.FilesystemProtocol
+	switch {
+	case gcs:
+		protocol = loadTesting.GCSProtocol
+	case azure:
+		protocol = loadTesting.AzureBlobProtocol
+	}
 	loadTesting.MkLoadTestFiles(f, filename, baseURL, startFrom, runFor,
 		loadTesting.Config{
-			Verbose:  verbose,
-			Protocol: loadTesting.FilesystemProtocol,
-			Strip:    "",
+			Verbose:      verbose,
+			Protocol:     protocol,
+			Strip:        "",
+			GCSCredsFile: gcsCreds,
+			AzureConnStr: azureConnStr,
+			AzureKey:     azureKey,
 			// Timeout is 0
 		})
 
@@ -5,13 +5,23 @@ package main
 import (
 	"github.com/davecb/Play-it-Again-Sam/pkg/loadTesting"
 
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"math"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
+	_ "github.com/lib/pq" // the driver for a postgres:// -results-dsn
 	"github.com/vharitonsky/iniflags"
 )
 
@@ -20,52 +30,214 @@ const terminationTimeout = 10 // Formerly 35
 func usage() {
 	//nolint
 	fmt.Fprint(os.Stderr, "Usage: runLoadTest --tps TPS [--progress "+
-		"TPS][--from rec --for rec][-v] load-file.csv baseURL\n")
+		"TPS][--from rec --for rec][-v] load-file.csv baseURL\n"+
+		"       runLoadTest --tps TPS --scenarios name=script.csv:share... [...] baseURL\n"+
+		"       runLoadTest --users N [--think-time dist --think-mean time][...] load-file.csv baseURL\n")
 	flag.PrintDefaults()
-	os.Exit(1)
+	os.Exit(exitConfig)
 }
 
 // main interprets the options and args.
 func main() {
 	var tpsTarget, progressRate, stepDuration, startTps int
-	var startFrom, runFor int
-	var s3, ceph, rest, timeBudget bool
-	var ro bool
+	var startFrom, runFor, maxRequests, repeat, pool, users, maxGoroutines, maxQueueDepth, maxCaptures int
+	var s3, ceph, rest, timeBudget, gcs, azure, grpc, websocket bool
+	var ro, lifecycle, deterministic, chunked, duplicateWrites bool
+	var preflight bool
+	var preflightPath, idempotencyHeader string
+	var seed int64
+	var rwRatio, verifyTolerance, speedup, latencyTimeout, thinkSpread float64
 	var rw, wo int64
-	var bufSize int64
+	var bufSize, maxBytesPerSec, maxReadBytes, maxTotalBytes, outputMaxSize int64
+	var multipartThreshold, partSize int64
+	var partConcurrency, pipeBuffer, shuffleWindow, outputRotate, outputBuffer, retries int
 	var s3Bucket, s3Key, s3Secret string
-	var verbose, debug, crash, akamaiDebug bool
-	var serial, cache, tail bool
-	var strip, hostHeader, headers string
+	var gcsCreds, azureConnStr, azureKey string
+	var verbose, debug, crash, akamaiDebug, strictInput, failFast, cleanup, seedObjects bool
+	var serial, cache, tail, followRotation, shuffle bool
+	var cookies, workerCookies, noKeepAlives, retryAfter, retryPosts, pausable bool
+	var keepAlivePeriod time.Duration
+	var lingerZero, dropOutput bool
+	var progressInterval, connectTimeout, requestTimeout time.Duration
+	var drainTimeout, startJitter, runDuration, rampDuration time.Duration
+	var maxConnLifetime, idleConnTimeout, minRequestTimeout, thinkMean time.Duration
+	var recordOutput, histogramFile, captureFile, outputFile, resultsFile, openMetricsFile string
+	var resultsDSN, resultsDriver string
+	var intervalStats, intervalColumns string
+	var cpuProfile, memProfile string
+	var perPath, logJSON, verifyBytes, progressBar bool
+	var arrivals, runFile, inputFormat, defaultScheme, thinkTime string
+	var strip, hostHeader, hostTemplate, headers, forceMethod string
+	var ifNoneMatch, ifModSince, query, rewrites string
+	var queryMap = make(map[string]string)
+	var rewriteMap = make(map[string]string)
+	var contentType, contentTypes, expectHeaders string
+	var contentTypeMap = make(map[string]string)
+	var expectHeaderMap = make(map[string]string)
 	var headerMap = make(map[string]string)
+	var weights, include, exclude, operations string
+	var weightField int
+	var weightMap = make(map[string]float64)
+	var protocols, protocolURLs, tags, scenarios string
+	var protocolField int
+	var protocolMap = make(map[string]string)
+	var protocolURLMap = make(map[string]string)
+	var tagMap = make(map[string]string)
+	var resolve, successCodes string
+	var maxP99 time.Duration
+	var maxErrorRate, minTPS float64
+	var matchCodes bool
+	var overrides = make(map[string]string)
 	var err error
 
 	flag.IntVar(&runFor, "for", 0, "number of records to use, eg 1000 ")
 	flag.IntVar(&startFrom, "from", 0, "number of records to skip, eg 100")
+	flag.IntVar(&repeat, "repeat", 0, "play the input this many times, then stop, or -1 for forever")
+	flag.DurationVar(&runDuration, "run-time", 0, "stop the run after this long, eg 2h")
+	flag.Float64Var(&speedup, "speedup", 0, "send records at their times in the trace, this many times faster, eg 1")
+	flag.BoolVar(&shuffle, "shuffle", false, "send the records in a random order")
+	flag.IntVar(&shuffleWindow, "shuffle-window", 0, "records to shuffle at once, default 1000")
+	flag.IntVar(&maxRequests, "max-requests", 0, "number of requests to send, eg 500")
+	flag.Int64Var(&maxTotalBytes, "max-bytes", 0, "stop once this many bytes have been read and written, eg 10000000000")
+	flag.IntVar(&maxGoroutines, "max-goroutines", 0, "don't start requests while this many goroutines run, eg 100000")
+	flag.IntVar(&maxQueueDepth, "max-queue-depth", 0, "shed requests while this many are outstanding, eg 1000")
 	flag.IntVar(&tpsTarget, "tps", 0, "TPS target")
 	flag.IntVar(&progressRate, "progress", 0, "progress rate, in TPS steps")
+	flag.DurationVar(&rampDuration, "ramp", 0, "climb linearly to the TPS target over this long, eg 30s")
+	flag.IntVar(&pool, "pool", 0, "send with this many workers, instead of one per TPS")
+	flag.IntVar(&users, "users", 0, "run this many virtual users, in a closed model, instead of a TPS")
 	flag.IntVar(&progressRate, "start-tps", 0, "TPS to start from")
 	flag.IntVar(&stepDuration, "duration", 10, "Duration of a step")
+	flag.DurationVar(&drainTimeout, "drain", 10*time.Second,
+		"time to wait for requests in flight at the end of a progression")
+	flag.DurationVar(&startJitter, "start-jitter", 0,
+		"spread of the workers' start times, default one second")
+	flag.StringVar(&arrivals, "arrivals", "",
+		"vary the time between requests, uniform or poisson")
+	flag.StringVar(&thinkTime, "think-time", "",
+		"draw the time between requests from constant, uniform, exponential or lognormal")
+	flag.DurationVar(&thinkMean, "think-mean", 0, "with --think-time, the average time, default one second")
+	flag.Float64Var(&thinkSpread, "think-spread", 0,
+		"with --think-time, the fraction either side for uniform, or sigma for lognormal, default 0.5")
+	flag.BoolVar(&deterministic, "deterministic", false,
+		"send requests in input order at exact times, for comparing runs")
+	flag.Int64Var(&seed, "seed", 0, "seed for everything random, default 42")
+	flag.DurationVar(&progressInterval, "progress-interval", 0,
+		"how often to report progress, eg 10s")
+	flag.BoolVar(&progressBar, "progress-bar", false, "show a status line on stderr, updated every second")
 
 	flag.BoolVar(&s3, "s3", false, "use s3 protocol")
 	flag.BoolVar(&rest, "rest", false, "use rest protocol")
+	flag.StringVar(&defaultScheme, "default-scheme", "", "with --rest, the scheme for a baseURL without one, eg https")
 	flag.BoolVar(&timeBudget, "timeBudget", false, "test the time budget")
+	flag.BoolVar(&gcs, "gcs", false, "use Google Cloud Storage, baseURL is the bucket")
+	flag.BoolVar(&azure, "azure", false, "use Azure Blob Storage, baseURL is the container URL")
+	flag.BoolVar(&grpc, "grpc", false, "use gRPC, baseURL is host:port, paths are methods")
+	flag.BoolVar(&websocket, "websocket", false, "use WebSockets, baseURL is the ws:// or wss:// endpoint")
 
 	flag.BoolVar(&ro, "ro", false, "read-only test")
 	flag.Int64Var(&rw, "rw", 0, "read-write test, w buffer size")
 	flag.Int64Var(&wo, "wo", 0, "write-only test, w buffer size")
+	flag.BoolVar(&lifecycle, "lifecycle", false, "PUT, GET and DELETE a new object for every record")
+	flag.BoolVar(&chunked, "chunked", false, "send REST PUTs with chunked encoding, without a Content-Length")
+	flag.BoolVar(&duplicateWrites, "duplicate-writes", false, "send each write twice with the same idempotency key")
+	flag.StringVar(&idempotencyHeader, "idempotency-header", "", "the header of the idempotency key, default Idempotency-Key")
 
 	flag.BoolVar(&serial, "serialize", false, "serialize load (only for load testing)")
-	flag.StringVar(&strip, "strip", "", "test to strip from paths")
+	flag.StringVar(&forceMethod, "force-method", "", "send every request as this method, eg GET")
+	flag.Float64Var(&rwRatio, "rw-ratio", 0, "send this fraction of requests as GETs, the rest as PUTs")
+	flag.StringVar(&strip, "strip", "", "one or more texts to strip from paths")
+	flag.StringVar(&rewrites, "rewrite", "", "rewrite paths with one or more regexp=replacement pairs")
 	flag.StringVar(&hostHeader, "host-header", "", "add a Host: header")
+	flag.StringVar(&hostTemplate, "host-template", "", "a Host: header for each request, eg {path:0}.example.com")
 	flag.StringVar(&headers, "headers", "", "add one or more key:value headers")
+	flag.StringVar(&query, "query", "", "add one or more key=value query parameters to every request")
+	flag.DurationVar(&connectTimeout, "connect-timeout", 0, "time to wait to connect, eg 3s")
+	flag.DurationVar(&requestTimeout, "request-timeout", 0, "time to wait for a whole request, eg 30s")
+	flag.Float64Var(&latencyTimeout, "latency-timeout", 0,
+		"time out requests at this many times their recorded latency, eg 10")
+	flag.DurationVar(&minRequestTimeout, "min-request-timeout", 0,
+		"with --latency-timeout, the shortest timeout, eg 100ms")
+	flag.IntVar(&retries, "retries", 0, "retry REST requests that couldn't connect or got a 502, 503 or 504, eg 2")
+	flag.BoolVar(&retryPosts, "retry-non-idempotent", false, "with --retries, retry POSTs and PATCHes too")
+	flag.BoolVar(&noKeepAlives, "no-keepalives", false, "use a new connection for every request")
+	flag.DurationVar(&keepAlivePeriod, "keepalive-period", 0,
+		"time between TCP keep-alive probes, eg 15s, or -1s for none (default 30s)")
+	flag.BoolVar(&lingerZero, "linger-zero", false, "close connections with a reset, so their ports skip TIME_WAIT")
+	flag.DurationVar(&maxConnLifetime, "max-conn-lifetime", 0,
+		"close connections this old after their request, eg 5m")
+	flag.DurationVar(&idleConnTimeout, "idle-conn-timeout", 0,
+		"close connections idle this long, eg 50s")
+	flag.Int64Var(&maxBytesPerSec, "bandwidth", 0, "limit each request's transfers to this many bytes/second")
+	flag.Int64Var(&maxReadBytes, "max-read", 0, "read at most this many bytes of each GET's response")
+	flag.BoolVar(&verifyBytes, "verify-bytes", false, "count successful GETs that aren't the recorded size")
+	flag.StringVar(&contentType, "content-type", "", "the type successful GETs must return, eg application/json")
+	flag.StringVar(&contentTypes, "content-types", "", "or one or more regexp=type pairs, eg ^/img/=image/*")
+	flag.StringVar(&expectHeaders, "expect-headers", "",
+		"headers successful GETs must have, as name=value pairs, eg X-Cache=HIT, or name=* for any value")
+	flag.Float64Var(&verifyTolerance, "verify-tolerance", 0, "with --verify-bytes, fraction the size may differ by, eg 0.01")
+	flag.BoolVar(&preflight, "preflight", false, "check the target is reachable before starting")
+	flag.StringVar(&preflightPath, "preflight-path", "",
+		"with --preflight, a path that must succeed, instead of a HEAD of the baseURL")
+	flag.BoolVar(&pausable, "pausable", false, "pause the run on a SIGUSR1, and resume it on the next")
+	flag.BoolVar(&retryAfter, "retry-after", false,
+		"pause when a 429 or 503 asks us to with Retry-After")
+	flag.StringVar(&resolve, "resolve", "", "connect to an IP instead of a host, as host=IP pairs")
+	flag.StringVar(&successCodes, "success-codes", "",
+		"codes that aren't errors, eg 200-299,304,404")
+	flag.DurationVar(&maxP99, "max-p99", 0, "fail the run if the p99 latency is over this, eg 250ms")
+	flag.Float64Var(&maxErrorRate, "max-error-rate", 0, "fail the run if the fraction of errors is over this, eg 0.01")
+	flag.Float64Var(&minTPS, "min-tps", 0, "fail the run if its average TPS is under this, eg 95")
+	flag.BoolVar(&matchCodes, "match-codes", false, "fail the run if any request doesn't get its recorded return code")
+	flag.StringVar(&ifNoneMatch, "if-none-match", "", "make GETs conditional on an ETag")
+	flag.StringVar(&ifModSince, "if-modified-since", "", "make GETs conditional on an http date")
 
+	flag.IntVar(&pipeBuffer, "pipe-buffer", 100, "number of records to queue for the workers")
 	flag.BoolVar(&cache, "cache", false, "allow caching")
 	flag.BoolVar(&tail, "tail", false, "tail -f the input file")
+	flag.BoolVar(&followRotation, "follow", false, "with --tail, reopen the input file if it's rotated")
+	flag.StringVar(&recordOutput, "record", "", "write replayable results to a file")
+	flag.StringVar(&resultsFile, "results", "", "write the results to a file, as JSON, to compare runs")
+	flag.StringVar(&openMetricsFile, "openmetrics", "", "write the final metrics to a file, in OpenMetrics format")
+	flag.StringVar(&resultsDSN, "results-dsn", "", "add a summary of the run to a database, eg postgres://host/db")
+	flag.StringVar(&resultsDriver, "results-driver", "", "the database/sql driver of -results-dsn, default its scheme")
+	flag.StringVar(&tags, "tags", "", "label the results with name=value pairs, eg \"git_sha=abc123 env=staging\"")
+	flag.StringVar(&outputFile, "output", "", "write each request to a file, instead of stdout")
+	flag.Int64Var(&outputMaxSize, "output-max-size", 0, "rotate the output file when it's this many bytes")
+	flag.IntVar(&outputRotate, "output-rotate", 0, "rotated output files to keep, default all")
+	flag.IntVar(&outputBuffer, "output-buffer", 0, "lines of output to queue for the writer (default 10000)")
+	flag.BoolVar(&dropOutput, "drop-output", false, "drop lines of output, and count them, if it can't keep up")
+	flag.StringVar(&captureFile, "capture", "", "write failed requests and responses to a file")
+	flag.IntVar(&maxCaptures, "max-captures", 0, "with --capture, the most failures to write (default 100)")
+	flag.BoolVar(&perPath, "per-path", false, "report the slowest paths at the end")
+	flag.StringVar(&histogramFile, "histogram", "", "write the latency distribution to a file")
+	flag.StringVar(&intervalStats, "interval-stats", "", "write a CSV row of stats to a file every progress interval")
+	flag.StringVar(&intervalColumns, "interval-columns", "", "columns for -interval-stats, eg timestamp,tps,p99")
+	flag.StringVar(&include, "include", "", "only send paths matching this regexp")
+	flag.StringVar(&exclude, "exclude", "", "don't send paths matching this regexp")
+	flag.StringVar(&operations, "operations", "", "only send records with these methods, eg GET,HEAD")
+	flag.StringVar(&weights, "weights", "", "weight paths by one or more regexp=weight pairs")
+	flag.IntVar(&weightField, "weight-field", 0, "weight records by this field, eg 9")
+	flag.StringVar(&protocols, "protocols", "", "send paths by other protocols, with one or more regexp=protocol pairs")
+	flag.IntVar(&protocolField, "protocol-field", 0, "send records by the protocol in this field, eg 9")
+	flag.StringVar(&protocolURLs, "protocol-urls", "", "base URLs for those protocols, as protocol=url pairs")
+	flag.StringVar(&scenarios, "scenarios", "",
+		"instead of a load file, mix scenarios by their shares, as name=script.csv:share, eg browse=browse.csv:70")
+	flag.BoolVar(&cookies, "cookies", false, "keep cookies from one request to the next")
+	flag.BoolVar(&workerCookies, "worker-cookies", false, "keep cookies per worker, not shared")
+
+	flag.StringVar(&cpuProfile, "cpuprofile", "", "write a cpu profile of the load generator to a file")
+	flag.StringVar(&memProfile, "memprofile", "", "write a heap profile of the load generator to a file")
 
 	flag.BoolVar(&debug, "d", false, "add debugging messages")
 	flag.BoolVar(&verbose, "v", false, "add verbose messages")
+	flag.BoolVar(&logJSON, "log-json", false, "log messages as JSON, one per line")
 	flag.BoolVar(&crash, "crash", false, "exit on any error return")
+	flag.BoolVar(&strictInput, "strict", false, "halt on a malformed input line, instead of skipping it")
+	flag.BoolVar(&failFast, "fail-fast", false, "stop the run at the first failed request")
+	flag.BoolVar(&cleanup, "cleanup", false, "delete the objects written, at the end of the run")
+	flag.BoolVar(&seedObjects, "seed-objects", false, "write the objects the input reads, before the run")
+	flag.StringVar(&inputFormat, "input-format", "", "read a list of paths, with \"pathlist\", instead of perf records")
 	flag.BoolVar(&akamaiDebug, "akamai-debug", false, "add akamai debugging headers")
 
 	flag.StringVar(&s3Bucket, "s3-bucket", "BUCKET NOT SET",
@@ -74,53 +246,115 @@ func main() {
 		"set key when using s3 protocol")
 	flag.StringVar(&s3Secret, "s3-secret", "SECRET NOT SET",
 		"set secret when using s3 protocol")
+	flag.Int64Var(&multipartThreshold, "multipart-threshold", 0,
+		"upload s3 objects bigger than this in parts")
+	flag.Int64Var(&partSize, "part-size", 0, "size of s3 multipart parts")
+	flag.IntVar(&partConcurrency, "part-concurrency", 0,
+		"number of s3 parts to upload at once")
+	flag.StringVar(&gcsCreds, "gcs-credentials", "",
+		"service-account file, instead of the default credentials")
+	flag.StringVar(&azureConnStr, "azure-connection-string", "",
+		"set connection string when using azure")
+	flag.StringVar(&azureKey, "azure-key", "",
+		"set account key when using azure")
+	flag.StringVar(&runFile, "run", "",
+		"read the whole run from a .json or .yaml file, instead of options")
+	if len(os.Args) > 1 && os.Args[1] == "compare" {
+		compare(os.Args[2:])
+		return
+	}
+	flag.Usage = usage // so a bad option exits with exitConfig, not the flag package's 2
 	iniflags.Parse()
+	log.SetFlags(log.Lshortfile | log.Ldate | log.Ltime) // show file:line in logs
+	ctx := interruptible()
 
-	if flag.NArg() < 2 {
+	if runFile != "" {
+		cfg, params, err := loadTesting.LoadConfig(runFile)
+		if err != nil {
+			halt(fmt.Errorf("%w, %v", loadTesting.ErrConfig, err))
+		}
+		if _, err = loadTesting.RunWithParamsContext(ctx, cfg, params); err != nil {
+			halt(err)
+		}
+		return
+	}
+	scenarioList := setScenarios(scenarios)
+	args := flag.Args()
+	if len(scenarioList) > 0 {
+		// the scenarios are the input, so there's only the url
+		args = append([]string{os.DevNull}, args...)
+	}
+	if len(args) < 2 {
 		fmt.Fprint(os.Stderr, "You must supply a load.csv file and a url\n") //nolint
 		usage()
 	}
-	log.SetFlags(log.Lshortfile | log.Ldate | log.Ltime) // show file:line in logs
 
 	setHeaders(headers, headerMap)
+	setWeights(weights, weightMap)
+	setPairs(protocols, protocolMap, true)
+	setPairs(protocolURLs, protocolURLMap, false)
+	setPairs(tags, tagMap, false)
+	setPairs(query, queryMap, false)
+	setPairs(rewrites, rewriteMap, true)
+	setPairs(contentTypes, contentTypeMap, true)
+	setPairs(expectHeaders, expectHeaderMap, false)
+	var strips []string
+	if fields := strings.Fields(strip); len(fields) > 1 {
+		strip, strips = fields[0], fields[1:]
+	}
+	setOverrides(resolve, overrides)
+	if ifModSince != "" {
+		if _, err = http.ParseTime(ifModSince); err != nil {
+			badOption("--if-modified-since must be an http date, eg %q, not %q\n",
+				time.Now().UTC().Format(http.TimeFormat), ifModSince)
+		}
+	}
 	if runFor == 0 {
 		runFor = math.MaxInt64
 	}
 
-	if tpsTarget == 0 {
-		log.Fatal("You must specify a --tps target, halting.")
+	if tpsTarget == 0 && users == 0 {
+		badOption("You must specify a --tps target, or --users, halting.")
 	}
 
 	// Interpret rw, ro and wo options
 	r, w := setMode(ro, rw, wo)
+	if lifecycle || (rwRatio > 0 && rwRatio < 1) {
+		w = true // it writes, whatever the mode
+	}
 	if wo != 0 {
 		bufSize = wo
 	} else if rw != 0 {
 		bufSize = rw
 	}
 
-	proto := setProtocol(s3, ceph, timeBudget)
-	filename := flag.Arg(0)
+	proto := setProtocol(s3, ceph, timeBudget, gcs, azure, grpc, websocket)
+	filename := args[0]
 	if filename == "" {
-		log.Fatalf("No load-test .csv file provided, halting.\n")
+		badOption("No load-test .csv file provided, halting.\n")
 	}
-	f, err := os.Open(filename)
+	f, err := loadTesting.OpenInput(filename)
 	if err != nil {
-		log.Fatalf("Error opening %s: %s, halting.", filename, err)
+		badOption("Error opening %s: %s, halting.", filename, err)
 	}
 	defer f.Close() // nolint
 
-	baseURL := flag.Arg(1)
+	baseURL := args[1]
 	if baseURL == "" {
-		log.Fatalf("No base url provided, halting. \n")
+		badOption("No base url provided, halting. \n")
 	}
 
-	loadTesting.RunLoadTest(f, filename, startFrom, runFor,
+	_, err = loadTesting.RunLoadTestContext(ctx, f, filename, startFrom, runFor,
 		tpsTarget, progressRate, startTps, baseURL,
 		loadTesting.Config{
 			Verbose:      verbose,
 			Debug:        debug,
 			Crash:        crash,
+			StrictInput:  strictInput,
+			FailFast:     failFast,
+			CleanupAfter: cleanup,
+			SeedObjects:  seedObjects,
+			InputFormat:  inputFormat,
 			AkamaiDebug:  akamaiDebug,
 			Serialize:    serial,
 			Cache:        cache,
@@ -129,15 +363,235 @@ func main() {
 			S3Key:        s3Key,
 			S3Secret:     s3Secret,
 			S3Bucket:     s3Bucket,
+			GCSCredsFile: gcsCreds,
+			AzureConnStr: azureConnStr,
+			AzureKey:     azureKey,
 			Strip:        strip,
 			Timeout:      terminationTimeout,
 			StepDuration: stepDuration,
 			HostHeader:   hostHeader,
+			HostTemplate: hostTemplate,
 			HeaderMap:    headerMap,
+			ExtraQuery:   queryMap,
 			R:            r,
 			W:            w,
 			BufSize:      bufSize,
+			UseCookieJar: cookies || workerCookies,
+			WorkerJars:   workerCookies,
+			IfNoneMatch:  ifNoneMatch,
+			IfModSince:   ifModSince,
+			MaxRequests:  maxRequests,
+			WorkerPool:   pool,
+			ClosedModel:  users > 0,
+			VirtualUsers: users,
+			ForceMethod:  forceMethod,
+
+			MaxGoroutines: maxGoroutines,
+			MaxQueueDepth: maxQueueDepth,
+			MaxTotalBytes: maxTotalBytes,
+
+			ReadWriteRatio: rwRatio,
+
+			FollowRotation: followRotation,
+			Repeat:         repeat,
+			RunDuration:    runDuration,
+			SpeedupFactor:  speedup,
+
+			SteadyRampDuration: rampDuration,
+
+			Shuffle:       shuffle,
+			ShuffleWindow: shuffleWindow,
+
+			ConnectTimeout: connectTimeout,
+			RequestTimeout: requestTimeout,
+			DrainTimeout:   drainTimeout,
+			StartJitter:    startJitter,
+			Arrivals:       arrivals,
+
+			ThinkTimeDistribution: thinkTime,
+			ThinkTimeMean:         thinkMean,
+			ThinkTimeSpread:       thinkSpread,
+
+			LatencyTimeoutFactor: latencyTimeout,
+			MinRequestTimeout:    minRequestTimeout,
+
+			Retries:            retries,
+			RetryNonIdempotent: retryPosts,
+
+			HostOverrides: overrides,
+			SuccessCodes:  setCodes(successCodes),
+
+			MaxP99:       maxP99,
+			MaxErrorRate: maxErrorRate,
+			MinTPS:       minTPS,
+			MatchCodes:   matchCodes,
+
+			DisableKeepAlives: noKeepAlives,
+			HonorRetryAfter:   retryAfter,
+			PauseSignal:       pausable,
+
+			KeepAlivePeriod: keepAlivePeriod,
+			LingerZero:      lingerZero,
+
+			MaxConnLifetime: maxConnLifetime,
+			IdleConnTimeout: idleConnTimeout,
+
+			MaxBytesPerSec: maxBytesPerSec,
+			MaxReadBytes:   maxReadBytes,
+
+			VerifyBytes:     verifyBytes,
+			VerifyTolerance: verifyTolerance,
+
+			ExpectContentType:  contentType,
+			ExpectContentTypes: contentTypeMap,
+			ExpectHeaders:      expectHeaderMap,
+
+			Lifecycle:         lifecycle,
+			ChunkedPut:        chunked,
+			DuplicateWrites:   duplicateWrites,
+			IdempotencyHeader: idempotencyHeader,
+
+			Preflight:     preflight || preflightPath != "",
+			PreflightPath: preflightPath,
+
+			Deterministic: deterministic,
+			RandomSeed:    seed,
+
+			MultipartThreshold: multipartThreshold,
+			PartSize:           partSize,
+			PartConcurrency:    partConcurrency,
+
+			ProgressInterval: progressInterval,
+			ProgressBar:      progressBar,
+			RecordOutput:     recordOutput,
+			HistogramFile:    histogramFile,
+			ResultsFile:      resultsFile,
+			OpenMetricsFile:  openMetricsFile,
+			ResultsDSN:       resultsDSN,
+			ResultsDriver:    resultsDriver,
+			PerPathStats:     perPath,
+			CPUProfile:       cpuProfile,
+			MemProfile:       memProfile,
+			CaptureFailures:  captureFile,
+			MaxCaptures:      maxCaptures,
+			PipeBuffer:       pipeBuffer,
+
+			OutputFile:    outputFile,
+			OutputMaxSize: outputMaxSize,
+			OutputRotate:  outputRotate,
+			OutputBuffer:  outputBuffer,
+			DropOutput:    dropOutput,
+
+			IntervalStatsFile: intervalStats,
+			IntervalColumns:   splitList(intervalColumns),
+
+			RunTags: tagMap,
+
+			Scenarios: scenarioList,
+
+			PathWeights: weightMap,
+			WeightField: weightField,
+
+			PathProtocols: protocolMap,
+			ProtocolField: protocolField,
+			ProtocolURLs:  protocolURLMap,
+			DefaultScheme: defaultScheme,
+
+			Strips:       strips,
+			PathRewrites: rewriteMap,
+
+			IncludePattern: include,
+			ExcludePattern: exclude,
+			Operations:     splitList(operations),
+
+			Logger: jsonLogger(logJSON, debug || verbose),
 		})
+	if err != nil {
+		halt(err)
+	}
+}
+
+// Exit codes, so automation can tell a test that failed from one
+// that couldn't run
+const (
+	exitFailed      = 1 // the results failed a check, or the run was halted
+	exitErrors      = 2 // requests failed, with --fail-fast
+	exitConfig      = 3 // the options or the run file were wrong
+	exitUnreachable = 4 // the target couldn't be reached, or stopped answering
+)
+
+// exitCode is the exit code for the error a run returned
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, loadTesting.ErrConfig):
+		return exitConfig
+	case errors.Is(err, loadTesting.ErrUnreachable):
+		return exitUnreachable
+	case errors.Is(err, loadTesting.ErrTooManyErrors):
+		return exitErrors
+	default:
+		return exitFailed
+	}
+}
+
+// compare compares the results files of two runs, before and after a
+// change, and exits with exitFailed if anything got significantly worse
+func compare(args []string) {
+	if len(args) != 2 {
+		badOption("Usage: runLoadTest compare before.json after.json")
+	}
+	before, err := loadTesting.ReadResults(args[0])
+	if err != nil {
+		badOption("Error reading %s: %v, halting.", args[0], err)
+	}
+	after, err := loadTesting.ReadResults(args[1])
+	if err != nil {
+		badOption("Error reading %s: %v, halting.", args[1], err)
+	}
+	c := loadTesting.CompareResults(before, after)
+	for i, r := range []loadTesting.Results{before, after} {
+		if len(r.Tags) > 0 {
+			fmt.Printf("%s: %v\n", args[i], r.Tags)
+		}
+	}
+	fmt.Printf("%-10s %12s %12s %8s\n", "measure", "before", "after", "change")
+	for _, m := range c.Metrics {
+		verdict := ""
+		switch {
+		case m.Regression:
+			verdict = "  REGRESSION"
+		case m.Significant:
+			verdict = "  improved"
+		}
+		fmt.Printf("%-10s %12.6f %12.6f %+7.1f%%%s\n", m.Name, m.Before, m.After, 100*m.Change, verdict)
+	}
+	if c.Regressed() {
+		halt(fmt.Errorf("%w, %s is worse than %s", loadTesting.ErrSLA, args[1], args[0]))
+	}
+}
+
+// halt logs why a run failed, and exits with its exit code
+func halt(err error) {
+	log.Output(2, fmt.Sprintf("%v, halting.\n", err)) // nolint
+	os.Exit(exitCode(err))
+}
+
+// interruptible returns a context that's cancelled by the first
+// interrupt or SIGTERM, which ends the run with its summary. A second
+// one kills the program, as usual.
+func interruptible() context.Context {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx
+}
+
+// badOption logs a misconfiguration, like log.Fatalf, and exits
+func badOption(format string, args ...interface{}) {
+	log.Output(2, fmt.Sprintf(format, args...)) // nolint
+	os.Exit(exitConfig)
 }
 
 // setheaders creates a proper map of header:value pairs
@@ -147,15 +601,126 @@ func setHeaders(headers string, headerMap map[string]string) {
 		for _, t := range tokens {
 			x := strings.Split(t, ":")
 			if len(x) != 2 || x[0] == "" || x[1] == "" {
-				log.Fatalf("headers must contain a key:value pair, found %q instead\n", t)
+				badOption("headers must contain a key:value pair, found %q instead\n", t)
 			}
 			headerMap[x[0]] = x[1]
 		}
 	}
 }
 
-// setProtocol from s3 and ceph booleans
-func setProtocol(s3, ceph, timeBudget bool) int {
+// setOverrides creates a map of host:IP pairs. IPv6 addresses contain
+// colons, so these are written as host=IP
+func setOverrides(resolve string, overrides map[string]string) {
+	for _, t := range strings.Fields(resolve) {
+		x := strings.Split(t, "=")
+		if len(x) != 2 || x[0] == "" || net.ParseIP(x[1]) == nil {
+			badOption("--resolve must contain host=IP pairs, found %q instead\n", t)
+		}
+		overrides[x[0]] = x[1]
+	}
+}
+
+// setCodes turns a list of codes and ranges of codes into a slice
+func setCodes(spec string) []int {
+	var codes []int
+
+	for _, t := range strings.Split(spec, ",") {
+		if t == "" {
+			continue
+		}
+		x := strings.Split(t, "-")
+		low, err := strconv.Atoi(x[0])
+		high := low
+		if err == nil && len(x) == 2 {
+			high, err = strconv.Atoi(x[1])
+		}
+		if err != nil || len(x) > 2 || low > high {
+			badOption("success codes must be codes or ranges, eg 200-299, found %q instead\n", t)
+		}
+		for code := low; code <= high; code++ {
+			codes = append(codes, code)
+		}
+	}
+	return codes
+}
+
+// jsonLogger returns a logger that writes JSON to stderr, or nil for
+// the package's default, human-readable one
+func jsonLogger(json, debugging bool) *slog.Logger {
+	if !json {
+		return nil
+	}
+	level := slog.LevelInfo
+	if debugging {
+		level = slog.LevelDebug
+	}
+	return slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{AddSource: true, Level: level}))
+}
+
+// splitList turns a comma-separated list into a slice, nil if it's empty
+func splitList(list string) []string {
+	var items []string
+
+	for _, t := range strings.Split(list, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			items = append(items, t)
+		}
+	}
+	return items
+}
+
+// setPairs creates a map from key=value pairs. Values can contain "=",
+// unless lastEquals, when keys can, as regexps might.
+func setPairs(spec string, pairs map[string]string, lastEquals bool) {
+	for _, t := range strings.Fields(spec) {
+		i := strings.Index(t, "=")
+		if lastEquals {
+			i = strings.LastIndex(t, "=")
+		}
+		if i <= 0 || i == len(t)-1 {
+			badOption("expected key=value pairs, found %q instead\n", t)
+		}
+		pairs[t[:i]] = t[i+1:]
+	}
+}
+
+// setScenarios creates a list of scenarios from name=script:share triples
+func setScenarios(spec string) []loadTesting.Scenario {
+	var scenarios []loadTesting.Scenario
+
+	for _, t := range strings.Fields(spec) {
+		i, j := strings.Index(t, "="), strings.LastIndex(t, ":")
+		if i <= 0 || j <= i+1 {
+			badOption("scenarios must be name=script:share, found %q instead\n", t)
+		}
+		share, err := strconv.ParseFloat(t[j+1:], 64)
+		if err != nil || share <= 0 {
+			badOption("the share in %q must be a positive number\n", t)
+		}
+		scenarios = append(scenarios, loadTesting.Scenario{Name: t[:i], File: t[i+1 : j], Share: share})
+	}
+	return scenarios
+}
+
+// setWeights creates a map of path-pattern:weight pairs
+func setWeights(weights string, weightMap map[string]float64) {
+	if weights != "" {
+		for _, t := range strings.Fields(weights) {
+			i := strings.LastIndex(t, "=")
+			if i <= 0 {
+				badOption("weights must be regexp=weight pairs, found %q instead\n", t)
+			}
+			w, err := strconv.ParseFloat(t[i+1:], 64)
+			if err != nil || w < 0 {
+				badOption("weight in %q must be a non-negative number\n", t)
+			}
+			weightMap[t[:i]] = w
+		}
+	}
+}
+
+// setProtocol from s3, ceph and other booleans
+func setProtocol(s3, ceph, timeBudget, gcs, azure, grpc, websocket bool) int {
 	var proto int
 
 	switch {
@@ -165,6 +730,14 @@ func setProtocol(s3, ceph, timeBudget bool) int {
 		proto = loadTesting.CephProtocol // unimplemented
 	case timeBudget:
 		proto = loadTesting.TimeBudgetProtocol
+	case gcs:
+		proto = loadTesting.GCSProtocol
+	case azure:
+		proto = loadTesting.AzureBlobProtocol
+	case grpc:
+		proto = loadTesting.GRPCProtocol
+	case websocket:
+		proto = loadTesting.WebSocketProtocol
 	default: //REST
 		proto = loadTesting.RESTProtocol
 	}
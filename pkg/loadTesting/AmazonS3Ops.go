@@ -5,10 +5,13 @@ package loadTesting
 // team debugged it for me. I expect most people will use the Amazon library.
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
+	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -21,25 +24,26 @@ import (
 
 // S3Proto satisfies operation by doing rest operations.
 type S3Proto struct {
+	*Runner
 	prefix string
+	svc    *s3.S3
 }
 
-var svc *s3.S3
 var awsLogLevel = aws.LogOff
 
 // Get does a get operation from an s3Protocol target and times it,
-func (p S3Proto) Get(path string, oldRc string) {
-	if conf.Debug {
-		log.Printf("in AmazonS3Get(%s, %s)\n", p.prefix, path)
+func (p *S3Proto) Get(path, size, oldRc string) {
+	if p.conf.Debug {
+		p.debugf("in AmazonS3Get(%s, %s)\n", p.prefix, path)
 
-		head, err := svc.HeadObject(&s3.HeadObjectInput{
-			Bucket: aws.String(conf.S3Bucket),
+		head, err := p.svc.HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(p.conf.S3Bucket),
 			Key:    aws.String(path),
 		})
 		if err != nil {
-			fmt.Println("HeadObject err", err)
+			p.debugf("HeadObject err %v\n", err)
 		} else {
-			fmt.Println("HeadObject ", head)
+			p.debugf("HeadObject %v\n", head)
 			//HeadObject  {
 			//	AcceptRanges: "bytes",
 			//	ContentLength: 7623,
@@ -54,102 +58,171 @@ func (p S3Proto) Get(path string, oldRc string) {
 
 	file, err := ioutil.TempFile("/tmp", "loadTesting")
 	if err != nil {
-		log.Fatalf("Unable to create a temp file,  %v", err)
+		p.fail(fmt.Errorf("unable to create a temp file, %v", err))
+		p.alive <- true
+		return
 	}
 	defer os.Remove(file.Name()) // nolint
 
-	downloader := s3manager.NewDownloaderWithClient(svc)
+	downloader := s3manager.NewDownloaderWithClient(p.svc)
+	readRange := p.readRange()
 	initial := time.Now() //              				***** Response time starts
-	numBytes, err := downloader.Download(file,
+	numBytes, err := downloader.DownloadWithContext(p.ctx, file,
 		&s3.GetObjectInput{
-			Bucket: aws.String(conf.S3Bucket),
+			Bucket: aws.String(p.conf.S3Bucket),
 			Key:    aws.String(path),
+			Range:  readRange,
 		})
 	responseTime := time.Since(initial) // 				***** Response time ends
+	if err != nil && readRange != nil && errorCodeToHTTPCode(err) == http.StatusRequestedRangeNotSatisfiable {
+		// a range from 0 is only unsatisfiable if the object is empty
+		err = nil
+	}
 	if err != nil {
 		rc := errorCodeToHTTPCode(err)
-		fmt.Printf("%s %f 0 0 %d %s %d GET\n",
+		p.outf("%s %f 0 0 %d %s %d GET\n",
 			initial.Format("2006-01-02 15:04:05.000"),
 			responseTime.Seconds(), numBytes, path, rc)
-		reportPerformance(initial, responseTime, 0, nil, path, rc, oldRc)
+		p.reportPerformance(initial, responseTime, 0, nil, path, rc, oldRc)
 
 		// Extract and reportPerformance the failure, iff possible
-		alive <- true
+		p.alive <- true
 		return
 	}
-	fmt.Printf("%s %f 0 0 %d %s 200 GET\n",
+	p.outf("%s %f 0 0 %d %s 200 GET\n",
 		initial.Format("2006-01-02 15:04:05.000"),
 		responseTime.Seconds(), numBytes, path)
-	reportPerformance(initial, responseTime, 0, nil, path, 200, oldRc)
+	p.verifyBytes(path, size, 200, numBytes)
+	p.reportPerformance(initial, responseTime, 0, nil, path, 200, oldRc)
+
+	p.alive <- true
+}
+
+// readRange is the range to GET with MaxReadBytes, or nil for all of it.
+// S3 refuses it with a 416 if the object is empty, which Get allows.
+func (p *S3Proto) readRange() *string {
+	if p.conf.MaxReadBytes <= 0 {
+		return nil
+	}
+	return aws.String(fmt.Sprintf("bytes=0-%d", p.conf.MaxReadBytes-1))
+}
+
+// Put puts an object of the given size from the junk data file, or
+// the contents of a body file, and times it. Objects bigger than the multipart threshold are uploaded in
+// parts, as real clients do, but are still reported as one request.
+func (p *S3Proto) Put(path, size, oldRC string) {
+	if p.conf.Debug {
+		p.debugf("in AmazonS3Put(%s, %s, %s)\n", p.prefix, path, size)
+	}
+	body, bytes := p.requestBody(size)
+	if body == nil {
+		p.reportPut(time.Now(), 0, 0, size, path, 411, oldRC) // 411 means "length required"
+		p.alive <- true
+		return
+	}
+	defer body.Close() // nolint
+	size = strconv.FormatInt(bytes, 10)
+
+	var err error
+	initial := time.Now() //              				***** Response time starts
+	if p.conf.MultipartThreshold > 0 && bytes > p.conf.MultipartThreshold {
+		err = p.multipartPut(p.ctx, path, body)
+	} else {
+		_, err = p.svc.PutObjectWithContext(p.ctx, &s3.PutObjectInput{
+			Bucket:        aws.String(p.conf.S3Bucket),
+			Key:           aws.String(path),
+			Body:          readSeeker(body),
+			ContentLength: aws.Int64(bytes),
+		})
+	}
+	responseTime := time.Since(initial) // 				***** Response time ends
+	rc := http.StatusOK
+	if err != nil {
+		p.warnf("unable to upload %q to %q, %v\n", path, p.conf.S3Bucket, err)
+		rc = errorCodeToHTTPCode(err)
+		if p.conf.Crash {
+			p.fatalf("halting.\n")
+		}
+	}
+	p.reportPut(initial, responseTime, 0, size, path, rc, oldRC)
+	p.alive <- true
+}
+
+// Post is a Put, as s3 has no separate POST of an object
+func (p *S3Proto) Post(path, size, oldRC string) {
+	p.Put(path, size, oldRC)
+}
+
+// Delete deletes an object to clean up after a run. S3 doesn't
+// complain if it doesn't exist.
+func (p *S3Proto) Delete(ctx context.Context, path string) error {
+	_, err := p.svc.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(p.conf.S3Bucket),
+		Key:    aws.String(path),
+	})
+	return err
+}
 
-	alive <- true
+// Seed writes an object of junk data before the run, untimed
+func (p *S3Proto) Seed(ctx context.Context, path string, size int64) error {
+	file, err := os.Open(p.junkDataFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close() // nolint
+	body := io.NewSectionReader(file, 0, size)
+	if p.conf.MultipartThreshold > 0 && size > p.conf.MultipartThreshold {
+		return p.multipartPut(ctx, path, body)
+	}
+	_, err = p.svc.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(p.conf.S3Bucket),
+		Key:           aws.String(path),
+		Body:          body,
+		ContentLength: aws.Int64(size),
+	})
+	return err
 }
 
-// Put puts a file and times it
-// error return is used only by mkLoadTestFiles  FIXME
-func (p S3Proto) Put(path, size, oldRC string) {
-	log.Fatalf("put is not implemented yet\n")
-	//if conf.Debug {
-	//	log.Printf("in AmazonS3Put(%s, %s, %d)\n", p.prefix, path, size)
-	//}
-	//
-	//file, err := os.Open(junkDataFile)
-	//if err != nil {
-	//	return fmt.Errorf("Unable to open junk-data file %s, %v", junkDataFile, err)
-	//}
-	//defer file.Close() // nolint
-	//lr := io.LimitReader(file, size)
-	//
-	//if svc == nil {
-	//	return fmt.Errorf("missing service %v", svc)
-	//}
-	//uploader := s3manager.NewUploaderWithClient(svc)
-	//initial := time.Now() //              				***** Response time starts
-	//_, err = uploader.Upload(&s3manager.UploadInput{
-	//	Bucket: aws.String(conf.S3Bucket),
-	//	Key:    aws.String(path),
-	//	Body:   lr,
-	//})
-	//responseTime := time.Since(initial) // 				***** Response time ends
-	//// FIXME swap this around
-	//if err == nil {
-	//	fmt.Printf("%s %f 0 0 %d %s 201 PUT\n",
-	//		initial.Format("2006-01-02 15:04:05.000"),
-	//		responseTime.Seconds(), size, path)
-	//	alive <- true
-	//	return nil
-	//}
-	//// This doesn't seem to do what one exoects: FIXME?
-	//// reqerr, ok := err.(awserr.RequestFailure)
-	////if ok {
-	////	log.Printf("%s %f 0 0 %d %s %d GET\n",
-	////		initial.Format("2006-01-02 15:04:05.000"),
-	////		responseTime.Seconds(), size, path, reqerr.StatusCode)
-	////	alive <- true
-	//// return nil
-	////}
-	//fmt.Printf("%s %f 0 0 %d %s 4XX GET\n",
-	//	initial.Format("2006-01-02 15:04:05.000"),
-	//	responseTime.Seconds(), size, path)
-	//alive <- true
-	//return fmt.Errorf("unable to upload %q to %q, %v", path, conf.S3Bucket, err)
+// multipartPut uploads in parts, several at a time
+func (p *S3Proto) multipartPut(ctx context.Context, path string, body io.Reader) error {
+	uploader := s3manager.NewUploaderWithClient(p.svc, func(u *s3manager.Uploader) {
+		if p.conf.PartSize > 0 {
+			u.PartSize = p.conf.PartSize
+		}
+		if p.conf.PartConcurrency > 0 {
+			u.Concurrency = p.conf.PartConcurrency
+		}
+		if p.conf.Debug {
+			p.debugf("multipart upload of %s in %d-byte parts, %d at a time\n",
+				path, u.PartSize, u.Concurrency)
+		}
+	})
+	out, err := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket: aws.String(p.conf.S3Bucket),
+		Key:    aws.String(path),
+		Body:   body,
+	})
+	if err == nil && p.conf.Debug {
+		p.debugf("multipart upload %s of %s complete\n", out.UploadID, path)
+	}
+	return err
 }
 
-// mustCreateService creates a connection to an s3-compatible server.
-func mustCreateService(myEndpoint string, awsLogLevel aws.LogLevelType) *s3.S3 {
+// createService creates a connection to an s3-compatible server.
+func (p *S3Proto) createService(myEndpoint string, awsLogLevel aws.LogLevelType) (*s3.S3, error) {
 
-	if conf.S3Key == "" {
-		log.Fatal("called mustCreateService with no s3 params, internal error\n")
+	if p.conf.S3Key == "" {
+		return nil, fmt.Errorf("the s3 protocol needs a key")
 	}
-	if conf.Verbose {
+	if p.conf.Verbose {
 		awsLogLevel = aws.LogDebugWithSigning | aws.LogDebugWithHTTPBody |
 			aws.LogDebugWithRequestErrors
 	}
 	token := ""
-	creds := credentials.NewStaticCredentials(conf.S3Key, conf.S3Secret, token)
+	creds := credentials.NewStaticCredentials(p.conf.S3Key, p.conf.S3Secret, token)
 	_, err := creds.Get()
 	if err != nil {
-		log.Fatalf("bad credentials: %s\n", err)
+		return nil, fmt.Errorf("bad credentials, %v", err)
 	}
 	cfg := aws.NewConfig().
 		WithLogLevel(awsLogLevel).
@@ -160,17 +233,19 @@ func mustCreateService(myEndpoint string, awsLogLevel aws.LogLevelType) *s3.S3 {
 		WithCredentials(creds)
 	sess, err := session.NewSession() // There is a session.Must() for convenience
 	if err != nil {
-		log.Fatalf("bad session=%v\n", err)
+		return nil, fmt.Errorf("bad session, %v", err)
 	}
-	svc = s3.New(sess, cfg)
-	return svc
+	return s3.New(sess, cfg), nil
 }
 
 // Init makes sure we have an amazon s3 session and any other prerequisites.
-func (p S3Proto) Init() {
-	if svc == nil {
-		svc = mustCreateService(p.prefix, awsLogLevel)
+func (p *S3Proto) Init() error {
+	var err error
+
+	if p.svc, err = p.createService(p.prefix, awsLogLevel); err != nil {
+		return fmt.Errorf("%w, %v", ErrConfig, err)
 	}
+	return nil
 }
 
 // errorCodeToHTTPCode is wimpey!
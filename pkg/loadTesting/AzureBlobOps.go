@@ -0,0 +1,174 @@
+package loadTesting
+
+// AzureBlobOps implements get and put against Azure Blob Storage, using
+// the Azure SDK. The baseURL is the container URL, for example
+// https://account.blob.core.windows.net/container, and the path in the
+// load file names the blob. Credentials are either a connection string
+// or an account key.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+)
+
+// AzureBlobProto satisfies operation by doing Azure blob operations.
+type AzureBlobProto struct {
+	*Runner
+	prefix    string
+	container *container.Client
+}
+
+// Init makes sure we have a client for the container
+func (p *AzureBlobProto) Init() error {
+	var err error
+
+	if p.container, err = p.createAzureContainer(p.prefix); err != nil {
+		return fmt.Errorf("%w, %v", ErrConfig, err)
+	}
+	return nil
+}
+
+// Get does a get of a blob and times it
+func (p *AzureBlobProto) Get(path, size, oldRc string) {
+	if p.conf.Debug {
+		p.debugf("in AzureBlobProto.Get(%s, %s)\n", p.prefix, path)
+	}
+	blob := p.container.NewBlockBlobClient(strings.TrimPrefix(path, "/"))
+
+	initial := time.Now() // Response time starts
+	resp, err := blob.DownloadStream(p.ctx, nil)
+	latency := time.Since(initial) // Latency ends
+	if err != nil {
+		if p.conf.Verbose {
+			p.debugf("error getting %s from %s, %v\n", path, p.prefix, err)
+		}
+		p.reportPerformance(initial, latency, 0, nil, path, azureErrorToHTTPCode(err), oldRc)
+		p.alive <- true
+		return
+	}
+	defer resp.Body.Close() // nolint
+	body, err := ioutil.ReadAll(resp.Body)
+	transferTime := time.Since(initial) - latency // Transfer time ends
+	rc := http.StatusOK
+	if err != nil {
+		p.warnf("error reading %s from %s, continuing, %v\n", path, p.prefix, err)
+		rc = azureErrorToHTTPCode(err)
+	}
+	p.verifyBytes(path, size, rc, int64(len(body)))
+	p.reportPerformance(initial, latency, transferTime, body, path, rc, oldRc)
+	p.alive <- true
+}
+
+// Put uploads a blob of the given size from the junk data file, or
+// the contents of a body file
+func (p *AzureBlobProto) Put(path, size, oldRc string) {
+	if p.conf.Debug {
+		p.debugf("in AzureBlobProto.Put(%s, %s, %s)\n", p.prefix, path, size)
+	}
+	body, bytes := p.requestBody(size)
+	if body == nil {
+		p.reportPut(time.Now(), 0, 0, size, path, 411, oldRc) // 411 means "length required"
+		p.alive <- true
+		return
+	}
+	defer body.Close() // nolint
+	size = strconv.FormatInt(bytes, 10)
+	blob := p.container.NewBlockBlobClient(strings.TrimPrefix(path, "/"))
+
+	initial := time.Now() // Response time starts
+	_, err := blob.UploadStream(p.ctx, body, nil)
+	latency := time.Since(initial) // Response time ends
+	rc := http.StatusCreated
+	if err != nil {
+		p.warnf("error putting %s to %s, %v\n", path, p.prefix, err)
+		rc = azureErrorToHTTPCode(err)
+		if p.conf.Crash {
+			p.fatalf("halting.\n")
+		}
+	}
+	p.reportPut(initial, latency, 0, size, path, rc, oldRc)
+	p.alive <- true
+}
+
+// Post is a Put, as blobs are only ever written whole
+func (p *AzureBlobProto) Post(path, size, oldRc string) {
+	p.Put(path, size, oldRc)
+}
+
+// Delete deletes a blob, if it exists, to clean up after a run
+func (p *AzureBlobProto) Delete(ctx context.Context, path string) error {
+	blob := p.container.NewBlockBlobClient(strings.TrimPrefix(path, "/"))
+	_, err := blob.Delete(ctx, nil)
+	if err != nil && azureErrorToHTTPCode(err) == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// Seed writes a blob of junk data before the run, untimed
+func (p *AzureBlobProto) Seed(ctx context.Context, path string, size int64) error {
+	fp, err := os.Open(p.junkDataFile)
+	if err != nil {
+		return err
+	}
+	defer fp.Close() // nolint
+	blob := p.container.NewBlockBlobClient(strings.TrimPrefix(path, "/"))
+	_, err = blob.UploadStream(ctx, io.LimitReader(fp, size), nil)
+	return err
+}
+
+// createAzureContainer connects to a container with a connection
+// string if we have one, otherwise with the account key
+func (p *AzureBlobProto) createAzureContainer(containerURL string) (*container.Client, error) {
+	var client *container.Client
+
+	u, err := url.Parse(containerURL)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a container URL, %v", containerURL, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%q is not a container URL, it has no host", containerURL)
+	}
+	switch {
+	case p.conf.AzureConnStr != "":
+		name := strings.Trim(u.Path, "/")
+		client, err = container.NewClientFromConnectionString(p.conf.AzureConnStr, name, nil)
+	case p.conf.AzureKey != "":
+		// the account is the first part of the hostname
+		account := strings.Split(u.Host, ".")[0]
+		var cred *container.SharedKeyCredential
+		cred, err = container.NewSharedKeyCredential(account, p.conf.AzureKey)
+		if err != nil {
+			return nil, fmt.Errorf("bad azure credentials, %v", err)
+		}
+		client, err = container.NewClientWithSharedKeyCredential(containerURL, cred, nil)
+	default:
+		return nil, errors.New("azure needs either a connection string or an account key")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not create an azure client, %v", err)
+	}
+	return client, nil
+}
+
+// azureErrorToHTTPCode returns the http code from an azure error, if any
+func azureErrorToHTTPCode(err error) int {
+	var respErr *azcore.ResponseError
+
+	if !errors.As(err, &respErr) {
+		return -2 // not from azure
+	}
+	return respErr.StatusCode
+}
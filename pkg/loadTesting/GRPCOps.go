@@ -0,0 +1,175 @@
+package loadTesting
+
+// GRPCOps implements unary gRPC calls to arbitrary methods, without
+// needing the generated client code. The baseURL is host:port and
+// the path in the load file is the fully-qualified method name, such as
+// /helloworld.Greeter/SayHello. gRPC status codes are reported as
+// their nearest http equivalents, so the output can be treated
+// exactly like that of the REST protocol.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCProto satisfies operation by making gRPC calls.
+type GRPCProto struct {
+	*Runner
+	prefix string
+	conn   *grpc.ClientConn
+}
+
+// padField is the protobuf field we put the payload in. It's the
+// largest legal field number, so that servers will skip over it as an
+// unknown field, rather than fail to unmarshal the request.
+const padField = 1<<29 - 1
+
+// Init dials the server. The connection is plaintext.
+func (p *GRPCProto) Init() error {
+	var err error
+
+	p.conn, err = grpc.Dial(p.prefix,
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return fmt.Errorf("%w, could not dial gRPC server %s, %v", ErrConfig, p.prefix, err)
+	}
+	return nil
+}
+
+// Get calls a method with an empty request, and times it
+func (p *GRPCProto) Get(path, size, oldRc string) {
+	if p.conf.Debug {
+		p.debugf("in GRPCProto.Get(%s, %s)\n", p.prefix, path)
+	}
+	initial, latency, reply, rc := p.call(path, nil)
+	p.reportPerformance(initial, latency, 0, reply, path, rc, oldRc)
+	p.alive <- true
+}
+
+// Put calls a method with a request of the given size, or of the
+// contents of a body file, and times it
+func (p *GRPCProto) Put(path, size, oldRc string) {
+	if p.conf.Debug {
+		p.debugf("in GRPCProto.Put(%s, %s, %s)\n", p.prefix, path, size)
+	}
+	body, bytes := p.requestBody(size)
+	if body == nil {
+		p.reportPut(time.Now(), 0, 0, size, path, 411, oldRc) // 411 means "length required"
+		p.alive <- true
+		return
+	}
+	payload, err := ioutil.ReadAll(body)
+	body.Close() // nolint
+	if err != nil {
+		p.fail(fmt.Errorf("could not read the body for %s, %v", path, err))
+		p.alive <- true
+		return
+	}
+	initial, latency, _, rc := p.call(path, payload)
+	p.reportPut(initial, latency, 0, strconv.FormatInt(bytes, 10), path, rc, oldRc)
+	p.alive <- true
+}
+
+// Post is a Put, as every gRPC call is already a POST
+func (p *GRPCProto) Post(path, size, oldRc string) {
+	p.Put(path, size, oldRc)
+}
+
+// call invokes a method with a payload, returning the time it
+// started, its latency, the reply and an http code
+func (p *GRPCProto) call(method string, payload []byte) (time.Time, time.Duration, []byte, int) {
+	var reply []byte
+
+	if !strings.HasPrefix(method, "/") {
+		method = "/" + method
+	}
+	req := padding(payload)
+	initial := time.Now() // Response time starts
+	err := p.conn.Invoke(p.ctx, method, &req, &reply,
+		grpc.ForceCodec(rawCodec{}))
+	latency := time.Since(initial) // Response time ends
+	code := status.Code(err)
+	if err != nil && p.conf.Verbose {
+		p.debugf("gRPC call to %s failed, %s: %v\n", method, code, err)
+	}
+	return initial, latency, reply, grpcCodeToHTTPCode(code)
+}
+
+// padding makes a valid protobuf message that's nothing but an
+// unknown field holding the payload
+func padding(payload []byte) []byte {
+	if len(payload) == 0 {
+		return nil
+	}
+	buf := make([]byte, 2*binary.MaxVarintLen64, 2*binary.MaxVarintLen64+len(payload))
+	n := binary.PutUvarint(buf, padField<<3|2) // 2 is length-delimited
+	n += binary.PutUvarint(buf[n:], uint64(len(payload)))
+	return append(buf[:n], payload...)
+}
+
+// rawCodec passes bytes through unchanged, so we can call any method.
+// It calls itself proto so servers will accept it.
+type rawCodec struct{}
+
+// Marshal returns the request bytes
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return nil, fmt.Errorf("rawCodec can't marshal a %T", v)
+	}
+	return *b, nil
+}
+
+// Unmarshal copies the reply bytes
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("rawCodec can't unmarshal into a %T", v)
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+// Name is the content-subtype
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+// grpcCodeToHTTPCode uses the same mapping as grpc-gateway
+func grpcCodeToHTTPCode(code codes.Code) int {
+	rc, present := grpcCodeMap[code]
+	if !present {
+		return http.StatusInternalServerError
+	}
+	return rc
+}
+
+var grpcCodeMap = map[codes.Code]int{
+	codes.OK:                 http.StatusOK,
+	codes.Canceled:           499,
+	codes.Unknown:            http.StatusInternalServerError,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.FailedPrecondition: http.StatusBadRequest,
+	codes.Aborted:            http.StatusConflict,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Internal:           http.StatusInternalServerError,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DataLoss:           http.StatusInternalServerError,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+}
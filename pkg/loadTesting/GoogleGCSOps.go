@@ -0,0 +1,167 @@
+package loadTesting
+
+// GoogleGCSOps implements GCS get and put using the Google client library.
+// The baseURL names the bucket, and the path in the load file names the object.
+// Credentials come from the usual application-default chain, unless
+// the config provides a service-account file.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// GCSProto satisfies operation by doing Google Cloud Storage operations.
+type GCSProto struct {
+	*Runner
+	prefix string
+	client *storage.Client
+}
+
+// Init makes sure we have a GCS client
+func (p *GCSProto) Init() error {
+	var err error
+
+	if p.client, err = p.createGCSClient(); err != nil {
+		return fmt.Errorf("%w, %v", ErrConfig, err)
+	}
+	return nil
+}
+
+// Get does a get of an object from a GCS bucket and times it
+func (p *GCSProto) Get(path, size, oldRc string) {
+	if p.conf.Debug {
+		p.debugf("in GCSProto.Get(%s, %s)\n", p.prefix, path)
+	}
+	obj := p.client.Bucket(p.bucket()).Object(strings.TrimPrefix(path, "/"))
+
+	initial := time.Now() // Response time starts
+	rdr, err := obj.NewReader(p.ctx)
+	latency := time.Since(initial) // Latency ends
+	if err != nil {
+		if p.conf.Verbose {
+			p.debugf("error getting %s from %s, %v\n", path, p.bucket(), err)
+		}
+		p.reportPerformance(initial, latency, 0, nil, path, gcsErrorToHTTPCode(err), oldRc)
+		p.alive <- true
+		return
+	}
+	defer rdr.Close() // nolint
+	body, err := ioutil.ReadAll(rdr)
+	transferTime := time.Since(initial) - latency // Transfer time ends
+	rc := http.StatusOK
+	if err != nil {
+		p.warnf("error reading %s from %s, continuing, %v\n", path, p.bucket(), err)
+		rc = gcsErrorToHTTPCode(err)
+	}
+	p.verifyBytes(path, size, rc, int64(len(body)))
+	p.reportPerformance(initial, latency, transferTime, body, path, rc, oldRc)
+	p.alive <- true
+}
+
+// Put writes an object of the given size from the junk data file, or
+// the contents of a body file
+func (p *GCSProto) Put(path, size, oldRc string) {
+	if p.conf.Debug {
+		p.debugf("in GCSProto.Put(%s, %s, %s)\n", p.prefix, path, size)
+	}
+	body, bytes := p.requestBody(size)
+	if body == nil {
+		p.reportPut(time.Now(), 0, 0, size, path, 411, oldRc) // 411 means "length required"
+		p.alive <- true
+		return
+	}
+	defer body.Close() // nolint
+	size = strconv.FormatInt(bytes, 10)
+	obj := p.client.Bucket(p.bucket()).Object(strings.TrimPrefix(path, "/"))
+
+	initial := time.Now() // Response time starts
+	w := obj.NewWriter(p.ctx)
+	_, err := io.Copy(w, body)
+	if err == nil {
+		// the upload isn't complete until the close succeeds
+		err = w.Close()
+	}
+	latency := time.Since(initial) // Response time ends
+	rc := http.StatusCreated
+	if err != nil {
+		p.warnf("error putting %s to %s, %v\n", path, p.bucket(), err)
+		rc = gcsErrorToHTTPCode(err)
+		if p.conf.Crash {
+			p.fatalf("halting.\n")
+		}
+	}
+	p.reportPut(initial, latency, 0, size, path, rc, oldRc)
+	p.alive <- true
+}
+
+// Post is a Put, as GCS objects are only ever written whole
+func (p *GCSProto) Post(path, size, oldRc string) {
+	p.Put(path, size, oldRc)
+}
+
+// Delete deletes an object, if it exists, to clean up after a run
+func (p *GCSProto) Delete(ctx context.Context, path string) error {
+	obj := p.client.Bucket(p.bucket()).Object(strings.TrimPrefix(path, "/"))
+	err := obj.Delete(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil
+	}
+	return err
+}
+
+// Seed writes an object of junk data before the run, untimed
+func (p *GCSProto) Seed(ctx context.Context, path string, size int64) error {
+	fp, err := os.Open(p.junkDataFile)
+	if err != nil {
+		return err
+	}
+	defer fp.Close() // nolint
+	w := p.client.Bucket(p.bucket()).Object(strings.TrimPrefix(path, "/")).NewWriter(ctx)
+	if _, err = io.Copy(w, io.LimitReader(fp, size)); err != nil {
+		w.Close() // nolint
+		return err
+	}
+	return w.Close()
+}
+
+// bucket is the prefix, less any gs:// scheme
+func (p *GCSProto) bucket() string {
+	return strings.Trim(strings.TrimPrefix(p.prefix, "gs://"), "/")
+}
+
+// createGCSClient connects to GCS with the default or configured credentials
+func (p *GCSProto) createGCSClient() (*storage.Client, error) {
+	var opts []option.ClientOption
+
+	if p.conf.GCSCredsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(p.conf.GCSCredsFile))
+	}
+	client, err := storage.NewClient(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("could not create a GCS client, %v", err)
+	}
+	return client, nil
+}
+
+// gcsErrorToHTTPCode maps the errors we know about to http codes
+func gcsErrorToHTTPCode(err error) int {
+	if err == storage.ErrObjectNotExist || err == storage.ErrBucketNotExist {
+		return http.StatusNotFound
+	}
+	gerr, ok := err.(*googleapi.Error)
+	if !ok {
+		return -2 // not from GCS
+	}
+	return gerr.Code
+}
@@ -1,7 +1,6 @@
 package loadTesting
 
 import (
-	"log"
 	"net/http"
 	"time"
 )
@@ -9,20 +8,22 @@ import (
 
 // timeBudgetProto satisfies operation by doing timed no-ops.
 type timeBudgetProto struct {
+	*Runner
 	prefix string
 }
 
 // Init does nothing
-func (p timeBudgetProto) Init() {
-	if conf.Debug {
-		log.Printf("in timeBudgetProto.Init()\n")
+func (p *timeBudgetProto) Init() error {
+	if p.conf.Debug {
+		p.debugf("in timeBudgetProto.Init()\n")
 	}
+	return nil
 }
 
 // Get does a GET that should take one tenth of a second
-func (p timeBudgetProto) Get(path string, oldRc string) {
-	if conf.Debug {
-		log.Printf("in timeBudgetProto.Get(%s)\n", path)
+func (p *timeBudgetProto) Get(path, size, oldRc string) {
+	if p.conf.Debug {
+		p.debugf("in timeBudgetProto.Get(%s)\n", path)
 	}
 
 	initial := time.Now() // Response time starts
@@ -32,15 +33,15 @@ func (p timeBudgetProto) Get(path string, oldRc string) {
 	totalTime := time.Since(initial)
 	transferTime := totalTime - latency // Transfer time ends
 
-	reportPerformance(initial, latency, transferTime, []byte(""), path, http.StatusOK, oldRc)
-	close(alive) // This forces an immediate exit
+	p.reportPerformance(initial, latency, transferTime, []byte(""), path, http.StatusOK, oldRc)
+	close(p.alive) // This forces an immediate exit
 }
 
 // Put does a PUT that should take one tenth of a second
-func (p timeBudgetProto) Put(path, size, oldRc string) {
+func (p *timeBudgetProto) Put(path, size, oldRc string) {
 
-	if conf.Debug {
-		log.Printf("in timeBudgetProto.Put(%s, %s)\n", path, size)
+	if p.conf.Debug {
+		p.debugf("in timeBudgetProto.Put(%s, %s)\n", path, size)
 	}
 	initial := time.Now() // Response time starts
 	// wait a tenth of a second
@@ -49,7 +50,12 @@ func (p timeBudgetProto) Put(path, size, oldRc string) {
 	totalTime := time.Since(initial)
 	transferTime := totalTime - latency // Transfer time ends
 
-	reportPerformance(initial, latency, transferTime, []byte(""), path, http.StatusOK, oldRc)
-	close(alive)
+	p.reportPerformance(initial, latency, transferTime, []byte(""), path, http.StatusOK, oldRc)
+	close(p.alive)
+}
+
+// Post does a Put
+func (p *timeBudgetProto) Post(path, size, oldRc string) {
+	p.Put(path, size, oldRc)
 }
 
@@ -0,0 +1,157 @@
+package loadTesting
+
+// WebSocketOps sends messages over long-lived WebSocket connections.
+// The baseURL is the ws:// or wss:// endpoint. Each worker keeps its
+// own connection from one request to the next, as a real client would,
+// and each record sends one message and times the round trip to the
+// first message back, the echo or acknowledgement. A GET sends the path
+// as a text message, a PUT or POST a binary message of the record's
+// size. Replies are reported as 200, a failure to connect as 599 or the
+// handshake's http code, and a broken connection as 444, so the output
+// can be treated exactly like that of the REST protocol.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocketProto satisfies operation by sending WebSocket messages.
+type WebSocketProto struct {
+	*Runner
+	prefix string
+	dialer *websocket.Dialer
+	ws     *wsConn // this worker's connection
+}
+
+// wsConn is a worker's connection, opened when it's first used, and
+// again if it breaks. Requests on it take turns, as each waits for
+// its reply.
+type wsConn struct {
+	sync.Mutex
+	conn *websocket.Conn
+}
+
+// Init sets up the dialer, with the same timeout and host overrides
+// as REST
+func (p *WebSocketProto) Init() error {
+	p.dialer = &websocket.Dialer{
+		NetDialContext:   p.dialWithOverrides(p.newDialer()),
+		HandshakeTimeout: p.conf.ConnectTimeout,
+	}
+	p.ws = &wsConn{}
+	return nil
+}
+
+// forWorker returns a copy of p with a connection of its own
+func (p *WebSocketProto) forWorker() operation {
+	c := *p
+	c.ws = &wsConn{}
+	return &c
+}
+
+// Get sends the path as a text message, and times the reply
+func (p *WebSocketProto) Get(path, size, oldRc string) {
+	if p.conf.Debug {
+		p.debugf("in WebSocketProto.Get(%s, %s)\n", p.prefix, path)
+	}
+	initial, latency, reply, rc := p.roundTrip(websocket.TextMessage, []byte(path))
+	p.reportPerformance(initial, latency, 0, reply, path, rc, oldRc)
+	p.alive <- true
+}
+
+// Put sends a binary message of the given size, and times the reply
+func (p *WebSocketProto) Put(path, size, oldRc string) {
+	p.send("PUT", path, size, oldRc)
+}
+
+// Post is a Put, as a message has no method
+func (p *WebSocketProto) Post(path, size, oldRc string) {
+	p.send("POST", path, size, oldRc)
+}
+
+// send sends a body from a file or of junk data, like a REST upload
+func (p *WebSocketProto) send(method, path, size, oldRc string) {
+	if p.conf.Debug {
+		p.debugf("in WebSocketProto.%s(%s, %s, %s)\n", method, p.prefix, path, size)
+	}
+	body, bytes := p.requestBody(size)
+	if body == nil {
+		p.reportWrite(method, time.Now(), 0, 0, size, path, 411, oldRc) // 411 means "length required"
+		p.alive <- true
+		return
+	}
+	payload, err := ioutil.ReadAll(body)
+	body.Close() // nolint
+	if err != nil {
+		p.fail(fmt.Errorf("could not read the body for %s, %v", path, err))
+		p.alive <- true
+		return
+	}
+	initial, latency, _, rc := p.roundTrip(websocket.BinaryMessage, payload)
+	p.reportWrite(method, initial, latency, 0, strconv.FormatInt(bytes, 10), path, rc, oldRc)
+	p.alive <- true
+}
+
+// roundTrip sends a message and waits for the reply, connecting first
+// if need be, and returns the time it started, its latency, the reply
+// and an http code. Connecting counts toward the latency, as it does
+// for REST.
+func (p *WebSocketProto) roundTrip(kind int, msg []byte) (time.Time, time.Duration, []byte, int) {
+	p.ws.Lock()
+	defer p.ws.Unlock()
+
+	initial := time.Now() // Response time starts
+	if p.ws.conn == nil {
+		conn, resp, err := p.dialer.Dial(p.prefix, p.header())
+		if err != nil {
+			if p.conf.Verbose {
+				p.debugf("could not connect to %s, %v\n", p.prefix, err)
+			}
+			if resp != nil {
+				return initial, time.Since(initial), nil, resp.StatusCode
+			}
+			return initial, time.Since(initial), nil, 599
+		}
+		p.ws.conn = conn
+	}
+	var deadline time.Time // none
+	if p.conf.RequestTimeout > 0 {
+		deadline = initial.Add(p.conf.RequestTimeout)
+	}
+	p.ws.conn.SetWriteDeadline(deadline) // nolint
+	p.ws.conn.SetReadDeadline(deadline)  // nolint
+
+	var reply []byte
+	err := p.ws.conn.WriteMessage(kind, msg)
+	if err == nil {
+		_, reply, err = p.ws.conn.ReadMessage()
+	}
+	latency := time.Since(initial) // Response time ends
+	if err != nil {
+		if p.conf.Verbose {
+			p.debugf("websocket to %s failed, %v, reconnecting\n", p.prefix, err)
+		}
+		p.ws.conn.Close() // nolint
+		p.ws.conn = nil
+		return initial, latency, reply, 444
+	}
+	return initial, latency, reply, http.StatusOK
+}
+
+// header is the handshake's extra headers
+func (p *WebSocketProto) header() http.Header {
+	h := http.Header{}
+	for key, value := range p.conf.HeaderMap {
+		h.Add(key, value)
+	}
+	if p.conf.HostHeader != "" {
+		h.Set("Host", p.conf.HostHeader)
+	}
+	return h
+}
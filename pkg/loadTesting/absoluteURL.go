@@ -0,0 +1,43 @@
+package loadTesting
+
+// Absolute URLs: traces captured across several hosts often have the
+// whole URL in each record, eg https://img.example.com/logo.png, not a
+// path to join to the one base URL. A REST record whose path is an
+// http or https URL is sent to it as it is, ignoring the base URL, so
+// such a trace can be replayed without splitting it by host. Strip
+// applies to the whole URL first, so it can remove a scheme and host
+// to make it relative. The rewrites and host templates then apply to
+// the path after any host left, as they would to a relative one, and
+// the two kinds can be mixed.
+
+import (
+	"net/url"
+	"strings"
+)
+
+// splitOrigin splits an absolute URL into its scheme and host, with
+// any userinfo and port, eg https://example.com, and the rest. A
+// relative path has no origin.
+func splitOrigin(path string) (string, string) {
+	i := strings.Index(path, "://")
+	if i < 0 {
+		return "", path
+	}
+	u, err := url.Parse(path)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", path
+	}
+	// the origin ends where the path, query or fragment starts, after
+	// any userinfo and port
+	end := len(path)
+	if j := strings.IndexAny(path[i+len("://"):], "/?#"); j >= 0 {
+		end = i + len("://") + j
+	}
+	return path[:end], path[end:]
+}
+
+// isAbsolute is true if a path is a whole URL, to be sent as it is
+func isAbsolute(path string) bool {
+	origin, _ := splitOrigin(path)
+	return origin != ""
+}
@@ -0,0 +1,31 @@
+package loadTesting
+
+import (
+	"testing"
+)
+
+// TestSplitOrigin sees if only http and https URLs with a host are
+// split into an origin and a path
+func TestSplitOrigin(t *testing.T) {
+	for _, c := range []struct {
+		name, path, origin, rest string
+	}{
+		{"a path", "/index.html", "", "/index.html"},
+		{"http", "http://example.com/index.html", "http://example.com", "/index.html"},
+		{"https", "https://example.com/a/b?c=d", "https://example.com", "/a/b?c=d"},
+		{"no path", "http://example.com", "http://example.com", ""},
+		{"a query and no path", "http://example.com?a=b", "http://example.com", "?a=b"},
+		{"a port", "http://example.com:8080/x", "http://example.com:8080", "/x"},
+		{"userinfo", "http://user:pw@example.com/x", "http://user:pw@example.com", "/x"},
+		{"userinfo and port", "https://user@example.com:8443/x", "https://user@example.com:8443", "/x"},
+		{"ftp", "ftp://example.com/file", "", "ftp://example.com/file"},
+		{"no host", "http:///index.html", "", "http:///index.html"},
+		{"a URL in the query", "/redirect?to=http://example.com/x", "", "/redirect?to=http://example.com/x"},
+	} {
+		origin, rest := splitOrigin(c.path)
+		if origin != c.origin || rest != c.rest {
+			t.Errorf("%s, splitOrigin(%q) is %q, %q, expected %q, %q",
+				c.name, c.path, origin, rest, c.origin, c.rest)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package loadTesting
+
+// Assertions are the run's pass/fail verdict, in one place: each check
+// configured, its limit, the value the run got, and whether it passed.
+// They're the last section of the summary, are in the Results and the
+// OpenMetrics, and if any failed, Run returns ErrSLA. The checks are a
+// maximum p99 and error rate, a minimum TPS, and, with VerifyBytes,
+// ExpectHeaders, DuplicateWrites and MatchCodes, no GETs of the wrong
+// size or without their headers, no duplicate writes that differed,
+// and no unexpected return codes.
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Assertion is a check of the run's results
+type Assertion struct {
+	Name   string // eg "p99"
+	Limit  string // eg "<= 250ms"
+	Actual string // eg "180ms"
+	Passed bool
+}
+
+// assertions checks the results against the configured limits
+func (c Config) assertions(r Results) []Assertion {
+	var a []Assertion
+
+	if c.MaxP99 > 0 {
+		a = append(a, Assertion{"p99", "<= " + c.MaxP99.String(),
+			r.P99.Round(time.Microsecond).String(), r.P99 <= c.MaxP99})
+	}
+	if c.MaxErrorRate > 0 {
+		a = append(a, Assertion{"error rate", fmt.Sprintf("<= %g%%", 100*c.MaxErrorRate),
+			fmt.Sprintf("%.2f%%", 100*r.ErrorRate()), r.ErrorRate() <= c.MaxErrorRate})
+	}
+	if c.MinTPS > 0 {
+		a = append(a, Assertion{"tps", fmt.Sprintf(">= %g", c.MinTPS),
+			fmt.Sprintf("%.1f", r.TPS()), r.TPS() >= c.MinTPS})
+	}
+	if c.VerifyBytes {
+		a = append(a, Assertion{"byte mismatches", "== 0",
+			strconv.FormatInt(r.ByteMismatches, 10), r.ByteMismatches == 0})
+	}
+	if len(c.ExpectHeaders) > 0 {
+		a = append(a, Assertion{"header mismatches", "== 0",
+			strconv.FormatInt(r.HeaderMismatches, 10), r.HeaderMismatches == 0})
+	}
+	if c.DuplicateWrites {
+		a = append(a, Assertion{"duplicate writes that differed", "== 0",
+			strconv.FormatInt(r.DuplicatesDiffer, 10), r.DuplicatesDiffer == 0})
+	}
+	if c.MatchCodes {
+		a = append(a, Assertion{"code mismatches", "== 0",
+			strconv.FormatInt(r.CodeMismatches, 10), r.CodeMismatches == 0})
+	}
+	return a
+}
+
+// finalResults returns the Results so far, with the assertions checked
+func (lt *Runner) finalResults() Results {
+	r := lt.results.snapshot()
+	r.Assertions = lt.conf.assertions(r)
+	return r
+}
+
+// checkAssertions returns an ErrSLA if any assertion failed
+func (lt *Runner) checkAssertions() error {
+	var failed int
+
+	for _, a := range lt.finalResults().Assertions {
+		if !a.Passed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%w, %d assertions failed", ErrSLA, failed)
+	}
+	return nil
+}
+
+// reportAssertions logs the assertions, and their verdict
+func (lt *Runner) reportAssertions(assertions []Assertion) {
+	var failed int
+
+	if len(assertions) == 0 {
+		return
+	}
+	lt.infof("assertions:\n")
+	for _, a := range assertions {
+		if a.Passed {
+			lt.infof("  passed %s %s, was %s\n", a.Name, a.Limit, a.Actual)
+			continue
+		}
+		lt.warnf("  FAILED %s %s, was %s\n", a.Name, a.Limit, a.Actual)
+		failed++
+	}
+	if failed > 0 {
+		lt.warnf("%d of %d assertions failed\n", failed, len(assertions))
+		return
+	}
+	lt.infof("all %d assertions passed\n", len(assertions))
+}
+
+// codeMismatch is true if a request didn't get the return code its
+// record expected
+func codeMismatch(rc int, oldRc string) bool {
+	old, _ := strconv.Atoi(oldRc)
+	return old != 0 && rc != old
+}
@@ -0,0 +1,65 @@
+package loadTesting
+
+// Bandwidth limits each request's body and response to MaxBytesPerSec,
+// as a mobile or edge client on a slow link would. Slow clients hold
+// connections and server buffers for longer, which changes the
+// concurrency and queueing at the server. Only REST requests are
+// limited, as the other protocols' libraries own their transports.
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"golang.org/x/time/rate"
+)
+
+const maxBandwidthBurst = 32 * 1024 // bytes read at a time, at most
+
+// limitedBody reads no faster than its limiter allows, until ctx is done
+type limitedBody struct {
+	io.ReadCloser
+	limiter *rate.Limiter
+	ctx     context.Context
+}
+
+// limitBandwidth wraps a body so it's read at no more than bytesPerSec
+func limitBandwidth(ctx context.Context, body io.ReadCloser, bytesPerSec int64) io.ReadCloser {
+	burst := bytesPerSec
+	if burst > maxBandwidthBurst {
+		burst = maxBandwidthBurst
+	}
+	return &limitedBody{
+		ReadCloser: body,
+		limiter:    rate.NewLimiter(rate.Limit(bytesPerSec), int(burst)),
+		ctx:        ctx,
+	}
+}
+
+// Read reads at most a burst, then waits until the limit allows it
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if len(p) > b.limiter.Burst() {
+		p = p[:b.limiter.Burst()]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if werr := b.limiter.WaitN(b.ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+// limitRequest limits the upload of a request with a body
+func (lt *Runner) limitRequest(req *http.Request) {
+	if lt.conf.MaxBytesPerSec > 0 && req.Body != nil && req.Body != http.NoBody {
+		req.Body = limitBandwidth(lt.ctx, req.Body, lt.conf.MaxBytesPerSec)
+	}
+}
+
+// limitResponse limits the download of a response
+func (lt *Runner) limitResponse(resp *http.Response) {
+	if lt.conf.MaxBytesPerSec > 0 {
+		resp.Body = limitBandwidth(lt.ctx, resp.Body, lt.conf.MaxBytesPerSec)
+	}
+}
@@ -0,0 +1,119 @@
+package loadTesting
+
+// BodyFiles lets PUTs and POSTs send realistic payloads. If the bytes
+// field of a record is {body:file}, the body is the contents of that
+// file and the size is its length, otherwise it's that many bytes of
+// junk data. Files are read once and kept, as the same few are usually
+// sent over and over.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// bodyCache holds the contents of the body files read so far
+type bodyCache struct {
+	sync.Mutex
+	bodies map[string][]byte
+}
+
+// bodyFile returns the file named by a {body:file} size field
+func bodyFile(size string) (string, bool) {
+	if !strings.HasPrefix(size, "{body:") || !strings.HasSuffix(size, "}") {
+		return "", false
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(size, "{body:"), "}"), true
+}
+
+// readBody returns the contents of a file, reading it only the first time
+func (c *bodyCache) readBody(name string) ([]byte, error) {
+	c.Lock()
+	defer c.Unlock()
+	if body, present := c.bodies[name]; present {
+		return body, nil
+	}
+	body, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("can't read body file %q, %v", name, err)
+	}
+	if c.bodies == nil {
+		c.bodies = make(map[string][]byte)
+	}
+	c.bodies[name] = body
+	return body, nil
+}
+
+// requestBody returns the body to send for a size field, and its
+// length. It's nil if the size is zero, as there's nothing to send,
+// and if there's no way to send it, which stops the run.
+func (lt *Runner) requestBody(size string) (io.ReadCloser, int64) {
+	if name, ok := bodyFile(size); ok {
+		body, err := lt.bodies.readBody(name)
+		if err != nil {
+			lt.fail(fmt.Errorf("%w, %v", ErrConfig, err))
+			return nil, 0
+		}
+		if len(body) == 0 {
+			return http.NoBody, 0
+		}
+		return seekBody{ReadSeeker: bytes.NewReader(body)}, int64(len(body))
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		lt.fail(fmt.Errorf("%w, put size %q was unreadable, %v", ErrConfig, size, err))
+		return nil, 0
+	}
+	if n <= 0 {
+		return nil, 0
+	}
+	// make sure we have a dummy file
+	fp, err := os.Open(lt.junkDataFile)
+	if err != nil {
+		lt.fail(fmt.Errorf("can't open data file %q, %v", lt.junkDataFile, err))
+		return nil, 0
+	}
+	return seekBody{ReadSeeker: io.NewSectionReader(fp, 0, n), file: fp}, n
+}
+
+// bodyGetter is a request's GetBody, which reads the body of a size
+// or {body:file} field again, so the request can be sent again
+func (lt *Runner) bodyGetter(field string) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		b, _ := lt.requestBody(field)
+		if b == nil {
+			return nil, fmt.Errorf("could not read the body %q again", field)
+		}
+		return b, nil
+	}
+}
+
+// seekBody is a body that can be rewound, for the SDKs that read
+// it more than once, to sign it or to send it again
+type seekBody struct {
+	io.ReadSeeker
+	file *os.File // the junk data file, or nil for a body file
+}
+
+// Close closes the junk data file, if it's from one
+func (b seekBody) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	return b.file.Close()
+}
+
+// readSeeker returns a body from requestBody as an io.ReadSeeker.
+// They all are, except an empty one.
+func readSeeker(body io.ReadCloser) io.ReadSeeker {
+	if rs, ok := body.(io.ReadSeeker); ok {
+		return rs
+	}
+	return bytes.NewReader(nil)
+}
@@ -0,0 +1,55 @@
+package loadTesting
+
+// Byte volume: the bytes of GET bodies read and of PUT and POST bodies
+// written are counted, and reported at the end. For tests where egress
+// is metered, or that check behavior up to a data cap, MaxTotalBytes
+// ends the run once the two together reach it, like MaxRequests does
+// with a count: no more requests are started, and those in flight are
+// drained. A write that got no response isn't counted, nor is one
+// whose size wasn't a number, as it was never sent.
+
+import (
+	"strconv"
+)
+
+// addBytes counts bytes read and written by a request, and returns
+// the total so far
+func (s *stats) addBytes(read, written int64) int64 {
+	s.Lock()
+	defer s.Unlock()
+	s.bytesRead += read
+	s.bytesWritten += written
+	return s.bytesRead + s.bytesWritten
+}
+
+// countRead counts the body of a GET
+func (lt *Runner) countRead(body []byte) {
+	lt.checkBytes(lt.results.addBytes(int64(len(body)), 0))
+}
+
+// countWritten counts the body of a write, if it was sent
+func (lt *Runner) countWritten(size string, rc int) {
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || rc <= 0 {
+		return
+	}
+	lt.checkBytes(lt.results.addBytes(0, n))
+}
+
+// checkBytes tells Run when the total reaches MaxTotalBytes
+func (lt *Runner) checkBytes(total int64) {
+	if lt.conf.MaxTotalBytes <= 0 || total < lt.conf.MaxTotalBytes {
+		return
+	}
+	select {
+	case lt.spent <- total:
+	default:
+		// Run has already been told
+	}
+}
+
+// reportBytes reports the bytes transferred
+func (lt *Runner) reportBytes(r Results) {
+	lt.infof("%d bytes transferred, %d read and %d written\n",
+		r.BytesRead+r.BytesWritten, r.BytesRead, r.BytesWritten)
+}
@@ -0,0 +1,97 @@
+package loadTesting
+
+// CaptureFailures writes the request line, headers and the start of
+// the response of failed REST requests to a file, for debugging the
+// few that matter without dumping everything at a high TPS. It stops
+// after MaxCaptures, and credentials in an Authorization header
+// aren't written.
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxCaptures = 100  // failures to capture, by default
+	maxCaptureBody     = 4096 // bytes of a response body to capture
+)
+
+// captureWriter writes failed requests. It's shared by all the workers.
+type captureWriter struct {
+	sync.Mutex
+	f     *os.File // nil once closed
+	count int
+	limit int
+}
+
+// createCapture creates the capture file
+func createCapture(name string, limit int) (*captureWriter, error) {
+	if limit == 0 {
+		limit = defaultMaxCaptures
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not create capture file %q, %v", name, err)
+	}
+	return &captureWriter{f: f, limit: limit}, nil
+}
+
+// captureFailure writes a request and its response, if it failed
+func (lt *Runner) captureFailure(req *http.Request, resp *http.Response, body []byte,
+	rc int, oldRc string, reqErr error) {
+	if lt.captures == nil || !lt.failed(rc, oldRc) {
+		return
+	}
+	c := lt.captures
+	c.Lock()
+	defer c.Unlock()
+	if c.f == nil || c.count >= c.limit {
+		return
+	}
+	c.count++
+
+	s := fmt.Sprintf("=== %s, %s %s returned %d", time.Now().Format(time.RFC3339Nano),
+		req.Method, req.URL, rc)
+	if reqErr != nil {
+		s += fmt.Sprintf(", %v", reqErr)
+	}
+	s += "\n"
+	redacted := req.Clone(req.Context())
+	if redacted.Header.Get("Authorization") != "" {
+		redacted.Header.Set("Authorization", "redacted")
+	}
+	if dump, err := httputil.DumpRequest(redacted, false); err == nil {
+		s += string(dump)
+	}
+	if resp != nil {
+		if dump, err := httputil.DumpResponse(resp, false); err == nil {
+			s += string(dump)
+		}
+	}
+	if len(body) > maxCaptureBody {
+		s += fmt.Sprintf("%s\n... %d more bytes\n", body[:maxCaptureBody], len(body)-maxCaptureBody)
+	} else if len(body) > 0 {
+		s += fmt.Sprintf("%s\n", body)
+	}
+	if _, err := c.f.WriteString(s + "\n"); err != nil {
+		lt.warnf("error writing capture file %q, %v\n", c.f.Name(), err)
+	}
+	if c.count == c.limit {
+		lt.infof("captured %d failed requests, the maximum\n", c.count)
+	}
+}
+
+// close closes the file
+func (c *captureWriter) close() {
+	c.Lock()
+	defer c.Unlock()
+	if c.f == nil {
+		return
+	}
+	c.f.Close() // nolint
+	c.f = nil
+}
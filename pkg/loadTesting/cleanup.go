@@ -0,0 +1,93 @@
+package loadTesting
+
+// Cleanup deletes the objects a run wrote, with CleanupAfter, so a write
+// test doesn't leave a bucket or server full of junk data. Each path is
+// remembered as its PUT is sent, with the operation that sent it, and
+// deleted by the same operation once the run is over. Deletes aren't
+// timed or reported, as they aren't part of the load.
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const cleanupWorkers = 16              // deletes to have in flight at once
+const cleanupTimeout = 5 * time.Minute // to delete them all, even if the run was cancelled
+
+// cleaner is an operation that can delete what it wrote
+type cleaner interface {
+	Delete(ctx context.Context, path string) error
+}
+
+// createdSet is the paths written with each operation
+type createdSet struct {
+	sync.Mutex
+	paths map[operation]map[string]bool
+}
+
+// add remembers a path written with op
+func (c *createdSet) add(op operation, path string) {
+	c.Lock()
+	defer c.Unlock()
+	if c.paths == nil {
+		c.paths = make(map[operation]map[string]bool)
+	}
+	if c.paths[op] == nil {
+		c.paths[op] = make(map[string]bool)
+	}
+	c.paths[op][path] = true
+}
+
+// cleanup deletes everything written during the run, unless it takes
+// longer than the timeout. It has a context of its own, as a cancelled
+// run is the one most likely to have left junk behind. Objects that
+// were never created, as their PUT failed, are not an error.
+func (lt *Runner) cleanup(timeout time.Duration) {
+	type deletion struct {
+		cl   cleaner
+		path string
+	}
+	var deleted, failed int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	lt.created.Lock()
+	defer lt.created.Unlock()
+	work := make(chan deletion)
+	var wg sync.WaitGroup
+	for i := 0; i < cleanupWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range work {
+				if err := d.cl.Delete(ctx, d.path); err != nil {
+					lt.warnf("cleanup could not delete %s, %v\n", d.path, err)
+					atomic.AddInt64(&failed, 1)
+					continue
+				}
+				atomic.AddInt64(&deleted, 1)
+			}
+		}()
+	}
+	for op, paths := range lt.created.paths {
+		cl, ok := op.(cleaner)
+		if !ok {
+			lt.warnf("this protocol can't delete, %d objects not cleaned up\n", len(paths))
+			continue
+		}
+		for path := range paths {
+			if ctx.Err() != nil {
+				break
+			}
+			work <- deletion{cl: cl, path: path}
+		}
+	}
+	close(work)
+	wg.Wait()
+	if ctx.Err() != nil {
+		lt.warnf("cleanup stopped early, %v\n", ctx.Err())
+	}
+	lt.infof("Cleaned up %d objects, %d could not be deleted\n", deleted, failed)
+}
@@ -0,0 +1,103 @@
+package loadTesting
+
+// In the closed model, a fixed number of virtual users each send a
+// request, wait for its response, think, and repeat, so the offered
+// load backs off as the target slows, and the throughput emerges from
+// the latency: about VirtualUsers / (latency + think time). It's
+// instead of a TPS target, which is an open model, where requests
+// are sent on schedule however long the earlier ones are taking. The
+// think times are drawn from the ThinkTimeDistribution, or if there's
+// none, the users don't think, and keep the target saturated.
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// checkClosedModel returns an error if the virtual users can't run
+func checkClosedModel(c Config) error {
+	switch {
+	case !c.ClosedModel && c.VirtualUsers != 0:
+		return fmt.Errorf("virtual users are only for the closed model")
+	case !c.ClosedModel:
+		return nil
+	case c.VirtualUsers <= 0:
+		return fmt.Errorf("the closed model needs one or more virtual users, not %d", c.VirtualUsers)
+	case c.WorkerPool > 0 || c.Deterministic:
+		return fmt.Errorf("the closed model's virtual users replace a worker pool or a deterministic scheduler")
+	case c.Arrivals != FixedArrivals:
+		return fmt.Errorf("virtual users send as soon as they've thought, so can't have %s arrivals", c.Arrivals)
+	case c.MaxQueueDepth > 0:
+		return fmt.Errorf("the closed model never has more requests outstanding than virtual users")
+	case c.Protocol == TimeBudgetProtocol:
+		return fmt.Errorf("the time budget protocol needs one worker per request, not virtual users")
+	}
+	return nil
+}
+
+// runClosedModel starts the virtual users, and runs until the end of the data
+func (lt *Runner) runClosedModel(pipe chan []string) {
+	var users sync.WaitGroup
+
+	lt.infof("starting %d virtual users, in a closed model\n", lt.conf.VirtualUsers)
+	for i := 0; i < lt.conf.VirtualUsers; i++ {
+		users.Add(1)
+		go func() {
+			defer users.Done()
+			lt.virtualUser(pipe)
+		}()
+	}
+	if lt.conf.Repeat == 0 {
+		// run until there's no activity
+		return
+	}
+	// otherwise stop once the users have used up the input
+	users.Wait()
+	close(lt.closed)
+	lt.drain(lt.conf.DrainTimeout)
+	close(lt.finished)
+}
+
+// virtualUser sends a request, waits for it and thinks, until the
+// input is used up
+func (lt *Runner) virtualUser(pipe chan []string) {
+	atomic.AddInt64(&lt.workers, 1)
+	defer atomic.AddInt64(&lt.workers, -1)
+	op, gen := lt.workerOp()
+
+	// start at a random point in a think time, so the users don't
+	// all send at once
+	time.Sleep(time.Duration(lt.randomFloat64() * float64(lt.userThinkTime())))
+	for {
+		op, gen = lt.latestOp(op, gen)
+		if lt.doWork(op, pipe, lt.run) {
+			return
+		}
+		if d := lt.userThinkTime(); d > 0 {
+			lt.results.addThink(d)
+			time.Sleep(d)
+		}
+	}
+}
+
+// userThinkTime draws a virtual user's think time, or zero if they don't
+func (lt *Runner) userThinkTime() time.Duration {
+	if lt.conf.ThinkTimeDistribution == NoThinkTime {
+		return 0
+	}
+	lt.randomLock.Lock()
+	defer lt.randomLock.Unlock()
+	return lt.thinkTime()
+}
+
+// reportClosedModel gives the throughput the virtual users achieved,
+// and the time they thought
+func (lt *Runner) reportClosedModel(r Results) {
+	lt.infof("%d virtual users achieved %.1f TPS\n", lt.conf.VirtualUsers, r.TPS())
+	if r.Thinks > 0 {
+		lt.infof("they thought %d times, for %.3f s on average\n",
+			r.Thinks, r.ThinkTime.Seconds()/float64(r.Thinks))
+	}
+}
@@ -0,0 +1,185 @@
+package loadTesting
+
+// Comparing runs turns a before and after pair into a regression check,
+// eg for a PR. Each run's Results are saved with ResultsFile, and
+// CompareResults reports the change in TPS, error rate and latency.
+// The error rates are compared with a two-proportion z-test. The TPS
+// and percentiles are compared with Welch's t-test across the runs'
+// intervals, if both have at least minTrendIntervals of them, and
+// otherwise a change of compareThreshold or more is taken as real.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"time"
+)
+
+const (
+	compareThreshold = 0.1 // a relative change that counts, without intervals
+	criticalValue    = 2.0 // of z or t, for about 95% confidence
+)
+
+// Comparison is the difference between two runs, before and after a change
+type Comparison struct {
+	Metrics []MetricDelta
+}
+
+// MetricDelta is the change in one measure between the runs
+type MetricDelta struct {
+	Name        string  // eg "tps" or "p99"
+	Before      float64 // latencies are in seconds
+	After       float64
+	Change      float64 // relative to Before, eg 0.1 for 10% more
+	Significant bool    // unlikely to be chance
+	Regression  bool    // significant, and worse
+}
+
+// Regressed is true if any measure got significantly worse
+func (c Comparison) Regressed() bool {
+	for _, m := range c.Metrics {
+		if m.Regression {
+			return true
+		}
+	}
+	return false
+}
+
+// CompareResults compares two runs, a before and an after
+func CompareResults(a, b Results) Comparison {
+	var c Comparison
+
+	tps := delta("tps", a.TPS(), b.TPS())
+	tps.Significant = changed(tps, a, b, func(r IntervalResult) float64 { return r.TPS })
+	tps.Regression = tps.Significant && tps.After < tps.Before
+	c.Metrics = append(c.Metrics, tps)
+
+	errors := delta("error_rate", a.ErrorRate(), b.ErrorRate())
+	errors.Significant = math.Abs(proportionZ(a.Errors, a.Requests, b.Errors, b.Requests)) >= criticalValue
+	errors.Regression = errors.Significant && errors.After > errors.Before
+	c.Metrics = append(c.Metrics, errors)
+
+	for _, p := range []struct {
+		name          string
+		before, after time.Duration
+		value         func(IntervalResult) float64
+	}{
+		{"p50", a.P50, b.P50, func(r IntervalResult) float64 { return r.P50.Seconds() }},
+		{"p90", a.P90, b.P90, func(r IntervalResult) float64 { return r.P90.Seconds() }},
+		{"p99", a.P99, b.P99, func(r IntervalResult) float64 { return r.P99.Seconds() }},
+	} {
+		m := delta(p.name, p.before.Seconds(), p.after.Seconds())
+		m.Significant = changed(m, a, b, p.value)
+		m.Regression = m.Significant && m.After > m.Before
+		c.Metrics = append(c.Metrics, m)
+	}
+	return c
+}
+
+// delta is the change in a measure, not yet judged
+func delta(name string, before, after float64) MetricDelta {
+	m := MetricDelta{Name: name, Before: before, After: after}
+	if before != 0 {
+		m.Change = (after - before) / before
+	}
+	return m
+}
+
+// intervalValues returns a measure of each interval of both runs, or
+// nils if either has too few to compare
+func intervalValues(a, b Results, value func(IntervalResult) float64) ([]float64, []float64) {
+	if len(a.Intervals) < minTrendIntervals || len(b.Intervals) < minTrendIntervals {
+		return nil, nil
+	}
+	var before, after []float64
+	for _, r := range a.Intervals {
+		before = append(before, value(r))
+	}
+	for _, r := range b.Intervals {
+		after = append(after, value(r))
+	}
+	return before, after
+}
+
+// changed is true if a measure changed by more than chance, judged
+// by its value in each interval if there are any, and by its size if not
+func changed(m MetricDelta, a, b Results, value func(IntervalResult) float64) bool {
+	before, after := intervalValues(a, b, value)
+	if before == nil {
+		return m.Before != m.After && (m.Before == 0 || math.Abs(m.Change) >= compareThreshold)
+	}
+	return math.Abs(welchT(before, after)) >= criticalValue
+}
+
+// welchT is Welch's t statistic for the difference in two means
+func welchT(a, b []float64) float64 {
+	meanA, varA := meanVariance(a)
+	meanB, varB := meanVariance(b)
+	se := math.Sqrt(varA/float64(len(a)) + varB/float64(len(b)))
+	if se == 0 {
+		if meanA == meanB {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return (meanB - meanA) / se
+}
+
+// meanVariance returns the mean and sample variance of some values
+func meanVariance(values []float64) (float64, float64) {
+	var sum, squares float64
+
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	for _, v := range values {
+		squares += (v - mean) * (v - mean)
+	}
+	return mean, squares / float64(len(values)-1)
+}
+
+// proportionZ is the z statistic for the difference in two proportions
+func proportionZ(x1, n1, x2, n2 int64) float64 {
+	if n1 == 0 || n2 == 0 {
+		return 0
+	}
+	p1, p2 := float64(x1)/float64(n1), float64(x2)/float64(n2)
+	p := float64(x1+x2) / float64(n1+n2)
+	se := math.Sqrt(p * (1 - p) * (1/float64(n1) + 1/float64(n2)))
+	if se == 0 {
+		return 0
+	}
+	return (p2 - p1) / se
+}
+
+// WriteResults saves a run's Results, as JSON, to compare it later
+func WriteResults(name string, r Results) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, data, 0644)
+}
+
+// ReadResults reads the Results WriteResults saved
+func ReadResults(name string) (Results, error) {
+	var r Results
+
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return r, err
+	}
+	if err = json.Unmarshal(data, &r); err != nil {
+		return r, fmt.Errorf("%s is not a results file, %v", name, err)
+	}
+	return r, nil
+}
+
+// writeResults saves the results of the run to ResultsFile
+func (lt *Runner) writeResults(name string) {
+	if err := WriteResults(name, lt.finalResults()); err != nil {
+		lt.warnf("could not write results file %q, %v\n", name, err)
+	}
+}
@@ -0,0 +1,57 @@
+package loadTesting
+
+// Connection lifetimes, for soak tests. Load balancers often drop
+// connections that have been open or idle too long without telling
+// either end, and the next request on one fails. Closing them first
+// avoids that, or, with a longer limit than the balancer's,
+// reproduces it deliberately.
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+// lifetimeTrace returns a request that notes the connection it gets in conn
+func lifetimeTrace(req *http.Request, conn *net.Conn) *http.Request {
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) { *conn = info.Conn },
+	}))
+}
+
+// expiring returns a response body that also closes its connection when
+// it's closed, if the connection has outlived MaxConnLifetime
+func (p *RestProto) expiring(body io.ReadCloser, conn net.Conn) io.ReadCloser {
+	if conn == nil || time.Since(openedAt(conn)) < p.conf.MaxConnLifetime {
+		return body
+	}
+	return &expiredBody{ReadCloser: body, conn: conn}
+}
+
+// expiredBody closes its connection after the response, so the
+// transport dials a new one for the next request
+type expiredBody struct {
+	io.ReadCloser
+	conn net.Conn
+}
+
+// Close closes the body, then the connection
+func (b *expiredBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.conn.Close() // nolint
+	return err
+}
+
+// openedAt returns when our dialer opened a connection, looking
+// inside tls ones
+func openedAt(conn net.Conn) time.Time {
+	if tc, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		conn = tc.NetConn()
+	}
+	if cc, ok := conn.(*countedConn); ok {
+		return cc.opened
+	}
+	return time.Now()
+}
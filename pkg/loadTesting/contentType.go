@@ -0,0 +1,85 @@
+package loadTesting
+
+// ExpectContentType catches soft failures, where a misconfigured proxy
+// or an error page returns a 200 with HTML instead of the JSON or
+// binary asked for. A successful REST GET whose Content-Type doesn't
+// match is logged and reported as a 406, so it counts as an error.
+// The type can depend on the path, with ExpectContentTypes, checked
+// in sorted order of their patterns, like the path weights.
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// contentTypeRule is a compiled path pattern and the type it expects
+type contentTypeRule struct {
+	re          *regexp.Regexp
+	contentType string
+}
+
+// compileContentTypes compiles the patterns, in sorted order
+func compileContentTypes(types map[string]string) ([]contentTypeRule, error) {
+	var compiled []contentTypeRule
+
+	patterns := make([]string, 0, len(types))
+	for p := range types {
+		patterns = append(patterns, p)
+	}
+	sort.Strings(patterns)
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("content type pattern %q is not a regular expression, %v", p, err)
+		}
+		compiled = append(compiled, contentTypeRule{re: re, contentType: types[p]})
+	}
+	return compiled, nil
+}
+
+// expectedContentType returns the type a path's GETs should return,
+// or "" if any will do
+func (lt *Runner) expectedContentType(path string) string {
+	for _, r := range lt.contentTypes {
+		if r.re.MatchString(path) {
+			return r.contentType
+		}
+	}
+	return lt.conf.ExpectContentType
+}
+
+// checkContentType returns the code to report a response as: its own,
+// or 406 if it succeeded with the wrong type
+func (lt *Runner) checkContentType(path string, resp *http.Response) int {
+	rc := resp.StatusCode
+	if rc < 200 || rc >= 300 || rc == http.StatusNoContent {
+		return rc
+	}
+	expected := lt.expectedContentType(path)
+	if expected == "" {
+		return rc
+	}
+	got := resp.Header.Get("Content-Type")
+	if !contentTypeMatches(expected, got) {
+		lt.warnf("GET %s returned %d with content type %q, expected %q\n", path, rc, got, expected)
+		return http.StatusNotAcceptable
+	}
+	return rc
+}
+
+// contentTypeMatches is true if a Content-Type is of the expected media
+// type, whatever its parameters, or of the expected family, eg image/*
+func contentTypeMatches(expected, got string) bool {
+	mediaType, _, err := mime.ParseMediaType(got)
+	if err != nil {
+		return false
+	}
+	if strings.HasSuffix(expected, "/*") {
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(expected, "*"))
+	}
+	return strings.EqualFold(mediaType, expected)
+}
@@ -0,0 +1,148 @@
+package loadTesting
+
+// Directives are comments in the input that change the run as it's
+// played, so one script can describe a multi-phase test, eg
+//	#set tps 500
+//	#set protocol s3
+// A directive takes effect when a worker reaches it, after the records
+// before it have been sent. Other comments are ignored, as before.
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const directivePrefix = "#set "
+const directiveMarker = "set" // a directive's first field, once uncommented
+
+// protocolNames are the protocols a directive can switch to
+var protocolNames = map[string]int{
+	"rest":      RESTProtocol,
+	"s3":        S3Protocol,
+	"gcs":       GCSProtocol,
+	"azure":     AzureBlobProtocol,
+	"grpc":      GRPCProtocol,
+	"websocket": WebSocketProtocol,
+}
+
+// directiveReader uncomments directives, so the csv reader returns
+// them as records, in order, instead of skipping them
+type directiveReader struct {
+	r           *bufio.Reader
+	pending     []byte
+	atLineStart bool
+}
+
+// newDirectiveReader wraps the input
+func newDirectiveReader(r io.Reader) *directiveReader {
+	return &directiveReader{r: bufio.NewReader(r), atLineStart: true}
+}
+
+// Read returns the input a line at a time, without the # of directives.
+// A tailed input may return part of a line, and the rest later.
+func (d *directiveReader) Read(p []byte) (int, error) {
+	if len(d.pending) == 0 {
+		line, err := d.r.ReadBytes('\n')
+		if len(line) == 0 {
+			return 0, err
+		}
+		if d.atLineStart && bytes.HasPrefix(line, []byte(directivePrefix)) {
+			line = line[1:]
+		}
+		d.atLineStart = line[len(line)-1] == '\n'
+		d.pending = line
+	}
+	n := copy(p, d.pending)
+	d.pending = d.pending[n:]
+	return n, nil
+}
+
+// isDirective is true if a record is an uncommented directive
+func isDirective(record []string) bool {
+	return len(record) > 0 && record[0] == directiveMarker
+}
+
+// applyDirective changes the run as a directive says. Bad ones are
+// logged and ignored, like ill-formed records.
+func (lt *Runner) applyDirective(record []string, pipe chan []string) {
+	directive := strings.Join(record, " ")
+	if len(record) != 3 {
+		lt.warnf("ill-formed directive %q ignored\n", directive)
+		return
+	}
+	switch record[1] {
+	case "tps":
+		rate, err := strconv.Atoi(record[2])
+		if err != nil || rate <= 0 {
+			lt.warnf("directive %q needs a positive rate, ignored\n", directive)
+			return
+		}
+		lt.setRate(rate, pipe)
+	case "protocol":
+		proto, present := protocolNames[strings.ToLower(record[2])]
+		if !present {
+			lt.warnf("directive %q names an unknown protocol, ignored\n", directive)
+			return
+		}
+		op, err := lt.newOperation(proto, lt.baseURL)
+		if err != nil {
+			lt.fail(err)
+			return
+		}
+		lt.setOperation(op)
+	default:
+		lt.warnf("unknown directive %q ignored\n", directive)
+		return
+	}
+	lt.infof("applied directive %q\n", directive)
+}
+
+// setRate changes the offered rate, starting or retiring workers to
+// match. The scheduler and the pool's pacer just follow the rate.
+func (lt *Runner) setRate(rate int, pipe chan []string) {
+	old := int(atomic.SwapInt64(&lt.offeredRate, int64(rate)))
+	lt.outf("#TPS=%d\n", rate)
+	if lt.paced() {
+		return
+	}
+	for i := old; i < rate; i++ {
+		go lt.worker(pipe)
+	}
+	if rate < old {
+		atomic.AddInt64(&lt.retiring, int64(old-rate))
+	}
+}
+
+// retire is true if a worker should stop, as the rate has been lowered
+func (lt *Runner) retire() bool {
+	for {
+		n := atomic.LoadInt64(&lt.retiring)
+		if n <= 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&lt.retiring, n, n-1) {
+			return true
+		}
+	}
+}
+
+// setOperation switches the workers to a new protocol's operations
+func (lt *Runner) setOperation(op operation) {
+	lt.opLock.Lock()
+	defer lt.opLock.Unlock()
+	lt.op = op
+	atomic.AddInt64(&lt.opGeneration, 1)
+}
+
+// latestOp returns op, or if the protocol has changed since gen, the
+// new protocol's operations and generation
+func (lt *Runner) latestOp(op operation, gen int64) (operation, int64) {
+	if atomic.LoadInt64(&lt.opGeneration) == gen {
+		return op, gen
+	}
+	return lt.workerOp()
+}
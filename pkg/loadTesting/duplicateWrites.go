@@ -0,0 +1,91 @@
+package loadTesting
+
+// DuplicateWrites tests a server's handling of idempotency keys, by
+// sending each REST PUT and POST twice, with the same key in the
+// IdempotencyHeader, and comparing the responses. An idempotent server
+// treats the second as a no-op, giving the same response again, or
+// refusing it with a 409 Conflict. A different response, eg a 201 and
+// then another 201 with a different body, suggests it wrote twice. The
+// second is sent as soon as the first has finished, from the same
+// worker, and isn't counted or logged as a request of its own.
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+)
+
+const defaultIdempotencyHeader = "Idempotency-Key"
+
+// Duplicate outcomes
+const (
+	duplicateSame    = iota // the same code and body, as for the original
+	duplicateRefused        // a 409 Conflict
+	duplicateDiffers        // something else, so it may have written twice
+	duplicateFailed         // no response
+)
+
+// addIdempotencyKey gives a write a new key, and makes its body
+// readable again, for the duplicate
+func (p *RestProto) addIdempotencyKey(req *http.Request, field string) {
+	var key [16]byte
+
+	rand.Read(key[:]) // nolint
+	header := p.conf.IdempotencyHeader
+	if header == "" {
+		header = defaultIdempotencyHeader
+	}
+	req.Header.Set(header, hex.EncodeToString(key[:]))
+	req.GetBody = p.bodyGetter(field)
+}
+
+// sendDuplicate sends a write again, with the same idempotency key,
+// and counts how its response compares with the original's
+func (p *RestProto) sendDuplicate(req *http.Request, rc int, contents []byte) {
+	dup := req.Clone(p.ctx)
+	if req.GetBody != nil {
+		var err error
+		if dup.Body, err = req.GetBody(); err != nil {
+			p.warnf("duplicate %s %s couldn't be sent, %v\n", req.Method, req.URL, err)
+			p.results.addDuplicate(duplicateFailed)
+			return
+		}
+	}
+	resp, err := p.do(dup)
+	if err != nil {
+		p.warnf("duplicate %s %s got no response, %v\n", req.Method, req.URL, err)
+		p.results.addDuplicate(duplicateFailed)
+		return
+	}
+	defer resp.Body.Close() // nolint
+	body, err := ioutil.ReadAll(resp.Body)
+	switch {
+	case err != nil:
+		p.results.addDuplicate(duplicateFailed)
+	case resp.StatusCode == rc && bytes.Equal(body, contents):
+		p.results.addDuplicate(duplicateSame)
+	case resp.StatusCode == http.StatusConflict:
+		p.results.addDuplicate(duplicateRefused)
+	default:
+		p.warnf("duplicate %s %s returned %d, after %d, so may have written twice\n",
+			req.Method, req.URL, resp.StatusCode, rc)
+		p.results.addDuplicate(duplicateDiffers)
+	}
+}
+
+// reportDuplicates tells how the server answered the duplicate writes
+func (lt *Runner) reportDuplicates(r Results) {
+	n := r.DuplicatesSame + r.DuplicatesRefused + r.DuplicatesDiffer + r.DuplicatesFailed
+	if n == 0 {
+		return
+	}
+	lt.infof("%d writes were sent twice: %d got the same response again, %d a 409, "+
+		"%d a different response, and %d none\n",
+		n, r.DuplicatesSame, r.DuplicatesRefused, r.DuplicatesDiffer, r.DuplicatesFailed)
+	if r.DuplicatesDiffer > 0 {
+		lt.warnf("%d duplicate writes got a different response, so the server may not be idempotent\n",
+			r.DuplicatesDiffer)
+	}
+}
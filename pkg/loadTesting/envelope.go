@@ -0,0 +1,63 @@
+package loadTesting
+
+// Envelopes time each REST request out at LatencyTimeoutFactor times
+// the latency it had in the trace, rather than at one RequestTimeout
+// for all of them, so a request that has become ten times slower fails
+// even if it's still quicker than the slowest. The recorded latency
+// includes the transfer time, and the envelope is at least
+// MinRequestTimeout, so fast requests aren't cut off by a little
+// jitter. Records with no latency, such as from a list of paths,
+// aren't limited. Requests cut off are reported as 504s, and counted.
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const envelopeCode = http.StatusGatewayTimeout // for a request cut off
+
+// envelope is how long a record's request may take, 0 for no limit
+func (lt *Runner) envelope(record []string) time.Duration {
+	latency, err := strconv.ParseFloat(record[latencyField], 64)
+	if err != nil {
+		return 0
+	}
+	transferTime, err := strconv.ParseFloat(record[transferTimeField], 64)
+	if err != nil || latency+transferTime <= 0 {
+		return 0
+	}
+	d := time.Duration(lt.conf.LatencyTimeoutFactor * (latency + transferTime) * float64(time.Second))
+	if d < lt.conf.MinRequestTimeout {
+		d = lt.conf.MinRequestTimeout
+	}
+	return d
+}
+
+// withTimeout returns a copy of p whose requests time out after d
+func (p *RestProto) withTimeout(d time.Duration) *RestProto {
+	c := *p
+	c.timeout = d
+	return &c
+}
+
+// withEnvelope returns a request that's cancelled at p's timeout, if
+// it has one, and the function to release it
+func (p *RestProto) withEnvelope(req *http.Request) (*http.Request, context.CancelFunc) {
+	if p.timeout == 0 {
+		return req, func() {}
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), p.timeout)
+	return req.WithContext(ctx), cancel
+}
+
+// outsideEnvelope is true, and counts it, if a request failed as it
+// was cut off at its timeout
+func (p *RestProto) outsideEnvelope(req *http.Request, err error) bool {
+	if err == nil || p.timeout == 0 || req.Context().Err() != context.DeadlineExceeded {
+		return false
+	}
+	p.results.addOverEnvelope()
+	return true
+}
@@ -0,0 +1,21 @@
+package loadTesting
+
+// Errors Run returns wrap one of these, so a program, such as a CI
+// pipeline, can tell with errors.Is a test that failed from one that
+// couldn't run.
+
+import (
+	"errors"
+)
+
+var (
+	// ErrSLA is a run whose results failed a check, such as on latency
+	ErrSLA = errors.New("service level breached")
+	// ErrTooManyErrors is a run stopped by failed requests, with FailFast
+	ErrTooManyErrors = errors.New("too many errors")
+	// ErrConfig is a run that couldn't start, as it was misconfigured,
+	// or was stopped by input it couldn't use
+	ErrConfig = errors.New("configuration error")
+	// ErrUnreachable is a target that couldn't be reached, or stopped answering
+	ErrUnreachable = errors.New("target unreachable")
+)
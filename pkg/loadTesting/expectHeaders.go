@@ -0,0 +1,53 @@
+package loadTesting
+
+// ExpectHeaders checks that successful REST GETs carry the response
+// headers they should, eg X-Cache: HIT from a CDN, a Cache-Control
+// value, or a routing header saying which backend answered, which a
+// 200 alone can't confirm. A value of "*" only requires the header to
+// be there. Each response that lacks one, or has the wrong value, is
+// logged and counted, and the count is reported at the end.
+
+import (
+	"net/http"
+	"sort"
+)
+
+// anyHeaderValue in ExpectHeaders matches any value, if the header is there
+const anyHeaderValue = "*"
+
+// checkHeaders counts and logs a successful GET without its expected headers
+func (lt *Runner) checkHeaders(path string, resp *http.Response) {
+	rc := resp.StatusCode
+	if len(lt.conf.ExpectHeaders) == 0 || rc < 200 || rc >= 300 {
+		return
+	}
+	names := make([]string, 0, len(lt.conf.ExpectHeaders))
+	for name := range lt.conf.ExpectHeaders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		expected := lt.conf.ExpectHeaders[name]
+		values, present := resp.Header[http.CanonicalHeaderKey(name)]
+		switch {
+		case !present:
+			lt.warnf("GET %s returned %d without a %s header\n", path, rc, name)
+		case expected != anyHeaderValue && !headerHas(values, expected):
+			lt.warnf("GET %s returned %d with %s %q, expected %q\n", path, rc, name, values, expected)
+		default:
+			continue
+		}
+		lt.results.addHeaderMismatch()
+		return
+	}
+}
+
+// headerHas is true if one of a header's values is the expected one
+func headerHas(values []string, expected string) bool {
+	for _, v := range values {
+		if v == expected {
+			return true
+		}
+	}
+	return false
+}
@@ -3,7 +3,6 @@ package loadTesting
 import (
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"path"
 	"time"
@@ -33,47 +32,50 @@ func (p FilesystemProto) Put(path, size, oldRC string) error {
 }
 
 // TimedCreateFilesystemFile is for local (non-Protocol) file creation
-func TimedCreateFilesystemFile(fullPath string, size int64) error {
+func (lt *Runner) TimedCreateFilesystemFile(fullPath string, size int64) error {
 	initial := time.Now() //               Response time starts
-	mustCreateFilesystemFile(fullPath, size)
+	if err := lt.createFilesystemFile(fullPath, size); err != nil {
+		return err
+	}
 	responseTime := time.Since(initial) // Response time ends
 	//fmt.Printf("%s %f 0 0 %d %s 201 PUT\n",
 	//	initial.Format("2006-01-02 15:04:05.000"),
 	//	responseTime.Seconds(), size, fullPath)
 	// FIXME: 200 OK or 201 Created?
-	reportPerformance(initial, responseTime, 0, nil, fullPath, 201, "")
-
-	alive <- true
+	lt.reportPerformance(initial, responseTime, 0, nil, fullPath, 201, "")
 	return nil
 
 }
 
-// mustCreateFilesystemFile implements making the file in a filesystem relative to the current directory
+// createFilesystemFile implements making the file in a filesystem relative to the current directory
 // It's used by both local and s3.
-func mustCreateFilesystemFile(fullPath string, size int64) {
-	if conf.Debug {
-		log.Printf("in createFilesystemFile(%s, %d)\n", fullPath, size)
+func (lt *Runner) createFilesystemFile(fullPath string, size int64) error {
+	if lt.conf.Debug {
+		lt.debugf("in createFilesystemFile(%s, %d)\n", fullPath, size)
 	}
 	dir := path.Dir(fullPath)
 	err := os.MkdirAll(dir, os.ModePerm)
 	if err != nil {
-		log.Fatalf("could not create directories of %q, %v", fullPath, err)
+		return fmt.Errorf("could not create directories of %q, %v", fullPath, err)
 	}
 	out, err := os.Create(fullPath)
 	if err != nil {
-		log.Fatalf("could not create file %q, %v", fullPath, err)
+		return fmt.Errorf("could not create file %q, %v", fullPath, err)
 	}
 	in, err := os.Open("/dev/urandom")
 	if err != nil {
-		log.Fatalf("could not open /dev/urandom, %v", err)
+		out.Close() // nolint
+		return fmt.Errorf("could not open /dev/urandom, %v", err)
 	}
 	defer in.Close() // nolint
 	_, err = io.CopyN(out, in, size)
 	if err != nil {
-		log.Fatalf("could not close %q, %v", fullPath, err)
+		out.Close() // nolint
+		return fmt.Errorf("could not write %q, %v", fullPath, err)
 	}
 	err = out.Close()
 	if err != nil {
-		log.Fatalf("error closing %q, %v", fullPath, err)
+		return fmt.Errorf("error closing %q, %v", fullPath, err)
 	}
+	return nil
 }
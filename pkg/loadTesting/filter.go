@@ -0,0 +1,45 @@
+package loadTesting
+
+// Filter drops records whose paths we don't want to replay, such as
+// health checks and static assets, so the load goes to the endpoints
+// we care about without having to edit the input. Operations drops
+// those with other methods, eg to replay only the reads of a trace.
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// compilePattern compiles a pattern, or returns nil if there isn't one
+func compilePattern(name, pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%s pattern %q is not a regular expression, %v", name, pattern, err)
+	}
+	return re, nil
+}
+
+// excluded is true if a path isn't to be sent
+func (lt *Runner) excluded(path string) bool {
+	if lt.include != nil && !lt.include.MatchString(path) {
+		return true
+	}
+	return lt.exclude != nil && lt.exclude.MatchString(path)
+}
+
+// unwanted is true if a record's method isn't one of the Operations
+func (lt *Runner) unwanted(method string) bool {
+	if len(lt.conf.Operations) == 0 {
+		return false
+	}
+	for _, op := range lt.conf.Operations {
+		if strings.EqualFold(op, method) {
+			return false
+		}
+	}
+	return true
+}
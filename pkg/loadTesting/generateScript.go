@@ -0,0 +1,147 @@
+package loadTesting
+
+// Generating a script makes a synthetic perf-format load file, for
+// getting started without a trace from production, and for fixtures.
+// It has TPS records a second for Duration, each a random choice of
+// path and method, by their weights, with a size drawn from the
+// SizeDistribution. As with a list of paths, the latencies and return
+// codes are zeroes, as there's nothing yet to compare them with.
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// SizeDistributions: how a generated record's size is drawn
+const (
+	ConstantSizes    = ""            // always the MeanSize
+	UniformSizes     = "uniform"     // from zero to twice the mean
+	ExponentialSizes = "exponential" // mostly small, some large
+)
+
+// ScriptOpts describes a script to generate
+type ScriptOpts struct {
+	Paths   []string           // chosen from at random, eg "index.html"
+	Methods map[string]float64 // the mix of methods, by weight, eg GET: 9, PUT: 1, nil for all GETs
+
+	MeanSize         int64  // of the objects GET and written, in bytes
+	SizeDistribution string // ConstantSizes, UniformSizes or ExponentialSizes
+
+	Duration time.Duration // how long the script lasts,
+	TPS      int           // at this many records a second
+	Start    time.Time     // the time of the first, zero for now
+	Seed     int64         // for the choices, so the same options make the same script
+}
+
+// GenerateScript writes a synthetic perf-format script
+func GenerateScript(w io.Writer, opts ScriptOpts) error {
+	methods, weights, err := checkScriptOpts(opts)
+	if err != nil {
+		return err
+	}
+	random := rand.New(rand.NewSource(opts.Seed))
+	start := opts.Start
+	if start.IsZero() {
+		start = time.Now()
+	}
+	if _, err := io.WriteString(w, "#yyy-mm-dd hh:mm:ss latency xfertime thinktime bytes url rc op\n"); err != nil {
+		return err
+	}
+	// use the same separator as the reader, so it can quote awkward paths
+	cw := csv.NewWriter(w)
+	cw.Comma = ' '
+	n := int(opts.Duration.Seconds() * float64(opts.TPS))
+	for i := 0; i < n; i++ {
+		at := start.Add(time.Duration(i) * time.Second / time.Duration(opts.TPS))
+		method := pickMethod(methods, weights, random.Float64())
+		size := "0"
+		if method == "GET" || method == "PUT" || method == "POST" {
+			size = strconv.FormatInt(scriptSize(opts, random), 10)
+		}
+		err := cw.Write([]string{
+			at.Format("2006-01-02"),
+			at.Format("15:04:05.000"),
+			"0", "0", "0",
+			size,
+			opts.Paths[random.Intn(len(opts.Paths))],
+			"0",
+			method,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// checkScriptOpts returns the methods, in order, and their cumulative
+// weights, or an error if the script can't be generated
+func checkScriptOpts(opts ScriptOpts) ([]string, []float64, error) {
+	switch {
+	case len(opts.Paths) == 0:
+		return nil, nil, fmt.Errorf("a script needs one or more paths")
+	case opts.TPS <= 0 || opts.Duration <= 0:
+		return nil, nil, fmt.Errorf("a script needs a TPS and a duration, not %d and %s", opts.TPS, opts.Duration)
+	case opts.MeanSize < 0:
+		return nil, nil, fmt.Errorf("a negative mean size (%d) is meaningless", opts.MeanSize)
+	}
+	switch opts.SizeDistribution {
+	case ConstantSizes, UniformSizes, ExponentialSizes:
+	default:
+		return nil, nil, fmt.Errorf("size distribution %q is not %s or %s, or empty for constant",
+			opts.SizeDistribution, UniformSizes, ExponentialSizes)
+	}
+	if len(opts.Methods) == 0 {
+		return []string{"GET"}, []float64{1}, nil
+	}
+	var methods []string
+	for method := range opts.Methods {
+		methods = append(methods, method)
+	}
+	// sorted, so the same seed makes the same choices
+	sort.Strings(methods)
+	var weights []float64
+	var total float64
+	for _, method := range methods {
+		if opts.Methods[method] < 0 {
+			return nil, nil, fmt.Errorf("method %s has a negative weight, %g", method, opts.Methods[method])
+		}
+		total += opts.Methods[method]
+		weights = append(weights, total)
+	}
+	if total == 0 {
+		return nil, nil, fmt.Errorf("the methods' weights add up to zero")
+	}
+	for i := range weights {
+		weights[i] /= total
+	}
+	return methods, weights, nil
+}
+
+// pickMethod returns the method that x, from 0 to 1, falls in
+func pickMethod(methods []string, weights []float64, x float64) string {
+	for i, w := range weights {
+		if x < w {
+			return methods[i]
+		}
+	}
+	return methods[len(methods)-1]
+}
+
+// scriptSize draws a record's size
+func scriptSize(opts ScriptOpts, random *rand.Rand) int64 {
+	mean := float64(opts.MeanSize)
+	switch opts.SizeDistribution {
+	case UniformSizes:
+		return int64(2 * mean * random.Float64())
+	case ExponentialSizes:
+		return int64(mean * random.ExpFloat64())
+	}
+	return opts.MeanSize
+}
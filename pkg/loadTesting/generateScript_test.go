@@ -0,0 +1,57 @@
+package loadTesting
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestGenerateScriptParses sees if a generated script is valid input
+func TestGenerateScriptParses(t *testing.T) {
+	opts := ScriptOpts{
+		Paths:            []string{"index.html", "a path with spaces", "img/logo.png"},
+		Methods:          map[string]float64{"GET": 8, "PUT": 1, "DELETE": 1},
+		MeanSize:         4096,
+		SizeDistribution: ExponentialSizes,
+		Duration:         10 * time.Second,
+		TPS:              5,
+		Start:            time.Date(2017, 12, 10, 16, 39, 8, 0, time.UTC),
+		Seed:             42,
+	}
+	name := filepath.Join(t.TempDir(), "generated.csv")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatalf("could not create %s, %v", name, err)
+	}
+	if err = GenerateScript(f, opts); err != nil {
+		t.Fatalf("could not generate a script, %v", err)
+	}
+	if _, err = f.Seek(0, 0); err != nil {
+		t.Fatalf("could not rewind %s, %v", name, err)
+	}
+	defer f.Close() // nolint
+
+	expected := 50
+	pipe := make(chan []string, expected+1)
+	n := NewRunner(Config{StrictInput: true}).copyToPipe(expected+1, newPerfReader(f), name, pipe, nil)
+	close(pipe)
+	if n != expected {
+		t.Fatalf("read %d records, expected %d", n, expected)
+	}
+	i := 0
+	for record := range pipe {
+		err := checkRecord(record)
+		switch {
+		case err != nil:
+			t.Errorf("record %d, %q, doesn't parse, %v", i, record, err)
+		case opts.Methods[record[operatorField]] == 0:
+			t.Errorf("record %d has method %q, not one of the mix", i, record[operatorField])
+		case record[operatorField] == "DELETE" && record[bytesField] != "0":
+			t.Errorf("record %d is a DELETE of %s bytes", i, record[bytesField])
+		case i == 1 && record[timeField] != "16:39:08.200":
+			t.Errorf("record %d is at %s, not a fifth of a second after the first", i, record[timeField])
+		}
+		i++
+	}
+}
@@ -0,0 +1,98 @@
+package loadTesting
+
+// The header of the output documents how it was produced: the version,
+// the rate plan, the target and every setting that isn't the default.
+// It's all comments, so the output is still valid input to another run.
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Version is the load generator's version, set when it's built with
+// -ldflags "-X github.com/davecb/Play-it-Again-Sam/pkg/loadTesting.Version=v1.2.3"
+var Version = "devel"
+
+// secretFields are settings never to print
+var secretFields = map[string]bool{
+	"S3Key":        true,
+	"S3Secret":     true,
+	"AzureConnStr": true,
+	"AzureKey":     true,
+	"ResultsDSN":   true,
+}
+
+// headerFields are settings already in the first lines of the header
+var headerFields = map[string]bool{
+	"Protocol":     true,
+	"StepDuration": true,
+	"RandomSeed":   true,
+	"RunTags":      true,
+}
+
+// printHeader prints the effective configuration as comments
+func (lt *Runner) printHeader(tpsTarget, progressRate, startTps int, baseURL string) {
+	seed := lt.conf.RandomSeed
+	if seed == 0 {
+		seed = randomSeed
+	}
+	lt.outf("#runLoadTest version %s, started %s\n", Version, time.Now().Format(time.RFC3339))
+	lt.outf("#baseURL %s, protocol %s\n", baseURL, protocolName(lt.conf.Protocol))
+	lt.outf("#tps %d, progress %d, start tps %d, step %ds, seed %d\n",
+		tpsTarget, progressRate, startTps, lt.conf.StepDuration, seed)
+	if len(lt.conf.RunTags) > 0 {
+		lt.outf("#tags %s\n", formatTags(lt.conf.RunTags, false))
+	}
+	for _, setting := range lt.conf.settings() {
+		lt.outf("#config %s\n", setting)
+	}
+}
+
+// settings returns the settings that aren't the default, as
+// name=value, in order, with secrets and header values redacted
+func (c Config) settings() []string {
+	var settings []string
+
+	v := reflect.ValueOf(c)
+	for i := 0; i < v.NumField(); i++ {
+		name, field := v.Type().Field(i).Name, v.Field(i)
+		switch {
+		case field.IsZero() || headerFields[name]:
+			continue
+		case (field.Kind() == reflect.Map || field.Kind() == reflect.Slice) && field.Len() == 0:
+			continue
+		case field.Kind() == reflect.Func || field.Kind() == reflect.Interface || field.Kind() == reflect.Ptr:
+			// callbacks, writers and loggers have no useful value to print
+			settings = append(settings, name+"=set")
+		case secretFields[name]:
+			settings = append(settings, name+"=redacted")
+		case name == "HeaderMap" || name == "ExtraQuery":
+			// the values may well be credentials, eg api keys
+			var keys []string
+			for _, key := range field.MapKeys() {
+				keys = append(keys, key.String())
+			}
+			sort.Strings(keys)
+			settings = append(settings, fmt.Sprintf("%s=%v (values redacted)", name, keys))
+		case name == "Timeout":
+			// which is in seconds
+			settings = append(settings, fmt.Sprintf("%s=%ds", name, c.Timeout))
+		default:
+			settings = append(settings, fmt.Sprintf("%s=%v", name, field.Interface()))
+		}
+	}
+	sort.Strings(settings)
+	return settings
+}
+
+// protocolName returns the name a directive would use for a protocol
+func protocolName(protocol int) string {
+	for name, p := range protocolNames {
+		if p == protocol {
+			return name
+		}
+	}
+	return fmt.Sprintf("%d", protocol)
+}
@@ -0,0 +1,55 @@
+package loadTesting
+
+// Host templates send each REST request to its own virtual host, for
+// multi-tenant systems that route by name. HostTemplate is expanded for
+// each record: {path:N} is the Nth segment of its path and {field:N}
+// the Nth column of the record, both counting from zero, so with
+// "{path:0}.example.com", /acme/logo.png goes to acme.example.com. A
+// missing segment or column expands to nothing, and the path of an
+// absolute URL is the part after its host. Without a template, the
+// HostHeader is used, if there is one.
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var hostVariable = regexp.MustCompile(`\{(path|field):([0-9]+)\}`)
+
+// checkHostTemplate returns an error if a template has anything in
+// braces that isn't one of its variables
+func checkHostTemplate(template string) error {
+	if strings.ContainsAny(hostVariable.ReplaceAllString(template, ""), "{}") {
+		return fmt.Errorf("host template %q has a variable that isn't {path:N} or {field:N}", template)
+	}
+	return nil
+}
+
+// expandHost expands the HostTemplate for a record
+func (lt *Runner) expandHost(record []string) string {
+	_, path := splitOrigin(record[pathField])
+	path, _ = splitQuery(path)
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	return hostVariable.ReplaceAllStringFunc(lt.conf.HostTemplate, func(v string) string {
+		m := hostVariable.FindStringSubmatch(v)
+		n, err := strconv.Atoi(m[2])
+		values := segments
+		if m[1] == "field" {
+			values = record
+		}
+		if err != nil || n >= len(values) {
+			return ""
+		}
+		return values[n]
+	})
+}
+
+// withHost returns a copy of p that sends its requests to a host
+func (p *RestProto) withHost(host string) *RestProto {
+	c := *p
+	c.host = host
+	return &c
+}
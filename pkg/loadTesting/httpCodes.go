@@ -1,5 +1,7 @@
 package loadTesting
 
+import "strconv"
+
 // The contents of the map follow the func.
 type codeTable struct {
 	descr  string
@@ -12,9 +14,9 @@ type codeTable struct {
 func codeDescr(errorValue int) (string, bool) {
 	val, present := codeMap[errorValue]
 	if !present {
-		return string(errorValue) + " not defined", false
+		return strconv.Itoa(errorValue) + " not defined", false
 	}
-	return string(errorValue) + " " + val.descr, val.create
+	return strconv.Itoa(errorValue) + " " + val.descr, val.create
 }
 
 var codeMap = map[int]codeTable{
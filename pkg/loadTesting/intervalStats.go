@@ -0,0 +1,284 @@
+package loadTesting
+
+// Interval stats are a CSV file with a row of aggregates for every
+// ProgressInterval, the format people paste into spreadsheets and
+// reports, instead of something they have to parse out of the log.
+// The percentiles are of the requests in that interval alone. The
+// same intervals are kept in Results.Intervals, and summarized as a
+// trend, to catch a target that slowly degrades, as with a leak.
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	minTrendIntervals = 8   // to say how the run changed, and
+	trendRatio        = 1.5 // by how much, before it's worth saying
+)
+
+// IntervalColumns are the columns an interval stats file can have
+var IntervalColumns = []string{"timestamp", "requests", "tps", "errors", "error_rate", "p50", "p90", "p99"}
+
+// defaultIntervalColumns are the ones it has if none are configured
+var defaultIntervalColumns = []string{"timestamp", "tps", "error_rate", "p50", "p99"}
+
+// intervalWriter writes a row of stats every interval, until closed
+type intervalWriter struct {
+	f       *os.File
+	w       *csv.Writer
+	columns []string
+	done    chan bool
+	stopped chan bool
+	logger
+}
+
+// interval is the difference between two samples of the stats
+type interval struct {
+	end     time.Time
+	length  time.Duration
+	errors  int64
+	latency histogram
+}
+
+// IntervalResult is the results of the requests in one interval
+type IntervalResult struct {
+	End       time.Time
+	Requests  int64
+	Errors    int64
+	TPS       float64
+	ErrorRate float64
+	P50       time.Duration
+	P90       time.Duration
+	P99       time.Duration
+}
+
+// intervalSampler turns samples of the stats into intervals
+type intervalSampler struct {
+	s    *stats
+	last interval
+}
+
+// newIntervalSampler starts the first interval now
+func newIntervalSampler(s *stats) *intervalSampler {
+	return &intervalSampler{s: s, last: interval{end: time.Now()}}
+}
+
+// next ends the current interval and starts another
+func (is *intervalSampler) next(now time.Time) interval {
+	errors, latency := is.s.sample()
+	iv := interval{end: now, length: now.Sub(is.last.end), errors: errors - is.last.errors,
+		latency: latency.minus(is.last.latency)}
+	is.last = interval{end: now, errors: errors, latency: latency}
+	return iv
+}
+
+// isIntervalColumn is true if name is one of the IntervalColumns
+func isIntervalColumn(name string) bool {
+	for _, c := range IntervalColumns {
+		if name == c {
+			return true
+		}
+	}
+	return false
+}
+
+// startIntervalStats creates the file, writes the column names
+// and starts writing rows
+func (lt *Runner) startIntervalStats(name string, every time.Duration) (*intervalWriter, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not create interval stats file %q, %v", name, err)
+	}
+	iw := &intervalWriter{
+		f:       f,
+		w:       csv.NewWriter(f),
+		columns: lt.conf.IntervalColumns,
+		done:    make(chan bool),
+		stopped: make(chan bool),
+		logger:  lt.logger,
+	}
+	if len(iw.columns) == 0 {
+		iw.columns = defaultIntervalColumns
+	}
+	iw.write(iw.columns)
+	go iw.run(lt.results, every)
+	return iw, nil
+}
+
+// run writes a row each interval, and a last one for whatever's left
+// of the run when it's closed
+func (iw *intervalWriter) run(s *stats, every time.Duration) {
+	defer close(iw.stopped)
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	sampler := newIntervalSampler(s)
+	for {
+		select {
+		case now := <-ticker.C:
+			iw.write(sampler.next(now).row(iw.columns))
+		case <-iw.done:
+			if iv := sampler.next(time.Now()); iv.latency.n > 0 {
+				iw.write(iv.row(iw.columns))
+			}
+			return
+		}
+	}
+}
+
+// write writes a row, and flushes it, so the file can be watched
+func (iw *intervalWriter) write(row []string) {
+	iw.w.Write(row) // nolint
+	iw.w.Flush()
+	if err := iw.w.Error(); err != nil {
+		iw.warnf("error writing interval stats, %v\n", err)
+	}
+}
+
+// close writes the last row and closes the file
+func (iw *intervalWriter) close() {
+	close(iw.done)
+	<-iw.stopped
+	if err := iw.f.Close(); err != nil {
+		iw.warnf("error closing interval stats file, %v\n", err)
+	}
+}
+
+// startIntervals keeps the results of every interval, for
+// Results.Intervals, until the function it returns is called
+func (lt *Runner) startIntervals(every time.Duration) func() {
+	done, stopped := make(chan bool), make(chan bool)
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+
+		sampler := newIntervalSampler(lt.results)
+		for {
+			select {
+			case now := <-ticker.C:
+				lt.results.addInterval(sampler.next(now).result())
+			case <-done:
+				if iv := sampler.next(time.Now()); iv.latency.n > 0 {
+					lt.results.addInterval(iv.result())
+				}
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// result is the interval's results
+func (iv interval) result() IntervalResult {
+	r := IntervalResult{
+		End:      iv.end,
+		Requests: iv.latency.n,
+		Errors:   iv.errors,
+		P50:      iv.latency.percentile(50),
+		P90:      iv.latency.percentile(90),
+		P99:      iv.latency.percentile(99),
+	}
+	if iv.length > 0 {
+		r.TPS = float64(r.Requests) / iv.length.Seconds()
+	}
+	if r.Requests > 0 {
+		r.ErrorRate = float64(r.Errors) / float64(r.Requests)
+	}
+	return r
+}
+
+// trends compares the start of the run to its end, the first and last
+// quarters of the intervals, and describes whatever changed by at
+// least trendRatio, eg "p99 rose 3.0x over the run"
+func trends(intervals []IntervalResult) []string {
+	if len(intervals) < minTrendIntervals {
+		return nil
+	}
+	quarter := len(intervals) / 4
+	first, last := meanOf(intervals[:quarter]), meanOf(intervals[len(intervals)-quarter:])
+
+	var lines []string
+	for _, m := range []struct {
+		name     string
+		from, to float64
+		unit     string
+	}{
+		{"p99", first.P99.Seconds(), last.P99.Seconds(), " s"},
+		{"TPS", first.TPS, last.TPS, ""},
+		{"error rate", 100 * first.ErrorRate, 100 * last.ErrorRate, "%"},
+	} {
+		switch {
+		case m.from > 0 && m.to >= m.from*trendRatio:
+			lines = append(lines, fmt.Sprintf("%s rose %.1fx over the run, from %.6g%s to %.6g%s",
+				m.name, m.to/m.from, m.from, m.unit, m.to, m.unit))
+		case m.to > 0 && m.from >= m.to*trendRatio:
+			lines = append(lines, fmt.Sprintf("%s fell %.1fx over the run, from %.6g%s to %.6g%s",
+				m.name, m.from/m.to, m.from, m.unit, m.to, m.unit))
+		}
+	}
+	return lines
+}
+
+// meanOf averages the TPS, error rate and p99 of some intervals
+func meanOf(intervals []IntervalResult) IntervalResult {
+	var m IntervalResult
+	var p99 time.Duration
+
+	for _, r := range intervals {
+		m.TPS += r.TPS
+		m.ErrorRate += r.ErrorRate
+		p99 += r.P99
+	}
+	n := float64(len(intervals))
+	m.TPS /= n
+	m.ErrorRate /= n
+	m.P99 = time.Duration(float64(p99) / n)
+	return m
+}
+
+// row formats the interval's columns, with times in seconds
+func (iv interval) row(columns []string) []string {
+	row := make([]string, len(columns))
+	for i, c := range columns {
+		row[i] = iv.column(c)
+	}
+	return row
+}
+
+// column formats one column
+func (iv interval) column(name string) string {
+	n := iv.latency.n
+	switch name {
+	case "timestamp":
+		return iv.end.Format(time.RFC3339)
+	case "requests":
+		return strconv.FormatInt(n, 10)
+	case "tps":
+		if iv.length <= 0 {
+			return "0.0"
+		}
+		return fmt.Sprintf("%.1f", float64(n)/iv.length.Seconds())
+	case "errors":
+		return strconv.FormatInt(iv.errors, 10)
+	case "error_rate":
+		if n == 0 {
+			return "0.0000"
+		}
+		return fmt.Sprintf("%.4f", float64(iv.errors)/float64(n))
+	case "p50":
+		return fmt.Sprintf("%.6f", iv.latency.percentile(50).Seconds())
+	case "p90":
+		return fmt.Sprintf("%.6f", iv.latency.percentile(90).Seconds())
+	case "p99":
+		return fmt.Sprintf("%.6f", iv.latency.percentile(99).Seconds())
+	}
+	return ""
+}
@@ -0,0 +1,117 @@
+package loadTesting
+
+// Lifecycle testing exercises the whole life of an object, the way an
+// ingestion pipeline does: each input record PUTs a new object under a
+// unique key, GETs it back to check it, then DELETEs it. The three are
+// reported as one LIFECYCLE request, which only succeeds if all do.
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// lifecycler is a protocol that can do lifecycle tests
+type lifecycler interface {
+	Lifecycle(path, size string)
+}
+
+var lifecycleKeys int64 // for unique object keys
+
+// lifecycleKey makes a key no other request in this or another run uses
+func lifecycleKey(path string) string {
+	return fmt.Sprintf("%s.lifecycle.%d.%d", path, os.Getpid(),
+		atomic.AddInt64(&lifecycleKeys, 1))
+}
+
+// Lifecycle PUTs, GETs and DELETEs an object, and times all three.
+// If the GET returns something of the wrong length, it's reported as a
+// 409, conflict, as someone else's object must have been there.
+func (p *RestProto) Lifecycle(path, size string) {
+	var latency, transferTime time.Duration
+
+	if p.conf.Debug {
+		p.debugf("in rest.Lifecycle(%s, %s)\n", path, size)
+	}
+	key := lifecycleKey(path)
+	body, bytes := p.requestBody(size)
+	if body == nil {
+		p.reportWrite("LIFECYCLE", time.Now(), 0, 0, size, key, 411, "") // 411 means "length required"
+		p.alive <- true
+		return
+	}
+	defer body.Close() // nolint
+	size = strconv.FormatInt(bytes, 10)
+
+	initial := time.Now()
+	rc := func() int {
+		rc, got := p.step("PUT", key, body, bytes, &latency, &transferTime)
+		if p.failed(rc, "") {
+			return rc
+		}
+		rc, got = p.step("GET", key, nil, 0, &latency, &transferTime)
+		if p.failed(rc, "") {
+			return rc
+		}
+		if got != bytes {
+			if p.conf.Verbose {
+				p.debugf("lifecycle GET of %s returned %d bytes, not %d\n", key, got, bytes)
+			}
+			return http.StatusConflict
+		}
+		rc, _ = p.step("DELETE", key, nil, 0, &latency, &transferTime)
+		return rc
+	}()
+	if p.abandoned(p.ctx.Err()) {
+		// the run stopped part-way through
+		p.alive <- true
+		return
+	}
+	p.reportWrite("LIFECYCLE", initial, latency, transferTime, size, key, rc, "")
+	p.alive <- true
+}
+
+// step does one request of a lifecycle, adding its times to the
+// totals, and returns its code and the length of the response body
+func (p *RestProto) step(method, key string, body io.Reader, bytes int64,
+	latency, transferTime *time.Duration) (int, int64) {
+
+	req, err := http.NewRequestWithContext(p.ctx, method, p.url(key), body)
+	if err != nil {
+		p.dumpXact(req, nil, nil, p.conf.Crash, "error creating http request", err)
+		return -1, 0
+	}
+	req.ContentLength = bytes
+	p.addHeaders(req)
+
+	initial := time.Now() // Response time starts
+	resp, err := p.do(req)
+	*latency += time.Since(initial) // Response time ends
+	if p.abandoned(err) {
+		return -1, 0
+	}
+	if err != nil {
+		p.dumpXact(req, nil, nil, p.conf.Crash, "error getting http response", err)
+		return errorToCode(err), 0
+	}
+	defer resp.Body.Close() // nolint
+	start := time.Now()
+	n, err := io.Copy(ioutil.Discard, resp.Body)
+	*transferTime += time.Since(start) // Transfer time ends
+	if p.abandoned(err) {
+		return -1, 0
+	}
+	if err != nil {
+		p.dumpXact(req, resp, nil, p.conf.Crash, "error reading http response", err)
+		return 444, n
+	}
+	if p.conf.Verbose {
+		p.debugf("lifecycle %s %s returned %d\n", method, key, resp.StatusCode)
+	}
+	return resp.StatusCode, n
+}
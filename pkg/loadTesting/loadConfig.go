@@ -0,0 +1,167 @@
+package loadTesting
+
+// LoadConfig reads a whole run, the Config and the rest of the
+// arguments to RunLoadTest, from a JSON or YAML file, so that
+// complicated runs can be kept in source control and repeated exactly.
+// The keys are the names of the fields, in any case, and durations
+// can be written as strings, eg
+//	{"Protocol": 1, "ConnectTimeout": "3s", "TPS": 100,
+//	 "Filename": "load.csv", "BaseURL": "http://localhost"}
+// Secrets can be read from the environment, see secrets.go.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// RunParams are the arguments to RunLoadTest that aren't in the Config
+type RunParams struct {
+	Filename string // the load file
+	BaseURL  string
+	From     int // records to skip
+	For      int // records to use, 0 for all of them
+	TPS      int // the target
+	Progress int // the step to increase by, 0 for a steady TPS
+	StartTPS int // where to start the steps, 0 for Progress
+}
+
+// runFile is the layout of the file
+type runFile struct {
+	Config
+	RunParams
+}
+
+// LoadConfig reads a run from a .json, .yaml or .yml file
+func LoadConfig(path string) (Config, RunParams, error) {
+	var fields map[string]interface{}
+
+	// the same defaults as runLoadTest's options
+	run := runFile{Config: Config{Timeout: 10, StepDuration: 10, R: true}}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, RunParams{}, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, &fields)
+	case ".yaml", ".yml":
+		var y interface{}
+		err = yaml.Unmarshal(data, &y)
+		m, ok := fromYAML(y).(map[string]interface{})
+		if err == nil && !ok {
+			err = fmt.Errorf("expected a map of fields")
+		}
+		fields = m
+	default:
+		return Config{}, RunParams{}, fmt.Errorf("%s is not a .json or .yaml file", path)
+	}
+	if err == nil {
+		err = resolveSecrets(fields)
+	}
+	if err == nil {
+		err = parseDurations(fields)
+	}
+	if err == nil {
+		// let encoding/json, which ignores case, fill in the structs
+		data, err = json.Marshal(fields)
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &run)
+	}
+	if err != nil {
+		return Config{}, RunParams{}, fmt.Errorf("can't load %s, %v", path, err)
+	}
+	return run.Config, run.RunParams, nil
+}
+
+// fromYAML turns the maps yaml gives us into ones encoding/json can use
+func fromYAML(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = fromYAML(value)
+		}
+		return m
+	case []interface{}:
+		for i := range v {
+			v[i] = fromYAML(v[i])
+		}
+	}
+	return v
+}
+
+// parseDurations turns durations written as strings into numbers
+func parseDurations(fields map[string]interface{}) error {
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Type != reflect.TypeOf(time.Duration(0)) {
+			continue
+		}
+		for key, value := range fields {
+			s, ok := value.(string)
+			if !ok || !strings.EqualFold(key, f.Name) {
+				continue
+			}
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return fmt.Errorf("%s: %v", key, err)
+			}
+			if f.Name == "Timeout" {
+				// which is in seconds
+				d /= time.Second
+			}
+			fields[key] = int64(d)
+		}
+	}
+	return nil
+}
+
+// RunWithParams runs a test read by LoadConfig, and returns its results
+func RunWithParams(cfg Config, p RunParams) (Results, error) {
+	return RunWithParamsContext(context.Background(), cfg, p)
+}
+
+// RunWithParamsContext is RunWithParams, stopping early if ctx is done
+func RunWithParamsContext(ctx context.Context, cfg Config, p RunParams) (Results, error) {
+	if len(cfg.Scenarios) > 0 && p.Filename == "" {
+		// the scenarios are the input
+		p.Filename = os.DevNull
+	}
+	if p.Filename == "" || p.BaseURL == "" {
+		return Results{}, fmt.Errorf("a run needs both a Filename and a BaseURL")
+	}
+	if p.TPS <= 0 && !cfg.ClosedModel {
+		return Results{}, fmt.Errorf("a run needs a TPS target, or virtual users")
+	}
+	f, err := OpenInput(p.Filename)
+	if err != nil {
+		return Results{}, err
+	}
+	defer f.Close() // nolint
+	if p.For == 0 {
+		p.For = math.MaxInt64
+	}
+	return RunLoadTestContext(ctx, f, p.Filename, p.From, p.For, p.TPS, p.Progress, p.StartTPS,
+		p.BaseURL, cfg)
+}
+
+// OpenInput opens an input file, or for "-", returns stdin, so the
+// input can be piped from a live producer
+func OpenInput(name string) (*os.File, error) {
+	if name == "-" {
+		return os.Stdin, nil
+	}
+	return os.Open(name)
+}
@@ -0,0 +1,92 @@
+package loadTesting
+
+// Logging goes through Config.Logger, a log/slog logger, so a program
+// that uses this package can send it wherever its own logs go, in
+// whatever format and at whatever level it likes. By default it goes
+// through the standard log package, as it always has, with the level
+// added. Debug and verbose messages are at slog.LevelDebug, and are
+// only logged if Config.Debug or Verbose asks for them, as before.
+// The per-request output lines are data, not logs, so are unchanged.
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// logger logs printf-style messages at a level
+type logger struct {
+	slog *slog.Logger
+}
+
+// newLogger returns the configured logger, or the default
+func newLogger(cfg Config) logger {
+	if cfg.Logger != nil {
+		return logger{slog: cfg.Logger}
+	}
+	level := slog.LevelInfo
+	if cfg.Debug || cfg.Verbose {
+		level = slog.LevelDebug
+	}
+	return logger{slog: slog.New(&levelHandler{Handler: slog.Default().Handler(), level: level})}
+}
+
+// levelHandler logs at or above its level, whatever the level of the
+// handler it wraps. The default handler only does Info and above.
+type levelHandler struct {
+	slog.Handler
+	level slog.Level
+}
+
+// Enabled is true at or above h's level
+func (h *levelHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+// WithAttrs keeps the level
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{Handler: h.Handler.WithAttrs(attrs), level: h.level}
+}
+
+// WithGroup keeps the level
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{Handler: h.Handler.WithGroup(name), level: h.level}
+}
+
+// debugf logs a debugging or verbose message
+func (l logger) debugf(format string, args ...interface{}) {
+	l.logf(slog.LevelDebug, format, args...)
+}
+
+// infof logs an ordinary message
+func (l logger) infof(format string, args ...interface{}) {
+	l.logf(slog.LevelInfo, format, args...)
+}
+
+// warnf logs something that went wrong, but not badly enough to stop
+func (l logger) warnf(format string, args ...interface{}) {
+	l.logf(slog.LevelWarn, format, args...)
+}
+
+// fatalf logs an error and exits, like log.Fatalf
+func (l logger) fatalf(format string, args ...interface{}) {
+	l.logf(slog.LevelError, format, args...)
+	os.Exit(1)
+}
+
+// logf logs a message, without the trailing newline the log package
+// wanted, as coming from the caller of debugf, infof and the rest
+func (l logger) logf(level slog.Level, format string, args ...interface{}) {
+	ctx := context.Background()
+	if !l.slog.Enabled(ctx, level) {
+		return
+	}
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:]) // skip Callers, logf and its caller
+	r := slog.NewRecord(time.Now(), level, strings.TrimSuffix(fmt.Sprintf(format, args...), "\n"), pcs[0])
+	_ = l.slog.Handler().Handle(ctx, r)
+}
@@ -6,9 +6,9 @@ package loadTesting
 // input looks like "01-Mar-2017 16:00:00 0 0 0 0 path 200 GET"
 
 import (
+	"context"
 	"encoding/csv"
 	"io"
-	"log"
 	"os"
 	"strconv"
 	"strings"
@@ -16,26 +16,22 @@ import (
 
 // MkLoadTestFiles interprets the time period and decides what to create.
 func MkLoadTestFiles(f *os.File, filename, baseURL string, startFrom, runFor int, cfg Config) {
-	if conf.Debug {
-		log.Printf("in MkLoadTestFiles(f *os.File, filename=%s, baseURL=%s, startFrom=%d, runFor=%d)",
+	lt := NewRunner(cfg)
+	if lt.conf.Debug {
+		lt.debugf("in MkLoadTestFiles(f *os.File, filename=%s, baseURL=%s, startFrom=%d, runFor=%d)",
 			filename, baseURL, startFrom, runFor)
 	}
 
-	conf = cfg
 	//doPrepWork(baseURL)    use op.Init()
-	defer os.Remove(junkDataFile) // nolint FIXME, for write
+	defer os.Remove(lt.junkDataFile) // nolint FIXME, for write
 
-	r := csv.NewReader(f)
-	r.Comma = ' '
-	r.Comment = '#'
-	r.FieldsPerRecord = -1 // ignore differences
-
-	skipForward(startFrom, r, filename)
-	makeFiles(runFor, r, filename, baseURL)
+	r := newPerfReader(f)
+	skipForward(startFrom, r, filename, lt.logger)
+	lt.makeFiles(runFor, r, filename, baseURL)
 }
 
 // skipForward skips over files we don't want to create
-func skipForward(startFrom int, r *csv.Reader, filename string) {
+func skipForward(startFrom int, r *csv.Reader, filename string, l logger) {
 	//skip forward if startFrom is non-zero
 	for i := 0; i < startFrom; i++ {
 		record, err := r.Read()
@@ -43,28 +39,28 @@ func skipForward(startFrom int, r *csv.Reader, filename string) {
 			break
 		}
 		if err != nil {
-			log.Fatalf("Fatal error skipping forward in %s: %s\n", filename, err)
+			l.fatalf("Fatal error skipping forward in %s: %s\n", filename, err)
 		}
-		log.Printf("skipped %s\n", record)
+		l.infof("skipped %s\n", record)
 	}
 }
 
 // makeFiles creates a quantity of files
-func makeFiles(runFor int, r *csv.Reader, filename string, baseURL string) {
+func (lt *Runner) makeFiles(runFor int, r *csv.Reader, filename string, baseURL string) {
 	for i := 0; i < runFor; i++ {
 		record, err := r.Read()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			log.Fatalf("Fatal error mid-way in %s: %s\n", filename, err)
+			lt.fatalf("Fatal error mid-way in %s: %s\n", filename, err)
 		}
-		log.Printf("read %s\n", record)
+		lt.infof("read %s\n", record)
 
 		// record-type logic:
 		if record[pathField] == "/" {
 			// not a valid file
-			log.Print("ignore a request to create the root dir, /\n")
+			lt.infof("ignore a request to create the root dir, /\n")
 			continue
 		}
 		bytes := record[bytesField]
@@ -74,11 +70,11 @@ func makeFiles(runFor int, r *csv.Reader, filename string, baseURL string) {
 		switch operatorValue {
 		case "PUT", "POST":
 			// Don't do files that will be created in the test
-			log.Printf("ignore %s operation on %s\n", operatorValue, path)
+			lt.infof("ignore %s operation on %s\n", operatorValue, path)
 			continue
 		case "DELETE", "DELE":
 			// Right now, create a 1-byte file to cause directory traversals.
-			mkFile(baseURL, filename, path, "1")
+			lt.mkFile(baseURL, filename, path, "1")
 			continue
 		case "GET", "":
 			// Treat as get if there is no operator supplied
@@ -88,29 +84,31 @@ func makeFiles(runFor int, r *csv.Reader, filename string, baseURL string) {
 			}
 			shortDescr, create := codeDescr(rc)
 			if create {
-				log.Printf("%s, create file %s of %s bytes\n", shortDescr, path, bytes)
-				mkFile(baseURL, filename, path, bytes)
+				lt.infof("%s, create file %s of %s bytes\n", shortDescr, path, bytes)
+				lt.mkFile(baseURL, filename, path, bytes)
 			} else {
-				log.Printf("%s, ignore %s\n", shortDescr, path)
+				lt.infof("%s, ignore %s\n", shortDescr, path)
 			}
 		}
 	}
 }
 
 // mkfile creates a single file of specified size or says why not.
-func mkFile(baseURL, sourceFile, fullPath, size string) {
+func (lt *Runner) mkFile(baseURL, sourceFile, fullPath, size string) {
 	var err error
 
-	if conf.Debug {
-		log.Printf("in mkFile(baseURL=%s, sourceFile=%s, fullPath=%s, size=%s", baseURL, sourceFile, fullPath, size)
+	if lt.conf.Debug {
+		lt.debugf("in mkFile(baseURL=%s, sourceFile=%s, fullPath=%s, size=%s", baseURL, sourceFile, fullPath, size)
 	}
 	fileSize, err := strconv.ParseInt(size, 10, 64) // FIXME hoist
 	if err != nil {
-		log.Fatalf("can't get size from %q", size)
+		lt.fatalf("can't get size from %q", size)
 	}
-	switch conf.Protocol {
+	switch lt.conf.Protocol {
 	case FilesystemProtocol: // prepend current directory to path
-		err = TimedCreateFilesystemFile("./"+strings.TrimPrefix(fullPath, "/"), fileSize)
+		err = lt.TimedCreateFilesystemFile("./"+strings.TrimPrefix(fullPath, "/"), fileSize)
+	case GCSProtocol, AzureBlobProtocol:
+		err = lt.seedFile(baseURL, fullPath, fileSize)
 	//case S3Protocol:
 	//	err = AmazonS3Put(baseURL, fullPath, fileSize)
 	//case RESTProtocol:
@@ -118,11 +116,35 @@ func mkFile(baseURL, sourceFile, fullPath, size string) {
 	//case CephProtocol: // Pre-alpha stage
 	//	err = createCephFile(baseURL+fullPath, fileSize)
 	default:
-		log.Fatalf("Unimplemented protocol %d, halting\n", conf.Protocol)
+		lt.fatalf("Unimplemented protocol %d, halting\n", lt.conf.Protocol)
 	}
 	if err != nil {
-		log.Fatalf(`Fatal error mid-way in %s: "%s" while creating %s of size %s\n`,
+		lt.fatalf(`Fatal error mid-way in %s: "%s" while creating %s of size %s\n`,
 			sourceFile, err, fullPath, size)
 	}
 }
 
+// seedFile writes an object with the protocol's Seed, as SeedObjects
+// does before a run, from a junk data file at least as big as it
+func (lt *Runner) seedFile(baseURL, fullPath string, size int64) error {
+	if lt.op == nil {
+		op, err := lt.newOperation(lt.conf.Protocol, baseURL)
+		if err != nil {
+			return err
+		}
+		lt.op = op
+		if err := lt.createFilesystemFile(lt.junkDataFile, size); err != nil {
+			return err
+		}
+		lt.conf.BufSize = size
+	}
+	if size > lt.conf.BufSize {
+		// the data file has to hold the largest of them
+		if err := lt.createFilesystemFile(lt.junkDataFile, size); err != nil {
+			return err
+		}
+		lt.conf.BufSize = size
+	}
+	return lt.op.(seeder).Seed(context.Background(), fullPath, size)
+}
+
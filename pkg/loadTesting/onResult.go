@@ -0,0 +1,93 @@
+package loadTesting
+
+// OnResult lets a program that uses this package see every request
+// as it completes, for its own reporting. Results are passed to it from
+// a single goroutine, in the order they completed, so it needn't be
+// thread-safe. It should be quick, though, or the workers will wait.
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RequestResult is the outcome of a single request
+type RequestResult struct {
+	Start    time.Time
+	Method   string
+	Path     string
+	Status   int
+	Latency  time.Duration // time to the start of the response
+	Transfer time.Duration // time to read the rest of it
+	Bytes    int64
+	Err      error // non-nil if the request failed
+}
+
+// resultHook passes results to Config.OnResult
+type resultHook struct {
+	sync.Mutex
+	ch   chan RequestResult // nil once closed
+	done chan bool
+}
+
+// newResultHook starts the goroutine that calls f
+func newResultHook(f func(RequestResult)) *resultHook {
+	h := &resultHook{
+		ch:   make(chan RequestResult, 1000),
+		done: make(chan bool),
+	}
+	go func() {
+		for r := range h.ch {
+			f(r)
+		}
+		close(h.done)
+	}()
+	return h
+}
+
+// send queues a result for the hook
+func (h *resultHook) send(r RequestResult) {
+	h.Lock()
+	defer h.Unlock()
+	if h.ch == nil {
+		// a straggler finished after we closed
+		return
+	}
+	h.ch <- r
+}
+
+// close waits for the hook to see every result sent so far
+func (h *resultHook) close() {
+	h.Lock()
+	if h.ch != nil {
+		close(h.ch)
+		h.ch = nil
+	}
+	h.Unlock()
+	<-h.done
+}
+
+// notify sends a result to the hook, if there is one
+func (lt *Runner) notify(method string, initial time.Time, latency, transferTime time.Duration,
+	bytes, path string, rc int, failed bool) {
+	if lt.hook == nil {
+		return
+	}
+	var err error
+	if failed {
+		descr, _ := codeDescr(rc)
+		err = errors.New(descr)
+	}
+	n, _ := strconv.ParseInt(bytes, 10, 64)
+	lt.hook.send(RequestResult{
+		Start:    initial,
+		Method:   method,
+		Path:     path,
+		Status:   rc,
+		Latency:  latency,
+		Transfer: transferTime,
+		Bytes:    n,
+		Err:      err,
+	})
+}
@@ -0,0 +1,161 @@
+package loadTesting
+
+// OpenMetrics writes the results of the run at the end, in the
+// OpenMetrics text format Prometheus reads, to OpenMetricsFile. It's
+// for CI runners and other places there's nothing to scrape: a later
+// step can push the file to a gateway, or archive it. The latency is
+// a histogram, with the usual Prometheus buckets, and the phases are
+// summaries of their p50, p90 and p99. The run's tags, if any, are the
+// labels of loadtest_run_info, and each assertion, if any, passed or not.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// openMetricsBuckets are the upper limits of the latency histogram, in seconds
+var openMetricsBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// writeOpenMetrics writes the results of the run to a file
+func (lt *Runner) writeOpenMetrics(name string) {
+	f, err := os.Create(name)
+	if err != nil {
+		lt.warnf("could not create OpenMetrics file %q, %v\n", name, err)
+		return
+	}
+	w := bufio.NewWriter(f)
+	writeOpenMetrics(w, lt.finalResults(), lt.results.latencies())
+	err = w.Flush()
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		lt.warnf("error writing OpenMetrics file %q, %v\n", name, err)
+	}
+}
+
+// writeOpenMetrics writes the metric families, ending with # EOF
+func writeOpenMetrics(w io.Writer, r Results, h histogram) {
+	if len(r.Tags) > 0 {
+		family(w, "loadtest_run", "info", "the run's tags")
+		fmt.Fprintf(w, "loadtest_run_info{%s} 1\n", formatTags(r.Tags, true))
+	}
+	family(w, "loadtest_start_time_seconds", "gauge", "when the run started")
+	fmt.Fprintf(w, "loadtest_start_time_seconds %d\n", r.Start.Unix())
+	family(w, "loadtest_duration_seconds", "gauge", "how long the run took")
+	fmt.Fprintf(w, "loadtest_duration_seconds %g\n", r.Duration.Seconds())
+	family(w, "loadtest_tps", "gauge", "the average rate of completed requests")
+	fmt.Fprintf(w, "loadtest_tps %g\n", r.TPS())
+
+	family(w, "loadtest_requests", "counter", "requests completed")
+	fmt.Fprintf(w, "loadtest_requests_total %d\n", r.Requests)
+	family(w, "loadtest_errors", "counter", "requests that failed")
+	fmt.Fprintf(w, "loadtest_errors_total %d\n", r.Errors)
+	family(w, "loadtest_responses", "counter", "requests, by return code")
+	var codes []int
+	for code := range r.Codes {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "loadtest_responses_total{code=\"%d\"} %d\n", code, r.Codes[code])
+	}
+	family(w, "loadtest_method_requests", "counter", "requests, by method")
+	var methods []string
+	for method := range r.Methods {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	for _, method := range methods {
+		fmt.Fprintf(w, "loadtest_method_requests_total{method=%s} %d\n",
+			strconv.Quote(method), r.Methods[method])
+	}
+
+	family(w, "loadtest_request_duration_seconds", "histogram", "the latency of requests")
+	for _, le := range openMetricsBuckets {
+		fmt.Fprintf(w, "loadtest_request_duration_seconds_bucket{le=\"%g\"} %d\n", le, h.below(le))
+	}
+	fmt.Fprintf(w, "loadtest_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", h.n)
+	fmt.Fprintf(w, "loadtest_request_duration_seconds_count %d\n", h.n)
+
+	family(w, "loadtest_phase_seconds", "summary", "the time REST requests spent in each phase")
+	for i, phase := range []PhaseLatency{r.DNS, r.Connect, r.TLS, r.Server, r.Transfer} {
+		quantiles(w, "loadtest_phase_seconds", "phase", phaseNames[i], phase)
+	}
+	family(w, "loadtest_outcome_seconds", "summary", "the latency of requests, by outcome")
+	quantiles(w, "loadtest_outcome_seconds", "outcome", "succeeded", r.Succeeded)
+	quantiles(w, "loadtest_outcome_seconds", "outcome", "failed", r.Failed)
+	family(w, "loadtest_code_seconds", "summary", "the latency of requests, by return code")
+	for _, code := range codes {
+		quantiles(w, "loadtest_code_seconds", "code", strconv.Itoa(code), r.CodeLatency[code])
+	}
+
+	family(w, "loadtest_connections", "counter", "connections REST requests used, opened or reused")
+	fmt.Fprintf(w, "loadtest_connections_total{reused=\"false\"} %d\n", r.NewConns)
+	fmt.Fprintf(w, "loadtest_connections_total{reused=\"true\"} %d\n", r.ReusedConns)
+	family(w, "loadtest_bytes", "counter", "bytes in the bodies of requests and responses")
+	fmt.Fprintf(w, "loadtest_bytes_total{direction=\"read\"} %d\n", r.BytesRead)
+	fmt.Fprintf(w, "loadtest_bytes_total{direction=\"written\"} %d\n", r.BytesWritten)
+	family(w, "loadtest_max_outstanding", "gauge", "the most requests outstanding at once")
+	fmt.Fprintf(w, "loadtest_max_outstanding %d\n", r.MaxOutstanding)
+	family(w, "loadtest_rejected", "counter", "requests shed, as too many were outstanding")
+	fmt.Fprintf(w, "loadtest_rejected_total %d\n", r.Rejected)
+	family(w, "loadtest_throttles", "counter", "responses with a Retry-After we honored")
+	fmt.Fprintf(w, "loadtest_throttles_total %d\n", r.Throttles)
+	family(w, "loadtest_byte_mismatches", "counter", "successful GETs of the wrong size")
+	fmt.Fprintf(w, "loadtest_byte_mismatches_total %d\n", r.ByteMismatches)
+	family(w, "loadtest_over_envelope", "counter", "requests cut off at a multiple of their recorded latency")
+	fmt.Fprintf(w, "loadtest_over_envelope_total %d\n", r.OverEnvelope)
+	family(w, "loadtest_header_mismatches", "counter", "successful GETs without the headers expected")
+	fmt.Fprintf(w, "loadtest_header_mismatches_total %d\n", r.HeaderMismatches)
+	family(w, "loadtest_duplicate_writes", "counter", "writes sent again with the same idempotency key, by outcome")
+	fmt.Fprintf(w, "loadtest_duplicate_writes_total{outcome=\"same\"} %d\n", r.DuplicatesSame)
+	fmt.Fprintf(w, "loadtest_duplicate_writes_total{outcome=\"refused\"} %d\n", r.DuplicatesRefused)
+	fmt.Fprintf(w, "loadtest_duplicate_writes_total{outcome=\"differed\"} %d\n", r.DuplicatesDiffer)
+	fmt.Fprintf(w, "loadtest_duplicate_writes_total{outcome=\"failed\"} %d\n", r.DuplicatesFailed)
+	family(w, "loadtest_code_mismatches", "counter", "requests that didn't get their recorded return code")
+	fmt.Fprintf(w, "loadtest_code_mismatches_total %d\n", r.CodeMismatches)
+	if len(r.Assertions) > 0 {
+		family(w, "loadtest_assertion_passed", "gauge", "1 if the assertion passed, 0 if it failed")
+		for _, a := range r.Assertions {
+			passed := 0
+			if a.Passed {
+				passed = 1
+			}
+			fmt.Fprintf(w, "loadtest_assertion_passed{assertion=%q} %d\n", a.Name, passed)
+		}
+	}
+	fmt.Fprintf(w, "# EOF\n")
+}
+
+// family writes the metadata of a metric family
+func family(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# TYPE %s %s\n# HELP %s %s\n", name, kind, name, help)
+}
+
+// quantiles writes a summary's p50, p90 and p99, and its count
+func quantiles(w io.Writer, name, label, value string, p PhaseLatency) {
+	for _, q := range []struct {
+		quantile string
+		d        float64
+	}{{"0.5", p.P50.Seconds()}, {"0.9", p.P90.Seconds()}, {"0.99", p.P99.Seconds()}} {
+		fmt.Fprintf(w, "%s{%s=%q,quantile=%q} %g\n", name, label, value, q.quantile, q.d)
+	}
+	fmt.Fprintf(w, "%s_count{%s=%q} %d\n", name, label, value, p.Count)
+}
+
+// below counts the latencies in buckets no longer than le seconds
+func (h *histogram) below(le float64) int64 {
+	var n int64
+	for i, count := range h.counts {
+		if bucketLimit(i).Seconds() > le {
+			break
+		}
+		n += count
+	}
+	return n
+}
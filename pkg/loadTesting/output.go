@@ -0,0 +1,191 @@
+package loadTesting
+
+// The output is the log of every request, in the perf format we read,
+// after a header of comments. It goes to stdout, or to OutputFile,
+// through a queue of OutputBuffer lines and a single writer, so a slow
+// consumer, such as a pipe to a busy program, doesn't hold up the
+// workers and inflate the latencies they measure. If the queue fills,
+// the workers wait for it, or with DropOutput, the lines are dropped
+// and counted. The writer flushes its buffer every outputFlushInterval,
+// so a crash loses no more than that. With OutputMaxSize, the file is
+// rotated as it reaches that size, by renaming it with the next number,
+// eg out.1, and starting a new one with the column names, so each can
+// be read on its own. OutputRotate keeps only that many of the old ones.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	outputFlushInterval = time.Second
+	defaultOutputBuffer = 10000
+)
+
+// columnNames starts the output, and every file it's rotated to
+const columnNames = "#yyy-mm-dd hh:mm:ss latency xfertime thinktime bytes url rc op offered\n"
+
+// outputWriter is the output, shared by all the workers. They queue
+// lines for it holding the read lock, and it's closed with the lock.
+type outputWriter struct {
+	sync.RWMutex
+	name    string // "" for stdout
+	f       *os.File
+	b       *bufio.Writer // nil after an error, only used by the writer
+	size    int64         // bytes in the current file
+	rotated int           // files rotated so far
+	lines   chan string   // nil once closed
+	dropped int64         // lines dropped, with DropOutput
+	stopped chan bool     // closed once the writer has written the last line
+}
+
+// createOutput creates the output file, or uses stdout, and
+// starts its writer
+func (lt *Runner) createOutput(name string) (*outputWriter, error) {
+	size := lt.conf.OutputBuffer
+	if size == 0 {
+		size = defaultOutputBuffer
+	}
+	o := &outputWriter{name: name, f: os.Stdout, lines: make(chan string, size), stopped: make(chan bool)}
+	if name != "" {
+		f, err := os.Create(name)
+		if err != nil {
+			return nil, fmt.Errorf("could not create output file %q, %v", name, err)
+		}
+		o.f = f
+	}
+	o.b = bufio.NewWriter(o.f)
+	go lt.writeOutput(o, o.lines)
+	return o, nil
+}
+
+// writeOutput writes the lines queued, flushing every
+// outputFlushInterval, until the queue is closed
+func (lt *Runner) writeOutput(o *outputWriter, lines <-chan string) {
+	defer close(o.stopped)
+	ticker := time.NewTicker(outputFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return
+			}
+			if o.b == nil {
+				// after an error, there's nowhere to write it
+				continue
+			}
+			n, err := io.WriteString(o.b, line)
+			o.size += int64(n)
+			if lt.checkOutput(o, err) && lt.conf.OutputMaxSize > 0 && o.size >= lt.conf.OutputMaxSize {
+				lt.checkOutput(o, lt.rotateOutput(o))
+			}
+		case <-ticker.C:
+			if o.b != nil {
+				lt.checkOutput(o, o.b.Flush())
+			}
+		}
+	}
+}
+
+// outf queues a line for the output
+func (lt *Runner) outf(format string, args ...interface{}) {
+	o := lt.out
+	if o == nil {
+		// not running, so there's nothing to manage
+		fmt.Printf(format, args...)
+		return
+	}
+	line := fmt.Sprintf(format, args...)
+	o.RLock()
+	defer o.RUnlock()
+	if o.lines == nil {
+		// a straggler finished after we closed
+		return
+	}
+	if !lt.conf.DropOutput {
+		o.lines <- line
+		return
+	}
+	select {
+	case o.lines <- line:
+	default:
+		// the writer can't keep up
+		atomic.AddInt64(&o.dropped, 1)
+	}
+}
+
+// droppedOutput returns the number of lines dropped so far
+func (lt *Runner) droppedOutput() int64 {
+	if lt.out == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&lt.out.dropped)
+}
+
+// rotateOutput renames the full file, removes the oldest if need be,
+// and starts a new one. It's called by the writer.
+func (lt *Runner) rotateOutput(o *outputWriter) error {
+	if err := o.b.Flush(); err != nil {
+		return err
+	}
+	if err := o.f.Close(); err != nil {
+		return err
+	}
+	o.rotated++
+	if err := os.Rename(o.name, fmt.Sprintf("%s.%d", o.name, o.rotated)); err != nil {
+		return err
+	}
+	if keep := lt.conf.OutputRotate; keep > 0 && o.rotated > keep {
+		os.Remove(fmt.Sprintf("%s.%d", o.name, o.rotated-keep)) // nolint
+	}
+	f, err := os.Create(o.name)
+	if err != nil {
+		return err
+	}
+	o.f, o.size = f, 0
+	o.b.Reset(f)
+	n, err := io.WriteString(o.b, columnNames)
+	o.size = int64(n)
+	return err
+}
+
+// checkOutput stops the output at its first error. Stdout isn't
+// checked, as it may be a pipe to a command such as head.
+func (lt *Runner) checkOutput(o *outputWriter, err error) bool {
+	if err == nil || o.name == "" {
+		return true
+	}
+	lt.warnf("error writing output file %q, %v, no more will be written\n", o.name, err)
+	o.b = nil
+	return false
+}
+
+// closeOutput writes the lines still queued, flushes the output, and
+// closes it if it's a file
+func (lt *Runner) closeOutput(o *outputWriter) {
+	o.Lock()
+	close(o.lines)
+	o.lines = nil
+	o.Unlock()
+	<-o.stopped
+	if o.b == nil {
+		return
+	}
+	err := o.b.Flush()
+	o.b = nil
+	if o.name == "" {
+		return
+	}
+	if err == nil {
+		err = o.f.Close()
+	}
+	if err != nil {
+		lt.warnf("error closing output file %q, %v\n", o.name, err)
+	}
+}
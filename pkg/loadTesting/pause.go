@@ -0,0 +1,114 @@
+package loadTesting
+
+// Pausing a run, to change something on the target part way through
+// and then carry on with the same test. While paused, the workers
+// stop taking requests from the input, so the ones in flight finish
+// and no more are sent, and on Resume they carry on from where they
+// were. The statistics carry on too, but the time paused isn't part
+// of the run's duration, and so its TPS, nor of its RunDuration.
+// With PauseSignal, SIGUSR1 pauses the run, and the next resumes it.
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// pauser is the state of a run's pauses
+type pauser struct {
+	sync.Mutex
+	resumed chan struct{} // closed by Resume, nil unless paused
+	since   time.Time     // when this pause began
+}
+
+// Pause stops sending requests until Resume. Requests in flight finish.
+func (lt *Runner) Pause() {
+	lt.pause.Lock()
+	defer lt.pause.Unlock()
+	if lt.pause.resumed != nil {
+		return
+	}
+	lt.pause.resumed = make(chan struct{})
+	lt.pause.since = time.Now()
+	lt.infof("paused, with %d requests in flight\n", lt.outstanding())
+}
+
+// Resume carries on sending requests after a Pause
+func (lt *Runner) Resume() {
+	lt.pause.Lock()
+	defer lt.pause.Unlock()
+	if lt.pause.resumed == nil {
+		return
+	}
+	close(lt.pause.resumed)
+	lt.pause.resumed = nil
+	d := time.Since(lt.pause.since)
+	lt.results.addPause(d)
+	lt.infof("resumed, after %.3f s\n", d.Seconds())
+}
+
+// TogglePause pauses a running test, or resumes a paused one
+func (lt *Runner) TogglePause() {
+	if lt.isPaused() {
+		lt.Resume()
+		return
+	}
+	lt.Pause()
+}
+
+// isPaused is true between a Pause and a Resume
+func (lt *Runner) isPaused() bool {
+	lt.pause.Lock()
+	defer lt.pause.Unlock()
+	return lt.pause.resumed != nil
+}
+
+// pausedFor returns the time the run has been paused, including any
+// pause still going on
+func (lt *Runner) pausedFor() time.Duration {
+	lt.pause.Lock()
+	defer lt.pause.Unlock()
+	d := lt.results.pausedFor()
+	if lt.pause.resumed != nil {
+		d += time.Since(lt.pause.since)
+	}
+	return d
+}
+
+// waitIfPaused blocks a worker until the run is resumed, or stopped
+func (lt *Runner) waitIfPaused() {
+	lt.pause.Lock()
+	resumed := lt.pause.resumed
+	lt.pause.Unlock()
+	if resumed == nil {
+		return
+	}
+	select {
+	case <-resumed:
+	case <-lt.stopped:
+	}
+}
+
+// handlePauseSignal toggles the pause on each SIGUSR1, until the
+// returned func is called
+func (lt *Runner) handlePauseSignal() func() {
+	signals := make(chan os.Signal, 1)
+	done := make(chan bool)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		for {
+			select {
+			case <-signals:
+				lt.TogglePause()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
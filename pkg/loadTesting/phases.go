@@ -0,0 +1,112 @@
+package loadTesting
+
+// Phases breaks the latency of REST requests down into DNS lookup,
+// TCP connect, TLS handshake, server time, from the request being
+// written to the first byte of the response, and transfer time.
+// Requests that reuse a connection have no DNS, connect or TLS phases,
+// so those percentiles are only of the requests that had them. Whether
+// each request reused a connection is counted too, to check that
+// keep-alives work, as new connections silently add to the latency.
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// The phases of a request
+const (
+	dnsPhase = iota
+	connectPhase
+	tlsPhase
+	serverPhase
+	transferPhase
+	numPhases
+)
+
+var phaseNames = [numPhases]string{"dns", "connect", "tls", "server", "transfer"}
+
+// PhaseLatency is the distribution of the time spent in one phase,
+// or by one kind of request
+type PhaseLatency struct {
+	Count int64 // requests that had this phase
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+}
+
+// phaseTimer records when the phases of a single request start and end.
+// Dials may be concurrent, so it's locked.
+type phaseTimer struct {
+	sync.Mutex
+	dnsStart, dnsDone         time.Time
+	connectStart, connectDone time.Time
+	tlsStart, tlsDone         time.Time
+	wrote, firstByte          time.Time
+
+	gotConn, reused bool // it had a connection, and whether it was reused
+}
+
+// traced returns a request that times its phases with t
+func (t *phaseTimer) traced(req *http.Request) *http.Request {
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { t.mark(&t.dnsStart) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { t.mark(&t.dnsDone) },
+		ConnectStart: func(network, addr string) {
+			t.mark(&t.connectStart)
+		},
+		ConnectDone: func(network, addr string, err error) {
+			t.mark(&t.connectDone)
+		},
+		TLSHandshakeStart:    func() { t.mark(&t.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { t.mark(&t.tlsDone) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { t.mark(&t.wrote) },
+		GotFirstResponseByte: func() { t.mark(&t.firstByte) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.Lock()
+			defer t.Unlock()
+			t.gotConn, t.reused = true, info.Reused
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// mark records the first time something happened
+func (t *phaseTimer) mark(when *time.Time) {
+	t.Lock()
+	defer t.Unlock()
+	if when.IsZero() {
+		*when = time.Now()
+	}
+}
+
+// phases returns the time spent in each phase, zero if it didn't happen
+func (t *phaseTimer) phases(transferTime time.Duration) [numPhases]time.Duration {
+	var p [numPhases]time.Duration
+
+	t.Lock()
+	defer t.Unlock()
+	p[dnsPhase] = since(t.dnsStart, t.dnsDone)
+	p[connectPhase] = since(t.connectStart, t.connectDone)
+	p[tlsPhase] = since(t.tlsStart, t.tlsDone)
+	p[serverPhase] = since(t.wrote, t.firstByte)
+	p[transferPhase] = transferTime
+	return p
+}
+
+// conn returns whether the request had a connection, and if it was reused
+func (t *phaseTimer) conn() (bool, bool) {
+	t.Lock()
+	defer t.Unlock()
+	return t.gotConn, t.reused
+}
+
+// since is the time from start to end, if both happened
+func since(start, end time.Time) time.Duration {
+	if start.IsZero() || end.IsZero() {
+		return 0
+	}
+	return end.Sub(start)
+}
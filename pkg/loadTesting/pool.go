@@ -0,0 +1,106 @@
+package loadTesting
+
+// The worker pool sends at the target rate with a fixed number of
+// goroutines, instead of one per TPS each ticking once a second, so
+// thousands of TPS don't need thousands of workers. A pacer admits a
+// request at each point in the schedule, and whichever worker is free
+// sends it and waits for the response. If none is free, the pacer
+// waits for one, and counts it, as the pool is too small for the rate.
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// paced is true if workers are started once and follow the offered
+// rate, rather than there being one per TPS
+func (lt *Runner) paced() bool {
+	return lt.conf.Deterministic || lt.conf.WorkerPool > 0
+}
+
+// startPool starts the pacer and the pool, adding the workers to wg
+func (lt *Runner) startPool(pipe chan []string, wg *sync.WaitGroup) {
+	tokens := make(chan struct{})
+	done := make(chan struct{})
+	var pool sync.WaitGroup
+
+	lt.infof("sending with a pool of %d workers\n", lt.conf.WorkerPool)
+	for i := 0; i < lt.conf.WorkerPool; i++ {
+		pool.Add(1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer pool.Done()
+			lt.poolWorker(tokens, pipe)
+		}()
+	}
+	go lt.pace(tokens, done)
+	go func() {
+		// the pacer stops once the workers have used up the input
+		pool.Wait()
+		close(done)
+	}()
+}
+
+// pace admits requests at the offered rate until done is closed. Like
+// the scheduler, if it falls more than a tick behind, it starts again
+// from now rather than admitting a burst.
+func (lt *Runner) pace(tokens chan<- struct{}, done <-chan struct{}) {
+	next := time.Now()
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(time.Until(next)):
+		}
+		lt.waitIfPaused()
+		select {
+		case tokens <- struct{}{}:
+		default:
+			// every worker is busy
+			atomic.AddInt64(&lt.poolBusy, 1)
+			select {
+			case tokens <- struct{}{}:
+			case <-done:
+				return
+			}
+		}
+		next = next.Add(lt.interval() / time.Duration(atomic.LoadInt64(&lt.offeredRate)))
+		if time.Since(next) > workerTick {
+			next = time.Now()
+		}
+	}
+}
+
+// poolWorker sends a request each time the pacer admits one, until
+// the input is used up
+func (lt *Runner) poolWorker(tokens <-chan struct{}, pipe chan []string) {
+	atomic.AddInt64(&lt.workers, 1)
+	defer atomic.AddInt64(&lt.workers, -1)
+	op, gen := lt.workerOp()
+	for range tokens {
+		op, gen = lt.latestOp(op, gen)
+		if lt.doWork(op, pipe, lt.run) {
+			return
+		}
+	}
+}
+
+// run makes a request in this goroutine, counting it as in flight
+func (lt *Runner) run(request func()) {
+	if !lt.admit() {
+		return
+	}
+	defer atomic.AddInt64(&lt.inFlight, -1)
+	request()
+}
+
+// reportPool compares the rate achieved with the target
+func (lt *Runner) reportPool(r Results) {
+	lt.infof("target %d TPS, achieved %.1f TPS\n", atomic.LoadInt64(&lt.offeredRate), r.TPS())
+	if busy := atomic.LoadInt64(&lt.poolBusy); busy > 0 {
+		lt.infof("all %d workers were busy for %d requests, so the pool is too small for the rate\n",
+			lt.conf.WorkerPool, busy)
+	}
+}
@@ -0,0 +1,73 @@
+package loadTesting
+
+// Preflight checks the target is there before generating any load, so
+// a mistyped URL fails at once with one clear message, rather than with
+// a flood of errors from every worker.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const preflightTimeout = 10 * time.Second
+
+// preflighter is a protocol that can check its target is reachable.
+// The others check what they can when they're initialized.
+type preflighter interface {
+	Preflight(ctx context.Context) error
+}
+
+// preflight runs the protocol's check, if it has one, unless ctx is
+// cancelled first
+func (lt *Runner) preflight(ctx context.Context) error {
+	pf, ok := lt.op.(preflighter)
+	if !ok {
+		lt.infof("no preflight check for protocol %d, continuing\n", lt.conf.Protocol)
+		return nil
+	}
+	if err := pf.Preflight(ctx); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w, preflight check failed, %v", ErrUnreachable, err)
+	}
+	lt.infof("preflight check passed\n")
+	return nil
+}
+
+// Preflight does a HEAD of the base URL, which succeeds if the server
+// answers at all without a 5xx, or a GET of PreflightPath, which must
+// succeed as a request in the test would.
+func (p *RestProto) Preflight(ctx context.Context) error {
+	method, url := "HEAD", p.prefix+"/"
+	if p.conf.PreflightPath != "" {
+		method, url = "GET", p.url(p.conf.PreflightPath)
+	}
+	ctx, cancel := context.WithTimeout(ctx, preflightTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return fmt.Errorf("could not create a request for %s, %v", url, err)
+	}
+	p.addHeaders(req)
+	if err := p.sign(req); err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s got no response, %v", method, url, err)
+	}
+	defer resp.Body.Close()            // nolint
+	io.Copy(ioutil.Discard, resp.Body) // nolint
+	switch {
+	case p.conf.PreflightPath == "" && resp.StatusCode >= 500:
+		return fmt.Errorf("%s %s returned %s", method, url, resp.Status)
+	case p.conf.PreflightPath != "" && p.failed(resp.StatusCode, ""):
+		return fmt.Errorf("%s %s returned %s", method, url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package loadTesting
+
+// Profiling the load generator itself, to see if it's CPU- or
+// GC-bound, before trusting it to drive a target at a high rate.
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// startProfiling starts a CPU profile, if asked for, and returns a
+// func to call at the end of the run that stops it and writes a heap
+// profile, if that was asked for.
+func (lt *Runner) startProfiling() (func(), error) {
+	var cpu *os.File
+	var err error
+
+	if lt.conf.CPUProfile != "" {
+		cpu, err = os.Create(lt.conf.CPUProfile)
+		if err != nil {
+			return nil, fmt.Errorf("could not create cpu profile %q, %v", lt.conf.CPUProfile, err)
+		}
+		if err = pprof.StartCPUProfile(cpu); err != nil {
+			cpu.Close() // nolint
+			return nil, fmt.Errorf("could not start cpu profile, %v", err)
+		}
+	}
+	return func() {
+		if cpu != nil {
+			pprof.StopCPUProfile()
+			if err := cpu.Close(); err != nil {
+				lt.warnf("error writing cpu profile %q, %v\n", lt.conf.CPUProfile, err)
+			}
+		}
+		if lt.conf.MemProfile != "" {
+			lt.writeHeapProfile(lt.conf.MemProfile)
+		}
+	}, nil
+}
+
+// writeHeapProfile writes the memory in use at the end of the run
+func (lt *Runner) writeHeapProfile(name string) {
+	f, err := os.Create(name)
+	if err != nil {
+		lt.warnf("could not create memory profile %q, %v\n", name, err)
+		return
+	}
+	runtime.GC() // so the profile is up to date
+	err = pprof.WriteHeapProfile(f)
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		lt.warnf("error writing memory profile %q, %v\n", name, err)
+	}
+}
@@ -0,0 +1,68 @@
+package loadTesting
+
+// The progress bar is a single status line on stderr, rewritten every
+// second, for eyeballing the health of a run at a glance. If stderr
+// isn't a terminal, as when it's redirected to a file, it's logged
+// every progressBarLogInterval instead, as a line per second would
+// swamp everything else.
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	progressBarInterval    = time.Second      // how often to update the line
+	progressBarLogInterval = 10 * time.Second // or to log it, if not a terminal
+)
+
+// startProgressBar shows the status line until the returned function
+// is called, which waits for it to stop, so the summary overwrites it
+func (lt *Runner) startProgressBar() func() {
+	tty := isTerminal(os.Stderr)
+	interval := progressBarLogInterval
+	if tty {
+		interval = progressBarInterval
+	}
+	done, stopped := make(chan bool), make(chan bool)
+	go func() {
+		defer close(stopped)
+		var last int64
+
+		start := time.Now()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				r := lt.results.snapshot()
+				s := fmt.Sprintf("[t+%ds] %.0f TPS, %.1f%% err, p99 %dms",
+					int(time.Since(start).Seconds()),
+					float64(r.Requests-last)/interval.Seconds(),
+					100*r.ErrorRate(), r.P99.Milliseconds())
+				last = r.Requests
+				if !tty {
+					lt.infof("%s\n", s)
+					continue
+				}
+				// padded to overwrite a longer line before it, and
+				// with the cursor left at the start, so a log message
+				// overwrites it rather than being appended to it
+				fmt.Fprintf(os.Stderr, "\r%-60s\r", s) // nolint
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// isTerminal is true if f is a terminal, rather than a file or a pipe
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,58 @@
+package loadTesting
+
+// Query strings. Traces often have their query strings stripped, or
+// need a common parameter, such as a cache-buster or a feature flag,
+// added to every request. ExtraQuery adds them to REST requests
+// without rewriting the trace, and Strip leaves query strings alone.
+
+import (
+	"net/url"
+	"strings"
+)
+
+// withQuery adds the ExtraQuery parameters to a URL, replacing any of
+// the same name, and leaving the rest of its query string as it was
+func (lt *Runner) withQuery(rawURL string) string {
+	if len(lt.conf.ExtraQuery) == 0 {
+		return rawURL
+	}
+	base, query := splitQuery(rawURL)
+	var kept []string
+	for _, pair := range strings.Split(query, "&") {
+		if pair == "" {
+			continue
+		}
+		key := pair
+		if i := strings.IndexByte(pair, '='); i >= 0 {
+			key = pair[:i]
+		}
+		if unescaped, err := url.QueryUnescape(key); err == nil {
+			key = unescaped
+		}
+		if _, present := lt.conf.ExtraQuery[key]; !present {
+			kept = append(kept, pair)
+		}
+	}
+	extra := make(url.Values, len(lt.conf.ExtraQuery))
+	for key, value := range lt.conf.ExtraQuery {
+		extra.Set(key, value)
+	}
+	return base + "?" + strings.Join(append(kept, extra.Encode()), "&")
+}
+
+// stripPath removes the first occurrence of strip from the path, but
+// not from its query string
+func stripPath(path, strip string) string {
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		return strings.Replace(path[:i], strip, "", 1) + path[i:]
+	}
+	return strings.Replace(path, strip, "", 1)
+}
+
+// splitQuery splits a path or URL at the start of its query string
+func splitQuery(path string) (string, string) {
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		return path[:i], path[i+1:]
+	}
+	return path, ""
+}
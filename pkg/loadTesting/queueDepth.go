@@ -0,0 +1,32 @@
+package loadTesting
+
+// Queue depth is the number of requests outstanding, sent but not yet
+// answered. In the usual open model, requests are sent at the rate
+// whether or not earlier ones have finished, so when the target can't
+// keep up they pile up, and the depth climbing without limit is the
+// sign of congestion collapse. The current depth is in the progress
+// reports and its peak in the summary. With MaxQueueDepth, requests
+// beyond it are shed, and counted as rejected, instead of queueing
+// without limit, as a load balancer or admission control would.
+
+import (
+	"sync/atomic"
+)
+
+// admit counts a request as outstanding, or if MaxQueueDepth
+// already are, sheds it. It's false if the request was shed.
+func (lt *Runner) admit() bool {
+	n := atomic.AddInt64(&lt.inFlight, 1)
+	if max := int64(lt.conf.MaxQueueDepth); max > 0 && n > max {
+		atomic.AddInt64(&lt.inFlight, -1)
+		lt.results.addRejected()
+		return false
+	}
+	lt.results.addOutstanding(n)
+	return true
+}
+
+// outstanding is the number of requests sent but not yet answered
+func (lt *Runner) outstanding() int64 {
+	return atomic.LoadInt64(&lt.inFlight)
+}
@@ -0,0 +1,84 @@
+package loadTesting
+
+// Parsing the input. Every record is checked as it's read, so a
+// malformed line is skipped, or halts the run, with its line number,
+// rather than crashing a worker part way through. A list of paths is
+// expanded into perf-format records first.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Input formats
+const (
+	PerfFormat     = ""         // nine fields, as recorded
+	PathListFormat = "pathlist" // a path, optionally after a method and before a size
+)
+
+// checkRecord checks the fields of a line of input. The records are
+// passed on as fields, so only the numbers are checked: dates and
+// times are left as they are, as logs write them in many formats.
+func checkRecord(fields []string) error {
+	if len(fields) < 9 {
+		return fmt.Errorf("%d fields, not 9", len(fields))
+	}
+	for _, f := range []struct {
+		name  string
+		field int
+	}{
+		{"latency", latencyField},
+		{"transfer time", transferTimeField},
+		{"think time", thinkTimeField},
+	} {
+		if _, err := strconv.ParseFloat(fields[f.field], 64); err != nil {
+			return fmt.Errorf("%s %q is not a number", f.name, fields[f.field])
+		}
+	}
+	if _, ok := bodyFile(fields[bytesField]); !ok {
+		if _, err := strconv.ParseInt(fields[bytesField], 10, 64); err != nil {
+			return fmt.Errorf("size %q is not a number", fields[bytesField])
+		}
+	}
+	if _, err := strconv.Atoi(fields[returnCodeField]); err != nil {
+		return fmt.Errorf("return code %q is not a number", fields[returnCodeField])
+	}
+	return nil
+}
+
+// parse checks a line of input, first expanding it into a perf-format
+// record if it's from a list of paths
+func (lt *Runner) parse(fields []string) ([]string, error) {
+	var err error
+
+	if lt.conf.InputFormat == PathListFormat {
+		if fields, err = expandPath(fields); err != nil {
+			return fields, err
+		}
+	}
+	if err = checkRecord(fields); err != nil {
+		return fields, err
+	}
+	return fields, lt.checkProtocol(fields)
+}
+
+// expandPath makes a perf-format record from a path, a method and a
+// path, or a method, a path and a size. The times and return code
+// are zeroes, as there's nothing to compare them with.
+func expandPath(fields []string) ([]string, error) {
+	method, path, size := "GET", "", "0"
+	switch len(fields) {
+	case 1:
+		path = fields[0]
+	case 2:
+		method, path = fields[0], fields[1]
+	case 3:
+		method, path, size = fields[0], fields[1], fields[2]
+	default:
+		return fields, fmt.Errorf("%d fields, not a path, a method and path, "+
+			"or a method, path and size", len(fields))
+	}
+	return []string{"1970-01-01", "00:00:00.000", "0", "0", "0", size, path, "0",
+		strings.ToUpper(method)}, nil
+}
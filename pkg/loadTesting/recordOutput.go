@@ -0,0 +1,85 @@
+package loadTesting
+
+// RecordOutput writes each completed request back out in the same
+// 9-field perf format we read, using the measured values, so that
+// a later run can replay the workload this one observed.
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// perfWriter writes perf-format records. It's shared by all the workers.
+type perfWriter struct {
+	sync.Mutex
+	logger
+	f *os.File
+	b *bufio.Writer
+	w *csv.Writer // nil once closed
+}
+
+// createRecorder creates a perf-format file and writes its header
+func createRecorder(name string, l logger) (*perfWriter, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not create record file %q, %v", name, err)
+	}
+	b := bufio.NewWriter(f)
+	b.WriteString("#yyy-mm-dd hh:mm:ss latency xfertime thinktime bytes url rc op\n") // nolint
+	// use the same separator as the reader, so it can quote awkward paths
+	w := csv.NewWriter(b)
+	w.Comma = ' '
+	return &perfWriter{logger: l, f: f, b: b, w: w}, nil
+}
+
+// record writes one request
+func (p *perfWriter) record(initial time.Time, latency, transferTime time.Duration,
+	bytes, path string, rc int, op string) error {
+	p.Lock()
+	defer p.Unlock()
+	if p.w == nil {
+		// a straggler finished after we closed
+		return nil
+	}
+	err := p.w.Write([]string{
+		initial.Format("2006-01-02"),
+		initial.Format("15:04:05.000"),
+		strconv.FormatFloat(latency.Seconds(), 'f', 6, 64),
+		strconv.FormatFloat(transferTime.Seconds(), 'f', 6, 64),
+		"0", // think time
+		bytes,
+		path,
+		strconv.Itoa(rc),
+		op,
+	})
+	if err != nil {
+		return fmt.Errorf("error writing record file %q, %v", p.f.Name(), err)
+	}
+	return nil
+}
+
+// close flushes and closes the file
+func (p *perfWriter) close() {
+	p.Lock()
+	defer p.Unlock()
+	if p.w == nil {
+		return
+	}
+	p.w.Flush()
+	err := p.w.Error()
+	if err == nil {
+		err = p.b.Flush()
+	}
+	if err == nil {
+		err = p.f.Close()
+	}
+	if err != nil {
+		p.warnf("error closing record file %q, %v\n", p.f.Name(), err)
+	}
+	p.w = nil
+}
@@ -0,0 +1,66 @@
+package loadTesting
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestRecordOutputRoundTrip sees if what one run records is valid input to another
+func TestRecordOutputRoundTrip(t *testing.T) {
+	var tests = []struct {
+		bytes, path string
+		rc          int
+		op          string
+	}{
+		{"12530", "/15b00a26-9ba3-4649-8477-c48bcab90dc7", 200, "GET"},
+		{"0", "/missing", 404, "GET"},
+		{"100820", "/a path with spaces", 201, "PUT"},
+	}
+	name := filepath.Join(t.TempDir(), "recorded.csv")
+	initial := time.Date(2017, 12, 10, 16, 39, 8, 511000000, time.UTC)
+
+	recorder, err := createRecorder(name, newLogger(Config{}))
+	if err != nil {
+		t.Fatalf("could not create %s, %v", name, err)
+	}
+	for _, test := range tests {
+		recorder.record(initial, 2729*time.Microsecond, 288*time.Microsecond,
+			test.bytes, test.path, test.rc, test.op)
+	}
+	recorder.close()
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatalf("could not reopen %s, %v", name, err)
+	}
+	defer f.Close() // nolint
+	pipe := make(chan []string, len(tests)+1)
+	n := NewRunner(Config{}).copyToPipe(len(tests)+1, newPerfReader(f), name, pipe, nil)
+	close(pipe)
+	if n != len(tests) {
+		t.Fatalf("read %d records, expected %d", n, len(tests))
+	}
+	i := 0
+	for record := range pipe {
+		test := tests[i]
+		switch {
+		case len(record) != 9:
+			t.Errorf("record %d has %d fields, not 9: %q", i, len(record), record)
+		case record[dateField] != "2017-12-10" || record[timeField] != "16:39:08.511":
+			t.Errorf("record %d has date %q %q", i, record[dateField], record[timeField])
+		case record[latencyField] != "0.002729" || record[transferTimeField] != "0.000288":
+			t.Errorf("record %d has times %q %q", i, record[latencyField], record[transferTimeField])
+		case record[bytesField] != test.bytes || record[pathField] != test.path:
+			t.Errorf("record %d has bytes %q and path %q, expected %q and %q",
+				i, record[bytesField], record[pathField], test.bytes, test.path)
+		case record[returnCodeField] != strconv.Itoa(test.rc):
+			t.Errorf("record %d has return code %q, expected %d", i, record[returnCodeField], test.rc)
+		case record[operatorField] != test.op:
+			t.Errorf("record %d has operator %q, expected %q", i, record[operatorField], test.op)
+		}
+		i++
+	}
+}
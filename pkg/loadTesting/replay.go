@@ -0,0 +1,51 @@
+package loadTesting
+
+// Replaying at the trace's own times. With SpeedupFactor, the reader
+// holds each record back until its time in the trace, relative to the
+// first, divided by the factor, so 1 is real time, 24 replays a day in
+// an hour and 0.5 is half speed. The TPS is still the most that will
+// be sent, so it should be above the trace's peak rate. Times that
+// aren't in the format this program writes are ignored.
+
+import (
+	"time"
+)
+
+const traceTimeFormat = "2006-01-02 15:04:05" // and any fraction of a second
+
+// replayClock is where the reader is in the trace. Only the reader uses it.
+type replayClock struct {
+	traceStart time.Time // the time in the trace of the first record, and
+	wallStart  time.Time // when it was sent
+	warned     bool      // about a time we couldn't read
+}
+
+// waitForTraceTime waits until a record is due. It's false if the
+// run was stopped instead.
+func (lt *Runner) waitForTraceTime(record []string) bool {
+	c := &lt.replay
+	t, err := time.Parse(traceTimeFormat, record[dateField]+" "+record[timeField])
+	if err != nil {
+		if !c.warned {
+			lt.warnf("can't replay at the trace's times, %q isn't a time, sending it now\n",
+				record[dateField]+" "+record[timeField])
+			c.warned = true
+		}
+		return true
+	}
+	if c.wallStart.IsZero() {
+		// the first record of a pass
+		c.traceStart, c.wallStart = t, time.Now()
+		return true
+	}
+	due := c.wallStart.Add(time.Duration(float64(t.Sub(c.traceStart)) / lt.conf.SpeedupFactor))
+	if time.Until(due) <= 0 {
+		return true
+	}
+	select {
+	case <-time.After(time.Until(due)):
+		return true
+	case <-lt.stopped:
+		return false
+	}
+}
@@ -0,0 +1,54 @@
+package loadTesting
+
+// Resources reports on the load generator itself, so we can tell if
+// it's the bottleneck. If it's saturated before the system under test
+// is, the TPS it reports is meaningless.
+
+import (
+	"net"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+const resourceInterval = 10 * time.Second // how often to report, in verbose mode
+
+// countedConn is a connection that's counted as open until it's closed
+type countedConn struct {
+	net.Conn
+	open   *int64
+	closed int32
+	opened time.Time
+}
+
+// Close counts the connection as closed, once
+func (c *countedConn) Close() error {
+	if atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		atomic.AddInt64(c.open, -1)
+	}
+	return c.Conn.Close()
+}
+
+// countConn counts a new connection
+func (lt *Runner) countConn(c net.Conn) net.Conn {
+	atomic.AddInt64(&lt.dials, 1)
+	atomic.AddInt64(&lt.openConns, 1)
+	return &countedConn{Conn: c, open: &lt.openConns, opened: time.Now()}
+}
+
+// reportResources logs our own resource use every interval, until done is closed
+func (lt *Runner) reportResources(interval time.Duration, done chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			lt.infof("load generator: %d goroutines, %d workers, "+
+				"%d connections open, %d opened so far\n",
+				runtime.NumGoroutine(), atomic.LoadInt64(&lt.workers),
+				atomic.LoadInt64(&lt.openConns), atomic.LoadInt64(&lt.dials))
+		}
+	}
+}
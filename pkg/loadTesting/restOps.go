@@ -1,11 +1,14 @@
 package loadTesting
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"net"
 	"net/http"
+	"net/http/cookiejar"
 	"net/http/httputil"
 	"os"
 	"strconv"
@@ -14,11 +17,81 @@ import (
 
 // RestProto satisfies operation by doing rest operations.
 type RestProto struct {
-	prefix string
+	*Runner
+	prefix  string
+	client  *http.Client
+	host    string        // from the HostTemplate, for this request
+	timeout time.Duration // its envelope, with LatencyTimeoutFactor
 }
 
-// Init does nothing
-func (p RestProto) Init() {}
+// Init sets up the client's timeouts, and adds a shared cookie jar
+// if we're replaying sessions
+func (p *RestProto) Init() error {
+	p.client = p.newHTTPClient()
+	if p.conf.UseCookieJar && !p.conf.WorkerJars {
+		p.client.Jar = p.mustCreateCookieJar()
+	}
+	return nil
+}
+
+// withCookieJar returns a copy of p with its own client and cookie jar,
+// sharing the transport (and its connection pool) with everyone else.
+func (p *RestProto) withCookieJar() *RestProto {
+	c := *p
+	c.client = &http.Client{
+		Transport: p.client.Transport,
+		Timeout:   p.client.Timeout,
+		Jar:       p.mustCreateCookieJar(),
+	}
+	return &c
+}
+
+// send sends a request with p's client, once
+func (p *RestProto) send(req *http.Request) (*http.Response, error) {
+	var conn net.Conn
+
+	if p.conf.MaxConnLifetime > 0 {
+		req = lifetimeTrace(req, &conn)
+	}
+	if err := p.sign(req); err != nil {
+		return nil, err
+	}
+	p.limitRequest(req)
+	resp, err := p.client.Do(req)
+	if err == nil && p.conf.MaxConnLifetime > 0 {
+		resp.Body = p.expiring(resp.Body, conn)
+	}
+	if err == nil {
+		p.limitResponse(resp)
+	}
+	if err == nil && p.conf.HonorRetryAfter {
+		if d := retryAfter(resp, time.Now()); d > 0 {
+			if p.conf.Verbose {
+				p.debugf("%s asked us to retry after %s, pausing\n", req.URL, d)
+			}
+			p.throttle(d)
+		}
+	}
+	return resp, err
+}
+
+// url returns the URL of a path, with any ExtraQuery parameters. An
+// absolute URL is used as it is, instead of joining it to the prefix.
+func (p *RestProto) url(path string) string {
+	if isAbsolute(path) {
+		return p.withQuery(path)
+	}
+	return p.withQuery(p.prefix + "/" + path)
+}
+
+// mustCreateCookieJar creates a jar so Set-Cookie responses carry forward
+func (p *RestProto) mustCreateCookieJar() http.CookieJar {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		p.fatalf("could not create a cookie jar, %v, halting\n", err)
+	}
+	return jar
+}
 
 // Tuning for large loads. With this, when we start reporting network
 // errors, then we've overloaded somebody. Previously we bottlenecked
@@ -30,73 +103,163 @@ const (
 	RequestTimeout     int = 0
 )
 
-var httpClient = &http.Client{
-	Transport: &http.Transport{
-		MaxIdleConnsPerHost: MaxIdleConnections,
-	},
-	Timeout: time.Duration(RequestTimeout) * time.Second,
+// newHTTPClient creates a client with the configured timeouts. The
+// connect timeout is separate from the request timeout, so an
+// unreachable server can be told from a slow one.
+func (p *RestProto) newHTTPClient() *http.Client {
+	timeout := time.Duration(RequestTimeout) * time.Second
+	if p.conf.RequestTimeout > 0 {
+		timeout = p.conf.RequestTimeout
+	}
+	dialer := p.newDialer()
+	return &http.Client{
+		Transport: &http.Transport{
+			MaxIdleConnsPerHost: MaxIdleConnections,
+			DialContext:         p.dialWithOverrides(dialer),
+			DisableKeepAlives:   p.conf.DisableKeepAlives,
+			IdleConnTimeout:     p.conf.IdleConnTimeout,
+		},
+		Timeout: timeout,
+	}
+}
+
+// dialWithOverrides connects to a different address for some hosts,
+// like an /etc/hosts entry would. The URL, and so the Host header and
+// TLS server name, are unchanged.
+func (lt *Runner) dialWithOverrides(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err == nil {
+			if ip, present := lt.conf.HostOverrides[host]; present {
+				if lt.conf.Debug {
+					lt.debugf("dialing %s instead of %s\n", ip, host)
+				}
+				addr = net.JoinHostPort(ip, port)
+			}
+		}
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			lt.checkPorts(err)
+			return nil, err
+		}
+		lt.tuneConn(conn)
+		return lt.countConn(conn), nil
+	}
+}
+
+// errorToCode turns an error from the client into a return code:
+// 599 if we couldn't connect, -1 if we couldn't sign the request,
+// otherwise 444, for no response
+func errorToCode(err error) int {
+	var opErr *net.OpError
+
+	switch {
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return 599
+	case isSigningError(err):
+		return -1
+	}
+	return 444
+}
+
+// abandoned is true if a request was cancelled because the run was
+// stopped, so it's neither a success nor a failure, and isn't reported
+func (p *RestProto) abandoned(err error) bool {
+	return err != nil && p.isStopped() && errors.Is(err, context.Canceled)
 }
 
 // Get does a GET from an http target and times it
-func (p RestProto) Get(path string, oldRc string) {
-	if conf.Debug {
-		log.Printf("in rest.Get(%s)\n", path)
+func (p *RestProto) Get(path, size, oldRc string) {
+	if p.conf.Debug {
+		p.debugf("in rest.Get(%s)\n", path)
 	}
-	req, err := http.NewRequest("GET", p.prefix+"/"+path, nil)
+	req, err := http.NewRequestWithContext(p.ctx, "GET", p.url(path), nil)
 	if err != nil {
-		dumpXact(req, nil, nil, conf.Crash, "error creating http request", err)
-		reportPerformance(time.Now(), 0, 0, nil, path, -1, oldRc)
-		alive <- true
+		p.dumpXact(req, nil, nil, p.conf.Crash, "error creating http request", err)
+		p.reportPerformance(time.Now(), 0, 0, nil, path, -1, oldRc)
+		p.alive <- true
 		return
 	}
-	addHeaders(req)
+	p.addHeaders(req)
+	timer := &phaseTimer{}
+	req = timer.traced(req)
+	req, cancel := p.withEnvelope(req)
+	defer cancel()
 
 	initial := time.Now() // Response time starts
-	resp, err := httpClient.Do(req)
+	resp, err := p.do(req)
 	latency := time.Since(initial) // Latency ends
+	if p.abandoned(err) {
+		p.alive <- true
+		return
+	}
 	if err != nil {
-		dumpXact(req, resp, nil, conf.Crash, "error getting http response", err)
-		// 444 is nginx's code for server has returned no information and/or EOF
-		reportPerformance(initial, latency, 0, nil, path, 444, oldRc)
-		alive <- true
+		p.dumpXact(req, resp, nil, p.conf.Crash, "error getting http response", err)
+		// 444 is nginx's code for server has returned no information and/or EOF,
+		// 599 the informal one for a failure to connect
+		rc := errorToCode(err)
+		if p.outsideEnvelope(req, err) {
+			rc = envelopeCode
+		}
+		p.captureFailure(req, nil, nil, rc, oldRc, err)
+		p.reportPerformance(initial, latency, 0, nil, path, rc, oldRc)
+		p.alive <- true
 		return
 	}
-	body, err := ioutil.ReadAll(resp.Body)
-	// how about io.Copy(ioutil.Discard, resp.Body)
+	body, err := p.readBody(resp.Body)
 	transferTime := time.Since(initial) - latency // Transfer time ends
 	defer resp.Body.Close()                       // nolint
+	if p.abandoned(err) {
+		p.alive <- true
+		return
+	}
 	if err != nil {
-		dumpXact(req, resp, body, conf.Crash, "error reading http response, continuing", err)
+		p.dumpXact(req, resp, body, p.conf.Crash, "error reading http response, continuing", err)
 		// the resp is available, the body, distinctly less so (;-))
-		reportPerformance(initial, latency, transferTime, body, path, resp.StatusCode, oldRc)
-		alive <- true
+		rc := resp.StatusCode
+		if p.outsideEnvelope(req, err) {
+			rc = envelopeCode
+		}
+		p.captureFailure(req, resp, body, rc, oldRc, err)
+		p.reportPerformance(initial, latency, transferTime, body, path, rc, oldRc)
+		p.alive <- true
 		return
 	}
 
 	// And, in the non-error cases, conditionally dump
 	switch {
 	case badGetCode(resp.StatusCode):
-		dumpXact(req, resp, body, conf.Crash, "bad return code", nil)
-	case conf.Verbose:
-		dumpXact(req, resp, body, conf.Crash, "verbose", nil)
+		p.dumpXact(req, resp, body, p.conf.Crash, "bad return code", nil)
+	case p.conf.Verbose:
+		p.dumpXact(req, resp, body, p.conf.Crash, "verbose", nil)
 	}
+	p.results.addPhases(timer.phases(transferTime))
+	p.results.addConn(timer.conn())
+	p.verifyBytes(path, size, resp.StatusCode, int64(len(body)))
+	p.checkHeaders(path, resp)
+	rc := p.checkContentType(path, resp)
+	p.captureFailure(req, resp, body, rc, oldRc, nil)
 
-	reportPerformance(initial, latency, transferTime, body, path, resp.StatusCode, oldRc)
-	alive <- true
+	p.reportPerformance(initial, latency, transferTime, body, path, rc, oldRc)
+	p.alive <- true
 }
 
 // AddHeaders adds/drops specified headers
-func addHeaders(req *http.Request) {
-	if !conf.Cache {
+func (p *RestProto) addHeaders(req *http.Request) {
+	if !p.conf.Cache {
 		req.Header.Add("cache-control", "no-cache")
 	}
-	if conf.HostHeader != "" {
-		req.Host = conf.HostHeader
+	host := p.conf.HostHeader
+	if p.host != "" {
+		host = p.host
+	}
+	if host != "" {
+		req.Host = host
 		// Go disfeature: host is special,
 		// See https://github.com/golang/go/issues/7682
-		req.Header.Add("Host", conf.HostHeader)
+		req.Header.Add("Host", host)
 	}
-	if conf.AkamaiDebug {
+	if p.conf.AkamaiDebug {
 		req.Header.Add("Pragma",
 			"akamai-x-cache-on, "+
 				"akamai-x-cache-remote-on, "+
@@ -106,75 +269,180 @@ func addHeaders(req *http.Request) {
 				"akamai-x-get-true-cache-key, "+
 				"akamai-x-get-request-id")
 	}
-	for key, value := range conf.HeaderMap {
+	for key, value := range p.conf.HeaderMap {
 		req.Header.Add(key, value)
 	}
+	if p.conf.IfNoneMatch != "" {
+		req.Header.Set("If-None-Match", p.conf.IfNoneMatch)
+	}
+	if p.conf.IfModSince != "" {
+		req.Header.Set("If-Modified-Since", p.conf.IfModSince)
+	}
 }
 
 // Put does an ordinary REST (not ceph or s3) put operation.
-func (p RestProto) Put(path, size, oldRC string) {
-	var bytes int64
-	var err error
+func (p *RestProto) Put(path, size, oldRC string) {
+	p.upload("PUT", path, size, oldRC)
+}
+
+// Post does a POST, with a body from a file or of junk data, like Put
+func (p *RestProto) Post(path, size, oldRC string) {
+	p.upload("POST", path, size, oldRC)
+}
+
+// Patch does a PATCH, a partial update, with a body like Put's
+func (p *RestProto) Patch(path, size, oldRC string) {
+	p.upload("PATCH", path, size, oldRC)
+}
 
-	if conf.Debug {
-		log.Printf("in rest.Put(%s, %s)\n", path, size)
+// Delete deletes an object, if it exists, to clean up after a run
+func (p *RestProto) Delete(ctx context.Context, path string) error {
+	req, err := http.NewRequestWithContext(ctx, "DELETE", p.url(path), nil)
+	if err != nil {
+		return err
 	}
-	bytes, err = strconv.ParseInt(size, 10, 64)
+	p.addHeaders(req)
+	resp, err := p.do(req)
 	if err != nil {
-		log.Fatalf("put size %q was unreadable, %v, halting\n",
-			size, err)
-	}
-	if bytes <= 0 {
-		fmt.Printf("%s 0 0 0 %s %s %d PUT\n",
-			time.Now().Format("2006-01-02 15:04:05.000"),
-			size, path, 411) // 411 means "length required"
-		alive <- true
-		return
+		return err
 	}
-	// make sure we have a dummy file
-	fp, err := os.Open(junkDataFile)
+	defer resp.Body.Close()            // nolint
+	io.Copy(ioutil.Discard, resp.Body) // nolint
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("DELETE returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Seed PUTs an object of junk data before the run, untimed
+func (p *RestProto) Seed(ctx context.Context, path string, size int64) error {
+	fp, err := os.Open(p.junkDataFile)
 	if err != nil {
-		log.Fatalf("can't open data file %q, halting\n", junkDataFile)
+		return err
 	}
 	defer fp.Close() // nolint
+	var body io.Reader = http.NoBody
+	if size > 0 {
+		body = io.LimitReader(fp, size)
+	}
+	req, err := http.NewRequestWithContext(ctx, "PUT", p.url(path), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	p.addHeaders(req)
+	resp, err := p.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()            // nolint
+	io.Copy(ioutil.Discard, resp.Body) // nolint
+	if badPutCode(resp.StatusCode) {
+		return fmt.Errorf("PUT returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// upload sends a body with a PUT or POST and times it
+func (p *RestProto) upload(method, path, size, oldRC string) {
+	if p.conf.Debug {
+		p.debugf("in rest.%s(%s, %s)\n", method, path, size)
+	}
+	body, bytes := p.requestBody(size)
+	if body == nil {
+		p.reportWrite(method, time.Now(), 0, 0, size, path, 411, oldRC) // 411 means "length required"
+		p.alive <- true
+		return
+	}
+	defer body.Close() // nolint
+	field := size      // which may name a body file
+	size = strconv.FormatInt(bytes, 10)
 
 	initial := time.Now() // Response time starts
-	req, err := http.NewRequest("PUT", p.prefix+"/"+path, io.LimitReader(fp, bytes))
+	req, err := http.NewRequestWithContext(p.ctx, method, p.url(path), body)
 	if err != nil {
-		// report problem and exit
-		dumpXact(req, nil, nil, true, "error creating http request", err)
+		p.dumpXact(req, nil, nil, p.conf.Crash, "error creating http request", err)
+		p.reportWrite(method, time.Now(), 0, 0, size, path, -1, oldRC)
+		p.alive <- true
+		return
+	}
+	req.ContentLength = bytes
+	if p.conf.Retries > 0 {
+		// so it can be sent again
+		req.GetBody = p.bodyGetter(field)
+	}
+	if p.conf.DuplicateWrites {
+		p.addIdempotencyKey(req, field)
+	}
+	if method == "PUT" && p.conf.ChunkedPut {
+		// a length of -1 is unknown, so the body is streamed in chunks
+		req.ContentLength = -1
+		req.TransferEncoding = []string{"chunked"}
+	}
+	timer := &phaseTimer{}
+	req = timer.traced(req)
+	req, cancel := p.withEnvelope(req)
+	defer cancel()
+	resp, err := p.do(req)
+	if p.abandoned(err) {
+		p.alive <- true
+		return
+	}
+	if p.outsideEnvelope(req, err) {
+		p.reportWrite(method, initial, time.Since(initial), 0, size, path, envelopeCode, oldRC)
+		p.alive <- true
 		return
 	}
-	resp, err := httpClient.Do(req)
+	latency := time.Since(initial) // Response time ends
 	if err != nil {
 		// Timeouts and bad parameters will trigger this case.
-		dumpXact(req, nil, nil, true, "error getting http response", err)
+		p.dumpXact(req, nil, nil, p.conf.Crash, "error getting http response", err)
+		rc := errorToCode(err)
+		p.captureFailure(req, nil, nil, rc, oldRC, err)
+		p.reportWrite(method, initial, latency, 0, size, path, rc, oldRC)
+		p.alive <- true
+		return
 	}
-	latency := time.Since(initial) // Response time ends
 	contents, err := ioutil.ReadAll(resp.Body)
 	transferTime := time.Since(initial) - latency // Transfer time ends
 	defer resp.Body.Close()                       // nolint
+	if p.abandoned(err) {
+		p.alive <- true
+		return
+	}
+	if p.outsideEnvelope(req, err) {
+		p.reportWrite(method, initial, latency, transferTime, size, path, envelopeCode, oldRC)
+		p.alive <- true
+		return
+	}
 	if err != nil {
-		dumpXact(req, resp, contents, true, "error reading http response", err)
+		p.dumpXact(req, resp, contents, p.conf.Crash, "error reading http response, continuing", err)
+		p.captureFailure(req, resp, contents, resp.StatusCode, oldRC, err)
+		p.reportWrite(method, initial, latency, transferTime, size, path, resp.StatusCode, oldRC)
+		p.alive <- true
+		return
 	}
 	// And, in the non-error cases, conditionally dump
 	switch {
 	case badPutCode(resp.StatusCode):
-		dumpXact(req, resp, contents, conf.Crash, "bad return code", nil)
-	case conf.Verbose:
-		dumpXact(req, resp, contents, conf.Crash, "", nil)
+		p.dumpXact(req, resp, contents, p.conf.Crash, "bad return code", nil)
+	case p.conf.Verbose:
+		p.dumpXact(req, resp, contents, p.conf.Crash, "", nil)
+	}
+	p.results.addPhases(timer.phases(transferTime))
+	p.results.addConn(timer.conn())
+	p.captureFailure(req, resp, contents, resp.StatusCode, oldRC, nil)
+	p.reportWrite(method, initial, latency, transferTime, size, path, resp.StatusCode, oldRC)
+	if p.conf.DuplicateWrites {
+		p.sendDuplicate(req, resp.StatusCode, contents)
 	}
-	//reportPerformance(initial, latency, transferTime, body, path, resp, oldRc)
-	fmt.Printf("%s %f %f 0 %s %s %d PUT\n",
-		time.Now().Format("2006-01-02 15:04:05.000"),
-		latency.Seconds(), transferTime.Seconds(), size, path, resp.StatusCode)
-	alive <- true
+	p.alive <- true
 }
 
-// badGetCode is true if this isn't a 20X or 404
+// badGetCode is true if this isn't a 20X, 304 or 404
 // in this case "bad" means "display the error"
 func badGetCode(i int) bool {
-	if i == 200 || i == 202 || i == 404 {
+	if i == 200 || i == 202 || i == 304 || i == 404 {
 		return false
 	}
 	// if --crash is set, returning true will trigger
@@ -190,7 +458,7 @@ func badPutCode(i int) bool {
 }
 
 // dumpXact dumps request and response together, with a reason
-func dumpXact(req *http.Request, resp *http.Response, body []byte, crash bool, reason string, err error) {
+func (lt *Runner) dumpXact(req *http.Request, resp *http.Response, body []byte, crash bool, reason string, err error) {
 	var r string
 	if err != nil {
 		r = fmt.Sprintf("%s, %v\n", reason, err)
@@ -200,9 +468,9 @@ func dumpXact(req *http.Request, resp *http.Response, body []byte, crash bool, r
 	r += requestToString(req)
 	r += responseToString(resp)
 	r += bodyToString(body)
-	log.Printf("%s\n", r)
+	lt.infof("%s\n", r)
 	if crash {
-		log.Fatalf("halting.\n")
+		lt.fatalf("halting.\n")
 	}
 }
 
@@ -214,9 +482,10 @@ func requestToString(req *http.Request) string {
 	if req == nil {
 		return "Request: <nil>\n"
 	}
-	dump, err = httputil.DumpRequestOut(req, true)
+	// the body of a write has already been sent, so can't be dumped
+	dump, err = httputil.DumpRequestOut(req, req.Body == nil || req.Body == http.NoBody)
 	if err != nil {
-		return fmt.Sprintf("fatal error dumping http request, %v\n", err)
+		return fmt.Sprintf("error dumping http request, %v\n", err)
 	}
 	return fmt.Sprintf("Request: \n%s", dump)
 }
@@ -0,0 +1,147 @@
+package loadTesting
+
+// The results database keeps a history of runs, to chart their p99,
+// say, over weeks. With ResultsDSN, a summary row of each run is
+// inserted at the end into the loadtest_results table, which is
+// created if need be: when it started, the version, a hash of its
+// settings, so runs of the same test can be picked out, its tags as
+// JSON, and its TPS, error rate and percentiles. It's written through
+// database/sql, so the program must import a driver for the database,
+// as the runLoadTest command does for PostgreSQL. ResultsDriver names
+// it, and defaults to the scheme of the DSN, eg postgres.
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const resultsTimeout = 30 * time.Second // to connect, create the table and insert
+
+// resultsTable is the columns of the history, in the order they're inserted
+const resultsTable = `CREATE TABLE IF NOT EXISTS loadtest_results (
+	started_at       TIMESTAMP NOT NULL,
+	version          VARCHAR(64),
+	config_hash      VARCHAR(64),
+	tags             TEXT,
+	duration_seconds DOUBLE PRECISION,
+	requests         BIGINT,
+	errors           BIGINT,
+	error_rate       DOUBLE PRECISION,
+	tps              DOUBLE PRECISION,
+	p50_seconds      DOUBLE PRECISION,
+	p90_seconds      DOUBLE PRECISION,
+	p99_seconds      DOUBLE PRECISION
+)`
+
+const resultsColumns = "started_at, version, config_hash, tags, duration_seconds, " +
+	"requests, errors, error_rate, tps, p50_seconds, p90_seconds, p99_seconds"
+
+// resultsDriver returns the driver for the ResultsDSN
+func (c Config) resultsDriver() string {
+	if c.ResultsDriver != "" {
+		return c.ResultsDriver
+	}
+	i := strings.Index(c.ResultsDSN, "://")
+	if i < 0 {
+		return ""
+	}
+	if scheme := c.ResultsDSN[:i]; scheme != "postgresql" {
+		return scheme
+	}
+	return "postgres"
+}
+
+// checkResultsDB returns an error if there's no driver for the ResultsDSN
+func checkResultsDB(c Config) error {
+	if c.ResultsDSN == "" {
+		if c.ResultsDriver != "" {
+			return fmt.Errorf("a results driver is only for a results DSN")
+		}
+		return nil
+	}
+	driver := c.resultsDriver()
+	if driver == "" {
+		return fmt.Errorf("the results DSN has no scheme, so needs a results driver, eg mysql")
+	}
+	for _, d := range sql.Drivers() {
+		if d == driver {
+			return nil
+		}
+	}
+	return fmt.Errorf("there's no database/sql driver %q for the results DSN, only %v", driver, sql.Drivers())
+}
+
+// configHash is a short hash of the settings and the run's parameters,
+// the same for runs of the same test. Only the tags are left out, as
+// they label a run rather than change it.
+func (c Config) configHash(filename string, tpsTarget, progressRate, startTps int, baseURL string) string {
+	seed := c.RandomSeed
+	if seed == 0 {
+		seed = randomSeed
+	}
+	params := []string{
+		fmt.Sprintf("input=%s", filename),
+		fmt.Sprintf("baseURL=%s", baseURL),
+		fmt.Sprintf("protocol=%s", protocolName(c.Protocol)),
+		fmt.Sprintf("tps=%d", tpsTarget),
+		fmt.Sprintf("progress=%d", progressRate),
+		fmt.Sprintf("startTps=%d", startTps),
+		fmt.Sprintf("step=%d", c.StepDuration),
+		fmt.Sprintf("seed=%d", seed),
+	}
+	sum := sha256.Sum256([]byte(strings.Join(append(params, c.settings()...), "\n")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// writeResultsDB inserts the summary of the run into the ResultsDSN,
+// with the hash of its settings
+func (lt *Runner) writeResultsDB(hash string) {
+	if err := insertResults(lt.conf, hash, lt.finalResults()); err != nil {
+		lt.warnf("could not save the results to the results database, %v\n", err)
+	}
+}
+
+// insertResults inserts a summary row, creating the table if need be
+func insertResults(c Config, hash string, r Results) error {
+	driver := c.resultsDriver()
+	db, err := sql.Open(driver, c.ResultsDSN)
+	if err != nil {
+		return err
+	}
+	defer db.Close() // nolint
+
+	ctx, cancel := context.WithTimeout(context.Background(), resultsTimeout)
+	defer cancel()
+	if _, err = db.ExecContext(ctx, resultsTable); err != nil {
+		return fmt.Errorf("creating the table, %v", err)
+	}
+	tags, err := json.Marshal(r.Tags)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx,
+		"INSERT INTO loadtest_results ("+resultsColumns+") VALUES ("+placeholders(driver, 12)+")",
+		r.Start.UTC(), Version, hash, string(tags), r.Duration.Seconds(),
+		r.Requests, r.Errors, r.ErrorRate(), r.TPS(),
+		r.P50.Seconds(), r.P90.Seconds(), r.P99.Seconds())
+	return err
+}
+
+// placeholders returns n parameters for a driver's dialect of SQL, eg
+// $1, $2 for PostgreSQL, and ?, ? for most others
+func placeholders(driver string, n int) string {
+	params := make([]string, n)
+	for i := range params {
+		params[i] = "?"
+		if strings.Contains(driver, "postgres") || driver == "pgx" {
+			params[i] = fmt.Sprintf("$%d", i+1)
+		}
+	}
+	return strings.Join(params, ", ")
+}
@@ -0,0 +1,71 @@
+package loadTesting
+
+// Retries resend a REST request that couldn't connect, or got a 502,
+// 503 or 504, up to Retries more times, as many clients do. Only the
+// idempotent methods are retried, as sending a POST or PATCH twice
+// may write twice, unless RetryNonIdempotent says it's safe. A
+// request is reported once, with the time all its attempts took and
+// the result of the last, and the retries are counted in the summary.
+// A request cut off at its envelope isn't retried.
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// idempotentMethods are those it's safe to send more than once
+var idempotentMethods = map[string]bool{
+	"GET":     true,
+	"HEAD":    true,
+	"PUT":     true,
+	"DELETE":  true,
+	"OPTIONS": true,
+	"TRACE":   true,
+}
+
+// do sends a request with p's client, retrying it if it failed and may be
+func (p *RestProto) do(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := p.send(req)
+		if attempt >= p.conf.Retries || !p.retryable(req, resp, err) {
+			return resp, err
+		}
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				// there's no way to send the body again
+				return resp, err
+			}
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return resp, err
+			}
+			req.Body = body
+		}
+		if err == nil {
+			io.Copy(ioutil.Discard, resp.Body) // nolint
+			resp.Body.Close()                  // nolint
+		}
+		if p.conf.Verbose {
+			p.debugf("retrying %s %s, attempt %d\n", req.Method, req.URL, attempt+2)
+		}
+		p.results.addRetry()
+	}
+}
+
+// retryable is true if a request failed in a way that's worth
+// retrying, and it's safe to
+func (p *RestProto) retryable(req *http.Request, resp *http.Response, err error) bool {
+	if !idempotentMethods[req.Method] && !p.conf.RetryNonIdempotent {
+		return false
+	}
+	if err != nil {
+		// but not if it was cut off, or couldn't be signed
+		return req.Context().Err() == nil && !isSigningError(err)
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
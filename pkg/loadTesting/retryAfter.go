@@ -0,0 +1,79 @@
+package loadTesting
+
+// RetryAfter makes the load test back off when a rate-limited server
+// asks it to, with a 429 or 503 and a Retry-After header, rather than
+// pressing on and measuring nothing but the server refusing it. All
+// the workers pause, as it's the test as a whole that's too fast.
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// retryAfter returns the time a response asks us to wait, or 0
+func retryAfter(resp *http.Response, now time.Time) time.Duration {
+	if resp.StatusCode != http.StatusTooManyRequests &&
+		resp.StatusCode != http.StatusServiceUnavailable {
+		return 0
+	}
+	h := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if h == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(h); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	when, err := http.ParseTime(h)
+	if err != nil || !when.After(now) {
+		return 0
+	}
+	return when.Sub(now)
+}
+
+// throttle pauses the workers for d, or leaves them paused if they
+// already are for longer. Only the time added is counted as throttled.
+func (lt *Runner) throttle(d time.Duration) {
+	now := time.Now().UnixNano()
+	until := now + int64(d)
+	for {
+		old := atomic.LoadInt64(&lt.pausedUntil)
+		if until <= old {
+			lt.results.addThrottle(0)
+			return
+		}
+		if atomic.CompareAndSwapInt64(&lt.pausedUntil, old, until) {
+			if old < now {
+				old = now
+			}
+			lt.results.addThrottle(time.Duration(until - old))
+			return
+		}
+	}
+}
+
+// waitIfThrottled sleeps until the workers are no longer paused, or
+// the run is stopped
+func (lt *Runner) waitIfThrottled() {
+	for {
+		wait := time.Until(time.Unix(0, atomic.LoadInt64(&lt.pausedUntil)))
+		if wait <= 0 {
+			return
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-lt.ctx.Done():
+			timer.Stop()
+			return
+		case <-lt.stopped:
+			timer.Stop()
+			return
+		}
+	}
+}
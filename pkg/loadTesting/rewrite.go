@@ -0,0 +1,65 @@
+package loadTesting
+
+// Rewriting paths lets a trace from one environment be replayed
+// against another with a different layout. After Strip, each of
+// Strips is removed, in order, then each PathRewrites pattern is
+// replaced, in sorted order, like the path weights. Like Strip, they
+// change the path, not its query string. Strip and Strips apply to the
+// whole of an absolute URL, so they can remove its scheme and host to
+// make it relative, and the rewrites to the path after any host left.
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// pathRewrite is a compiled path pattern and its replacement
+type pathRewrite struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// compileRewrites compiles the patterns, in sorted order
+func compileRewrites(rewrites map[string]string) ([]pathRewrite, error) {
+	var compiled []pathRewrite
+
+	patterns := make([]string, 0, len(rewrites))
+	for p := range rewrites {
+		patterns = append(patterns, p)
+	}
+	sort.Strings(patterns)
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("path rewrite pattern %q is not a regular expression, %v", p, err)
+		}
+		compiled = append(compiled, pathRewrite{re: re, replacement: rewrites[p]})
+	}
+	return compiled, nil
+}
+
+// rewritePath applies Strip and Strips to a path, or an absolute URL,
+// then the rewrites to the path, leaving any scheme and host alone
+func (lt *Runner) rewritePath(path string) string {
+	if lt.conf.Strip != "" {
+		path = stripPath(path, lt.conf.Strip)
+	}
+	for _, strip := range lt.conf.Strips {
+		path = stripPath(path, strip)
+	}
+	if len(lt.rewrites) == 0 {
+		return path
+	}
+	// it's absolute only if stripping left its scheme and host
+	origin, path := splitOrigin(path)
+	var query string
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path, query = path[:i], path[i:]
+	}
+	for _, r := range lt.rewrites {
+		path = r.re.ReplaceAllString(path, r.replacement)
+	}
+	return origin + path + query
+}
@@ -0,0 +1,110 @@
+package loadTesting
+
+// Routing sends some records over a different protocol from the rest,
+// so one run can model a request that fans out across tiers, such as
+// REST in front of S3. A record's protocol comes from a column in the
+// input, or from the first path pattern it matches, and is the run's
+// protocol otherwise. Each protocol has its own base URL, if set.
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// protocolRoute is a compiled path pattern and the protocol it's sent by
+type protocolRoute struct {
+	re       *regexp.Regexp
+	protocol string
+}
+
+// routeTable holds an operation for each protocol records are routed to,
+// created when the first record needs it
+type routeTable struct {
+	sync.Mutex
+	routes []protocolRoute
+	ops    map[string]operation
+}
+
+// compileRoutes compiles the patterns, in sorted order, like the
+// path weights, so which of several matches is reproducible
+func compileRoutes(routes map[string]string) ([]protocolRoute, error) {
+	var compiled []protocolRoute
+
+	patterns := make([]string, 0, len(routes))
+	for p := range routes {
+		patterns = append(patterns, p)
+	}
+	sort.Strings(patterns)
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("path protocol pattern %q is not a regular expression, %v", p, err)
+		}
+		compiled = append(compiled, protocolRoute{re: re, protocol: strings.ToLower(routes[p])})
+	}
+	return compiled, nil
+}
+
+// routing is true if records can go by different protocols
+func (lt *Runner) routing() bool {
+	return lt.conf.ProtocolField > 0 || len(lt.routes.routes) > 0
+}
+
+// recordProtocol returns the name of the protocol a record asks
+// for, or "" for the run's own
+func (lt *Runner) recordProtocol(record []string) string {
+	if f := lt.conf.ProtocolField; f > 0 && len(record) > f && record[f] != "" {
+		return strings.ToLower(record[f])
+	}
+	for _, r := range lt.routes.routes {
+		if r.re.MatchString(record[pathField]) {
+			return r.protocol
+		}
+	}
+	return ""
+}
+
+// checkProtocol returns an error if a record names an unknown protocol
+func (lt *Runner) checkProtocol(record []string) error {
+	if name := lt.recordProtocol(record); name != "" {
+		if _, present := protocolNames[name]; !present {
+			return fmt.Errorf("protocol %q is not one of rest, s3, gcs, azure, grpc or websocket", name)
+		}
+	}
+	return nil
+}
+
+// route returns the operation to send a record with: op, unless the
+// record is routed to another protocol
+func (lt *Runner) route(op operation, record []string) operation {
+	if !lt.routing() {
+		return op
+	}
+	name := lt.recordProtocol(record)
+	if name == "" {
+		return op
+	}
+
+	lt.routes.Lock()
+	defer lt.routes.Unlock()
+	if routed, present := lt.routes.ops[name]; present {
+		return routed
+	}
+	baseURL, present := lt.conf.ProtocolURLs[name]
+	if !present {
+		baseURL = lt.baseURL
+	}
+	if lt.routes.ops == nil {
+		lt.routes.ops = make(map[string]operation)
+	}
+	routed, err := lt.newOperation(protocolNames[name], baseURL)
+	if err != nil {
+		lt.fail(err)
+		return op
+	}
+	lt.routes.ops[name] = routed
+	return routed
+}
@@ -4,16 +4,22 @@ package loadTesting
 // input looks like "01-Mar-2017 16:00:00 0 0 0 0 path 404 GET"
 
 import (
+	"context"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"math/rand"
+	"net/http"
 	"os"
-	"strings"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 	//"github.com/aws/aws-sdk-go/service/s3"
-	"gopkg.in/fsnotify.v1"
 	//"google.golang.org/genproto/googleapis/watcher/v1"
 	"strconv"
 	"syscall"
@@ -26,13 +32,30 @@ const (
 	S3Protocol         // Amazon s3 protocol or compatable
 	CephProtocol       // reserved for native ceph protocol
 	TimeBudgetProtocol // see if we're inside our time budget
+	GCSProtocol        // Google Cloud Storage
+	AzureBlobProtocol  // Azure Blob Storage
+	GRPCProtocol       // unary gRPC calls
+	WebSocketProtocol  // messages over persistent WebSockets
 )
 
 // operations are the things a protocol must support
 type operation interface {
-	Init()
-	Get(path, oldRc string)
+	Init() error
+	Get(path, size, oldRc string)
 	Put(path, size, oldRc string)
+	Post(path, size, oldRc string)
+}
+
+// patcher is a protocol that can do partial updates, which object
+// stores can't
+type patcher interface {
+	Patch(path, size, oldRc string)
+}
+
+// perWorker is a protocol whose workers each need their own copy of
+// it, eg for a connection of their own
+type perWorker interface {
+	forWorker() operation
 }
 
 // These are the field names in the csv file
@@ -53,6 +76,11 @@ type Config struct {
 	Verbose      bool   // Extra info about requests
 	Debug        bool   // Extra info about program
 	Crash        bool   // Halt on any error
+	StrictInput  bool   // Halt on a malformed input line, instead of skipping it
+	FailFast     bool   // Stop the run, and return an error, at the first failure
+	CleanupAfter bool   // Delete the objects written, at the end of the run
+	SeedObjects  bool   // Write the objects the input reads, before the run
+	InputFormat  string // PathListFormat, or PerfFormat, the default
 	Serialize    bool   // FIXME semi-evil hack
 	Cache        bool   // allow caching
 	Tail         bool   // tail a log
@@ -61,294 +89,1173 @@ type Config struct {
 	S3Bucket     string // s3-specific options
 	S3Key        string
 	S3Secret     string
+	GCSCredsFile string // service-account file, instead of the default credentials
+	AzureConnStr string // azure connection string, or
+	AzureKey     string // azure account key
 	Strip        string
 	Timeout      time.Duration     // time to wait at end
-	StepDuration int               // duration of a test step
+	StepDuration int               // seconds per step of a progression, defaults to 10
 	HostHeader   string            // add a Host: header
+	HostTemplate string            // or one for each request, eg {path:0}.example.com
 	HeaderMap    map[string]string // one or more key:value headers
+	ExtraQuery   map[string]string // query parameters to add to every REST request
 	R            bool              // read tests allowed
 	W            bool              // write tests allowed
 	BufSize      int64             // max size of written file
+	UseCookieJar bool              // carry Set-Cookie forward to later requests
+	WorkerJars   bool              // one cookie jar per worker, ie, per user
+	IfNoneMatch  string            // make GETs conditional on an ETag, or
+	IfModSince   string            // on a date
+	MaxRequests  int               // stop after this many requests, 0 for no limit
+	WorkerPool   int               // send with this many goroutines, paced, instead of one per TPS
+	ClosedModel  bool              // each of VirtualUsers sends, waits and thinks, instead of a TPS
+	VirtualUsers int               // the users of a ClosedModel
+	ForceMethod  string            // send every request with this method, eg GET
+
+	MaxGoroutines int // don't start requests while this many goroutines run, 0 for no limit
+	MaxQueueDepth int // shed requests while this many are outstanding, 0 for no limit
+
+	MaxTotalBytes int64 // stop once this many bytes have been read and written, 0 for no limit
+
+	// Assertions, checked at the end, failing the run with ErrSLA
+	MaxP99       time.Duration // the slowest p99 latency that passes, 0 for any
+	MaxErrorRate float64       // the highest fraction of errors, eg 0.01, 0 for any
+	MinTPS       float64       // the lowest average TPS, 0 for any
+	MatchCodes   bool          // fail if any request doesn't get its recorded return code
+
+	ReadWriteRatio float64 // fraction of requests to send as GETs, the rest as PUTs, 0 to leave as is
+
+	FollowRotation bool // when tailing, reopen the log if it's rotated
+	Repeat         int  // play the input this many times then stop, 0 to wait for the timeout, -1 forever
+
+	RunDuration        time.Duration // stop after this long, 0 for no limit
+	SteadyRampDuration time.Duration // climb to a steady TPS over this long, 0 to start at it
+
+	SpeedupFactor float64 // send records at their times in the trace, this many times faster, 0 to ignore them
+
+	Shuffle       bool // send the records in a random order
+	ShuffleWindow int  // records to shuffle at once, 0 for 1000
+
+	// Timeouts
+	ConnectTimeout time.Duration // time to wait to connect, 0 for the OS default
+	RequestTimeout time.Duration // time to wait for a whole request, 0 for forever
+	DrainTimeout   time.Duration // time to wait for stragglers after a ramp, 0 for 10s
+	StartJitter    time.Duration // spread of the workers' start times, 0 for one tick
+	Arrivals       string        // PoissonArrivals or UniformArrivals, "" for one per tick
+
+	ThinkTimeDistribution string        // eg ExponentialThinkTime, instead of the Arrivals
+	ThinkTimeMean         time.Duration // the average time between a worker's requests, 0 for one tick
+	ThinkTimeSpread       float64       // of uniform, the fraction either side of the mean; of lognormal, sigma
+
+	LatencyTimeoutFactor float64       // time out requests at this times their recorded latency, 0 for none
+	MinRequestTimeout    time.Duration // the shortest such timeout
+
+	Retries            int  // times to retry a REST request that failed, 0 for never
+	RetryNonIdempotent bool // retry POSTs and PATCHes too, which may write twice
+
+	HostOverrides map[string]string // hostname: IP address to use instead of DNS
+	SuccessCodes  []int             // if set, the only codes that aren't errors
+
+	DisableKeepAlives bool // use a new connection for every request
+	HonorRetryAfter   bool // pause the workers when a 429 or 503 has a Retry-After
+	PauseSignal       bool // pause the run on SIGUSR1, and resume it on the next
+
+	// Socket tuning, for high connection rates
+	KeepAlivePeriod time.Duration // between TCP keep-alive probes, 0 for 30s, negative for none
+	LingerZero      bool          // close connections with a reset, so their ports skip TIME_WAIT
+
+	// Connection lifetimes, for long runs behind load balancers
+	MaxConnLifetime time.Duration // close connections this old after their request, 0 for never
+	IdleConnTimeout time.Duration // close connections idle this long, 0 for never
+
+	MaxBytesPerSec int64 // limit each REST request's transfers to this, 0 for no limit
+	MaxReadBytes   int64 // read at most this much of a REST or S3 GET, 0 for all of it
+
+	VerifyBytes     bool    // count successful GETs that aren't the recorded size
+	VerifyTolerance float64 // fraction of the size they may differ by, 0 for exactly
+
+	ExpectContentType  string            // type successful REST GETs must return, eg application/json
+	ExpectContentTypes map[string]string // or by path regexp, eg "^/img/": "image/*"
+	ExpectHeaders      map[string]string // headers successful REST GETs must have, eg X-Cache: HIT, or "*" for any value
+
+	Lifecycle  bool // PUT, GET and DELETE a new object for every record
+	ChunkedPut bool // stream REST PUTs chunked, without a Content-Length
+
+	DuplicateWrites   bool   // send each REST PUT and POST twice, with the same idempotency key
+	IdempotencyHeader string // the key's header, "" for Idempotency-Key
+
+	Preflight     bool   // check the target is reachable before starting
+	PreflightPath string // a path that must succeed, "" to HEAD the base URL
+
+	// Reproducibility
+	Deterministic bool  // send requests in input order at exact times, without jitter
+	RandomSeed    int64 // seed for everything random, 0 for the default
+
+	// S3 multipart uploads
+	MultipartThreshold int64 // upload objects bigger than this in parts, 0 for never
+	PartSize           int64 // size of a part, 0 for the library default
+	PartConcurrency    int   // parts to upload at once, 0 for the library default
+
+	// Reporting
+	ProgressInterval time.Duration // how often to report progress, 0 for never
+	ProgressWriter   io.Writer     // where to report it, nil for the log
+	ProgressBar      bool          // show a status line on stderr, updated every second
+	RecordOutput     string        // file to write replayable results to
+	HistogramFile    string        // file to write the latency distribution to
+	ResultsFile      string        // file to write the Results to, as JSON, to compare runs
+	OpenMetricsFile  string        // file to write the final metrics to, in OpenMetrics format
+	ResultsDSN       string        // database to add a summary of the run to, eg postgres://host/db
+	ResultsDriver    string        // its database/sql driver, "" for the DSN's scheme
+	PerPathStats     bool          // report the slowest paths, at a cost in memory
+	CPUProfile       string        // file to write a cpu profile of the run to
+	MemProfile       string        // file to write a heap profile to at the end
+	CaptureFailures  string        // file to write failed REST requests and responses to
+	MaxCaptures      int           // most failures to capture, 0 for 100
+
+	OutputFile    string // file to write each request to, instead of stdout
+	OutputMaxSize int64  // rotate it when it's this big, 0 for never
+	OutputRotate  int    // rotated files to keep, 0 for all of them
+	OutputBuffer  int    // lines queued for it, 0 for 10000
+	DropOutput    bool   // drop lines, and count them, if it can't keep up, instead of waiting
+
+	IntervalStatsFile string   // CSV file to write a row of stats to every ProgressInterval
+	IntervalColumns   []string // its columns, from IntervalColumns, nil for the defaults
+
+	RunTags map[string]string // labels for the results, eg git_sha: abc123, env: staging
+
+	PipeBuffer int // records to queue for the workers, 0 for 100
+
+	// Sampling
+	PathWeights map[string]float64 // path regexp: weight, eg "^/hot/": 10
+	WeightField int                // or the column with each record's weight
+
+	// Scenarios, to mix several scripts instead of reading the input, see scenarios.go
+	Scenarios []Scenario
+
+	// Routing, to send some records by another protocol, see routing.go
+	PathProtocols map[string]string // path regexp: protocol name, eg "^/objects/": "s3"
+	ProtocolField int               // or the column with each record's protocol
+	ProtocolURLs  map[string]string // protocol name: base URL, if not the run's
+	DefaultScheme string            // for REST base URLs without one, eg https, "" to refuse them
+
+	// Rewriting, after Strip, for a trace from a different layout
+	Strips       []string          // more text to strip from paths, in order
+	PathRewrites map[string]string // path regexp: replacement, eg "^/v[0-9]+/": "/v2/"
+
+	// Filtering, applied after Strip and rewriting
+	IncludePattern string   // if set, only send paths matching this regexp
+	ExcludePattern string   // don't send paths matching this one
+	Operations     []string // if set, only send records with these methods, eg GET
+
+	// Logger is where messages go, see logging.go, nil for the log package
+	Logger *slog.Logger
+
+	// OnResult is called after each request, always from the same
+	// goroutine, so it needn't be thread-safe. See onResult.go
+	OnResult func(RequestResult)
+
+	// SignRequest, if set, signs each REST request just before it's
+	// sent. It's called from every worker at once, on the hot path, so
+	// it must be quick and thread-safe. See signRequest.go
+	SignRequest func(*http.Request) error
+}
+
+// Runner is a single load test. Everything a test changes is in here,
+// so more than one can run in the same process.
+type Runner struct {
+	conf         Config
+	op           operation
+	opLock       sync.Mutex // as a directive can change op
+	opGeneration int64      // changed when it does
+	baseURL      string
+	streaming    bool       // the input is a pipe, not a file
+	random       *rand.Rand // for workers' start times
+	randomLock   sync.Mutex // as the workers share random
+	sampler      *rand.Rand // for sampling, used only by the reader
+	window       [][]string // records being shuffled, also only by the reader
+	out          *outputWriter
+	alive        chan bool
+	closed       chan bool
+	finished     chan bool // closed when the input has been played Repeat times
+	inFlight     int64     // requests started but not finished
+	queued       int       // requests queued for the workers
+	junkDataFile string
+	offeredRate  int64 // offered rate in TPS, for the log
+	workers      int64 // workers running
+	retiring     int64 // workers to stop, as a directive lowered the rate
+	poolBusy     int64 // requests the pacer had to wait for a free worker for
+	overLimit    int64 // requests not sent, as MaxGoroutines were running
+	unanswered   int64 // requests in a row that couldn't connect, and
+	answered     int64 // 1 once one did
+	openConns    int64 // connections open, and
+	dials        int64 // opened so far
+	pausedUntil  int64 // UnixNano the workers are paused until, per Retry-After
+	results      *stats
+	scenarios    []*scenarioScript // played instead of the input, if any
+	recorder     *perfWriter
+	captures     *captureWriter // failed requests, with CaptureFailures
+	pathWeights  []pathWeight
+	rewrites     []pathRewrite
+	contentTypes []contentTypeRule
+	replay       replayClock    // the reader's place in the trace, with SpeedupFactor
+	routes       routeTable     // other protocols to send records by
+	include      *regexp.Regexp // paths to send, or nil for all
+	exclude      *regexp.Regexp // paths not to send
+	bodies       bodyCache      // files to send as PUT and POST bodies
+	hook         *resultHook
+	stopped      chan bool // closed by stop, to end the run early
+	stopOnce     sync.Once
+	ctx          context.Context    // the requests', cancelled by stop
+	cancel       context.CancelFunc // which cancels it
+	pause        pauser             // with Pause, until Resume
+	failure      chan error         // the first failure, with FailFast
+	spent        chan int64         // the bytes transferred, once they reach MaxTotalBytes
+	created      createdSet         // paths written, to delete with CleanupAfter
+	logger
 }
 
-var OfferedRate int // Log offered rate in TPS
+var junkDataFiles int64 // for unique junk data file names
 
-var conf Config
-var op operation
-var random = rand.New(rand.NewSource(42))
-var pipe = make(chan []string, 100)
-var alive = make(chan bool, 1000)
-var closed = make(chan bool)
-var junkDataFile = "/tmp/LoadTestJunkDataFile"
+// NewRunner creates a load test with the given config
+func NewRunner(cfg Config) *Runner {
+	seed := cfg.RandomSeed
+	if seed == 0 {
+		seed = randomSeed
+	}
+	if cfg.StepDuration == 0 {
+		cfg.StepDuration = defaultStepDuration
+	}
+	return &Runner{
+		conf:     cfg,
+		random:   rand.New(rand.NewSource(seed)),
+		sampler:  rand.New(rand.NewSource(seed)),
+		alive:    make(chan bool, 1000),
+		closed:   make(chan bool),
+		finished: make(chan bool),
+		stopped:  make(chan bool),
+		ctx:      context.Background(),
+		cancel:   func() {},
+		failure:  make(chan error, 1),
+		spent:    make(chan int64, 1),
+		junkDataFile: filepath.Join(os.TempDir(), fmt.Sprintf("LoadTestJunkDataFile.%d.%d",
+			os.Getpid(), atomic.AddInt64(&junkDataFiles, 1))),
+		results: newStats(cfg.RunTags),
+		logger:  newLogger(cfg),
+	}
+}
+
+// Results returns a snapshot of the results so far
+func (lt *Runner) Results() Results {
+	return lt.results.snapshot()
+}
 
 const size = 396759652 // nolint // FIXME, this is a heuristic
+const randomSeed = 42  // so runs are repeatable
+const defaultDrainTimeout = 10 * time.Second
+const defaultStepDuration = 10 // seconds
+
+// RepeatForever plays the input again and again, until something else ends the run
+const RepeatForever = -1
+const defaultPipeBuffer = 100
+const workerTick = time.Second // each worker makes a request this often
 
-// RunLoadTest does whatever main figured out that the caller wanted.
+// Arrivals: how the time between a worker's requests varies
+const (
+	FixedArrivals   = ""        // exactly one tick
+	UniformArrivals = "uniform" // anywhere from half a tick to one and a half
+	PoissonArrivals = "poisson" // exponentially distributed, averaging one tick
+)
+
+// RunLoadTest does whatever main figured out that the caller wanted,
+// and returns the results of the run, with any error from Run. A run
+// that couldn't start, with an ErrConfig, has no results.
 func RunLoadTest(f *os.File, filename string, fromTime, forTime int,
-	tpsTarget, progressRate, startTps int, baseURL string, cfg Config) {
-	var processed = 0
-	conf = cfg
-	defer reportRUsage("RunLoadTest", time.Now())
+	tpsTarget, progressRate, startTps int, baseURL string, cfg Config) (Results, error) {
+	return RunLoadTestContext(context.Background(), f, filename, fromTime, forTime,
+		tpsTarget, progressRate, startTps, baseURL, cfg)
+}
 
-	if conf.Debug {
-		log.Printf("new runLoadTest(f, tpsTarget=%d, progressRate=%d, "+
-			"startTps=%d, fromTime=%d, forTime=%d, baseURL=%s)\n",
-			tpsTarget, progressRate, startTps, fromTime, forTime, baseURL)
+// RunLoadTestContext is RunLoadTest, stopping early if ctx is cancelled
+// or its deadline passes, as RunContext does
+func RunLoadTestContext(ctx context.Context, f *os.File, filename string, fromTime, forTime int,
+	tpsTarget, progressRate, startTps int, baseURL string, cfg Config) (Results, error) {
+	lt := NewRunner(cfg)
+	err := lt.RunContext(ctx, f, filename, fromTime, forTime, tpsTarget, progressRate, startTps, baseURL)
+	if errors.Is(err, ErrConfig) {
+		return Results{}, err
 	}
+	return lt.finalResults(), err
+}
 
-	// Figure out which set of operations to use
-	switch conf.Protocol {
+// newOperation creates and initializes a protocol's operations
+func (lt *Runner) newOperation(protocol int, baseURL string) (operation, error) {
+	var op operation
+
+	switch protocol {
 	case RESTProtocol:
-		op = RestProto{prefix: baseURL}
-		op.Init()
+		prefix, err := withScheme(baseURL, lt.conf.DefaultScheme)
+		if err != nil {
+			return nil, fmt.Errorf("%w, %v", ErrConfig, err)
+		}
+		op = &RestProto{Runner: lt, prefix: prefix}
 	case S3Protocol:
-		op = S3Proto{prefix: baseURL}
-		op.Init()
+		op = &S3Proto{Runner: lt, prefix: baseURL}
 	case TimeBudgetProtocol:
-		op = timeBudgetProto{prefix: baseURL}
-		op.Init()
+		op = &timeBudgetProto{Runner: lt, prefix: baseURL}
+	case GCSProtocol:
+		op = &GCSProto{Runner: lt, prefix: baseURL}
+	case AzureBlobProtocol:
+		op = &AzureBlobProto{Runner: lt, prefix: baseURL}
+	case GRPCProtocol:
+		op = &GRPCProto{Runner: lt, prefix: baseURL}
+	case WebSocketProtocol:
+		op = &WebSocketProto{Runner: lt, prefix: baseURL}
 	default:
-		log.Fatalf("protocol %d not implemented yet", conf.Protocol)
+		return nil, fmt.Errorf("%w, protocol %d not implemented yet", ErrConfig, protocol)
+	}
+	if err := op.Init(); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+// compilePatterns compiles the path weights, routes, rewrites,
+// content types and filters
+func (lt *Runner) compilePatterns() error {
+	var err error
+
+	if lt.pathWeights, err = compileWeights(lt.conf.PathWeights); err != nil {
+		return err
+	}
+	if lt.routes.routes, err = compileRoutes(lt.conf.PathProtocols); err != nil {
+		return err
+	}
+	if lt.rewrites, err = compileRewrites(lt.conf.PathRewrites); err != nil {
+		return err
+	}
+	if lt.contentTypes, err = compileContentTypes(lt.conf.ExpectContentTypes); err != nil {
+		return err
+	}
+	if lt.include, err = compilePattern("include", lt.conf.IncludePattern); err != nil {
+		return err
+	}
+	lt.exclude, err = compilePattern("exclude", lt.conf.ExcludePattern)
+	return err
+}
+
+// Run runs the load test. It returns an error, without starting, if
+// the config can't work, and an ErrSLA if it ran but an assertion failed.
+func (lt *Runner) Run(f *os.File, filename string, fromTime, forTime int,
+	tpsTarget, progressRate, startTps int, baseURL string) error {
+	return lt.RunContext(context.Background(), f, filename, fromTime, forTime,
+		tpsTarget, progressRate, startTps, baseURL)
+}
+
+// RunContext is Run, stopping early if ctx is cancelled or its deadline
+// passes. That stops the reader and the load generator, the workers
+// take no more work, and the REST, gRPC and object store requests in
+// flight are cancelled. The run returns at once, as it does at its
+// RunDuration, with ctx's error. The preflight check and seeding stop
+// early too, but the cleanup is still done, with a timeout of its own.
+func (lt *Runner) RunContext(ctx context.Context, f *os.File, filename string, fromTime, forTime int,
+	tpsTarget, progressRate, startTps int, baseURL string) (err error) {
+	var processed = 0
+
+	if err := lt.conf.Validate(); err != nil {
+		return fmt.Errorf("%w, %v", ErrConfig, err)
+	}
+	lt.ctx, lt.cancel = context.WithCancel(ctx)
+	defer lt.cancel()
+	if lt.conf.ClosedModel && progressRate != 0 {
+		return fmt.Errorf("%w, the closed model's load comes from its virtual users, so can't be a progression", ErrConfig)
+	}
+	if lt.conf.SteadyRampDuration > 0 && progressRate != 0 {
+		return fmt.Errorf("%w, a progression is already a ramp, so can't have a steady ramp too", ErrConfig)
+	}
+	if !lt.conf.ClosedModel && progressRate == 0 && tpsTarget <= 0 {
+		return fmt.Errorf("%w, a zero or negative tps target is not meaningful", ErrConfig)
+	}
+	if lt.conf.Protocol == RESTProtocol {
+		var err error
+		if baseURL, err = withScheme(baseURL, lt.conf.DefaultScheme); err != nil {
+			return fmt.Errorf("%w, %v", ErrConfig, err)
+		}
+	}
+	if len(lt.conf.Scenarios) > 0 {
+		scripts, err := lt.openScenarios()
+		if err != nil {
+			return err
+		}
+		defer closeScenarios(scripts)
+		lt.scenarios = scripts
+	}
+	if _, err := f.Seek(0, io.SeekCurrent); err != nil {
+		// a pipe, such as stdin, which we read as data arrives
+		lt.streaming = true
+		if lt.conf.Repeat != 0 {
+			return fmt.Errorf("%w, %s is a pipe, so it can't be repeated", ErrConfig, filename)
+		}
+	}
+	lt.adviseSockets(tpsTarget)
+	stopProfiling, err := lt.startProfiling()
+	if err != nil {
+		return fmt.Errorf("%w, %v", ErrConfig, err)
+	}
+	defer stopProfiling()
+	defer func() {
+		if err == nil {
+			err = lt.checkReachable()
+		}
+		if err == nil {
+			err = lt.checkAssertions()
+		}
+	}()
+	if lt.out, err = lt.createOutput(lt.conf.OutputFile); err != nil {
+		return fmt.Errorf("%w, %v", ErrConfig, err)
+	}
+	defer lt.closeOutput(lt.out)
+	defer lt.reportRUsage("RunLoadTest", time.Now())
+	defer lt.reportSummary()
+	// a pause at the end isn't part of the run
+	defer lt.Resume()
+	if lt.conf.PauseSignal {
+		defer lt.handlePauseSignal()()
+	}
+	if lt.conf.HistogramFile != "" {
+		defer lt.writeHistogram(lt.conf.HistogramFile)
+	}
+	if lt.conf.ResultsFile != "" {
+		defer lt.writeResults(lt.conf.ResultsFile)
+	}
+	if lt.conf.OpenMetricsFile != "" {
+		defer lt.writeOpenMetrics(lt.conf.OpenMetricsFile)
+	}
+	if lt.conf.ResultsDSN != "" {
+		defer lt.writeResultsDB(lt.conf.configHash(filename, tpsTarget, progressRate, startTps, baseURL))
+	}
+	// the run ends before it's reported, so the results don't change after
+	defer lt.results.finish()
+
+	if lt.conf.Debug {
+		lt.debugf("new runLoadTest(f, tpsTarget=%d, progressRate=%d, "+
+			"startTps=%d, fromTime=%d, forTime=%d, baseURL=%s)\n",
+			tpsTarget, progressRate, startTps, fromTime, forTime, baseURL)
+	}
+
+	// Figure out which set of operations to use
+	lt.baseURL = baseURL
+	if lt.op, err = lt.newOperation(lt.conf.Protocol, baseURL); err != nil {
+		return err
+	}
+	if lt.conf.Preflight {
+		if err := lt.preflight(ctx); err != nil {
+			return err
+		}
+	}
+	if lt.conf.CleanupAfter {
+		defer lt.cleanup(cleanupTimeout)
+	}
+
+	if err := lt.compilePatterns(); err != nil {
+		return fmt.Errorf("%w, %v", ErrConfig, err)
+	}
+	if lt.conf.PerPathStats {
+		lt.results.paths = make(map[string]*pathStats)
+	}
+
+	var seeds []seed
+	if lt.conf.SeedObjects {
+		var largest int64
+		seeds, largest, err = lt.readSeeds(f, filename)
+		if err != nil {
+			return err
+		}
+		if largest > lt.conf.BufSize {
+			// the data file has to hold the largest of them
+			lt.conf.BufSize = largest
+		}
+		if lt.conf.BufSize == 0 {
+			// and to exist, even if they're all empty
+			lt.conf.BufSize = 1
+		}
 	}
 
 	// Create data for rw and wo tests
-	if conf.BufSize > 0 {
-		log.Printf("Creating %d-byte data file %q\n", conf.BufSize,
-			junkDataFile)
-		mustCreateFilesystemFile(junkDataFile, conf.BufSize)
-		defer os.Remove(junkDataFile) // nolint
-	} else if conf.BufSize < 0 {
-		log.Fatalf("A negative size for data files (%d) is meaningless, halting\n", conf.BufSize)
+	if lt.conf.BufSize > 0 {
+		lt.infof("Creating %d-byte data file %q\n", lt.conf.BufSize,
+			lt.junkDataFile)
+		defer os.Remove(lt.junkDataFile) // nolint
+		if err := lt.createFilesystemFile(lt.junkDataFile, lt.conf.BufSize); err != nil {
+			return fmt.Errorf("%w, %v", ErrConfig, err)
+		}
+	}
+	if lt.conf.SeedObjects {
+		if err := lt.seedObjects(ctx, seeds); err != nil {
+			return err
+		}
+	}
+	if lt.conf.RecordOutput != "" {
+		if lt.recorder, err = createRecorder(lt.conf.RecordOutput, lt.logger); err != nil {
+			return fmt.Errorf("%w, %v", ErrConfig, err)
+		}
+		defer lt.recorder.close()
+	}
+	if lt.conf.CaptureFailures != "" {
+		if lt.captures, err = createCapture(lt.conf.CaptureFailures, lt.conf.MaxCaptures); err != nil {
+			return fmt.Errorf("%w, %v", ErrConfig, err)
+		}
+		defer lt.captures.close()
+	}
+	if lt.conf.OnResult != nil {
+		lt.hook = newResultHook(lt.conf.OnResult)
+		defer lt.hook.close()
+	}
+	if lt.conf.ProgressInterval > 0 {
+		done := make(chan bool)
+		defer close(done)
+		go lt.reportProgress(lt.conf.ProgressInterval, lt.conf.ProgressWriter, done)
+		defer lt.startIntervals(lt.conf.ProgressInterval)()
+	}
+	if lt.conf.ProgressBar {
+		defer lt.startProgressBar()()
+	}
+	if lt.conf.IntervalStatsFile != "" {
+		iw, err := lt.startIntervalStats(lt.conf.IntervalStatsFile, lt.conf.ProgressInterval)
+		if err != nil {
+			return fmt.Errorf("%w, %v", ErrConfig, err)
+		}
+		defer iw.close()
+	}
+	if lt.conf.Verbose {
+		done := make(chan bool)
+		defer close(done)
+		go lt.reportResources(resourceInterval, done)
 	}
 
+	// the queue of work from the input file to the workers
+	if lt.conf.PipeBuffer == 0 {
+		lt.conf.PipeBuffer = defaultPipeBuffer
+	}
+	pipe := make(chan []string, lt.conf.PipeBuffer)
+
 	// select some work to do from the input file
-	go workSelector(f, filename, fromTime, forTime, pipe)
+	go lt.workSelector(f, filename, fromTime, forTime, pipe)
 	// which pipes work to ...
-	go generateLoad(pipe, tpsTarget, progressRate, startTps, baseURL)
+	go lt.generateLoad(pipe, tpsTarget, progressRate, startTps, baseURL)
 	// which then writes to "alive", ...
+	var deadline <-chan time.Time // nil, so never, unless there's a RunDuration
+	var excused time.Duration     // pauses the deadline has been put off for
+	if lt.conf.RunDuration > 0 {
+		deadline = time.After(lt.conf.RunDuration)
+	}
 	for {
 		select {
-		case _, ok := <-alive:
+		case _, ok := <-lt.alive:
 			if !ok {
 				// if alive was closed, we're done
-				return
+				return nil
 			}
 			processed++
-		case <-time.After(time.Second * conf.Timeout):
+			if lt.conf.MaxRequests > 0 && processed >= lt.conf.MaxRequests {
+				lt.infof("%d requests completed, halting normally.\n", processed)
+				return nil
+			}
+		case err := <-lt.failure:
+			lt.infof("%d records processed\n", processed)
+			lt.infof("%v, halting at the first failure.\n", err)
+			lt.stop()
+			return err
+		case <-ctx.Done():
+			lt.infof("%d records processed\n", processed)
+			lt.infof("%v, halting.\n", ctx.Err())
+			lt.stop()
+			return ctx.Err()
+		case total := <-lt.spent:
+			lt.infof("%d records processed\n", processed)
+			lt.infof("%d bytes transferred, the most allowed, halting normally.\n", total)
+			lt.stopStarting()
+			lt.drain(lt.conf.DrainTimeout)
+			return nil
+		case <-deadline:
+			if extra := lt.pausedFor() - excused; extra > 0 {
+				// pauses don't count, so run for that much longer
+				excused += extra
+				deadline = time.After(extra)
+				continue
+			}
+			lt.infof("%d records processed\n", processed)
+			lt.infof("Ran for %s, halting normally.\n", lt.conf.RunDuration)
+			lt.stop()
+			return nil
+		case <-lt.finished:
+			lt.infof("%d records processed\n", processed)
+			lt.infof("Played the input %d times, halting normally.\n", lt.conf.Repeat)
+			return nil
+		case <-time.After(time.Second * lt.conf.Timeout):
+			if lt.isPaused() {
+				// there's no activity because we're paused
+				continue
+			}
 			// FIXME, this is memory-intensive
-			log.Printf("%d records processed\n", processed)
-			log.Printf("No activity after %d seconds, halting normally.\n",
-				conf.Timeout)
-			return
+			lt.infof("%d records processed\n", processed)
+			lt.infof("No activity after %d seconds, halting normally.\n",
+				lt.conf.Timeout)
+			return nil
 		}
 	}
 }
 
 // workSelector pipes a selection from a file to the workers
-func workSelector(f *os.File, filename string, startFrom, runFor int, pipe chan []string) { // nolint
-	var watcher *fsnotify.Watcher
+func (lt *Runner) workSelector(f *os.File, filename string, startFrom, runFor int, pipe chan []string) { // nolint
+	var t *tailer
 
-	if conf.Debug {
-		log.Printf("in workSelector(r, %s, startFrom=%d runFor=%d, pipe)\n", filename, startFrom, runFor)
+	if lt.conf.Debug {
+		lt.debugf("in workSelector(r, %s, startFrom=%d runFor=%d, pipe)\n", filename, startFrom, runFor)
+	}
+	if lt.scenarios != nil {
+		lt.playScenarios(lt.scenarios, runFor, pipe)
+		lt.infof("Scenarios finished, closing input pipe\n")
+		close(pipe)
+		return
 	}
-	if conf.Tail {
+	switch {
+	case lt.conf.Tail && lt.streaming:
+		// reading a pipe already waits for more data, and only
+		// gets an EOF when the writer's done
+		lt.infof("%s is a pipe, reading it as data arrives\n", filename)
+	case lt.conf.Tail:
 		// if we're tailing, start at the end
-		_, err := f.Seek(0, io.SeekEnd)
-		if err != nil {
-			log.Fatalf("Fatal error seeking to the end of %s: %s\n", filename, err)
+		var err error
+		if t, err = createTailer(f, filename, lt.conf.FollowRotation, lt.stopped, lt.logger); err != nil {
+			lt.fail(err)
+			close(pipe)
+			return
 		}
-		watcher, _ = fsnotify.NewWatcher()
-		if err != nil {
-			log.Fatalf("Fatal error setting up fsnotify for tail of %s: %s\n", filename, err)
-			// FIXME: to fall back to polling, set watcher to nil
+		defer t.close()
+	}
+
+	var r *csv.Reader
+	if t != nil {
+		r = newPerfReader(newDirectiveReader(t))
+	} else {
+		r = newPerfReader(newDirectiveReader(f))
+	}
+	passes := lt.conf.Repeat
+	if passes == 0 {
+		passes = 1
+	}
+	recNo := 0
+	for pass := 1; ; pass++ {
+		skipForward(startFrom, r, filename, lt.logger)
+		lt.replay = replayClock{}
+		n := lt.copyToPipe(runFor, r, filename, pipe, t)
+		recNo += n
+		if pass == passes || lt.capped() || lt.isStopped() {
+			break
 		}
-		defer watcher.Close() // nolint
-		err = watcher.Add(filename)
-		if err != nil {
-			log.Fatalf("Fatal error addding %s to fsnotify: %s\n", filename, err)
+		if n == 0 {
+			lt.infof("%s has no records to repeat\n", filename)
+			break
 		}
-		log.Printf("seeked to the end of %s, doing a tail -f with normal timeouts\n",
-			filename)
+		// play it again, from the beginning
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			lt.fail(fmt.Errorf("could not rewind %s, %v", filename, err))
+			break
+		}
+		r = newPerfReader(newDirectiveReader(f))
 	}
+	lt.infof("EOF: loaded %d records, closing input pipe\n", recNo)
+	close(pipe)
+}
 
+// newPerfReader reads space-separated perf-format records, skipping comments
+func newPerfReader(f io.Reader) *csv.Reader {
 	r := csv.NewReader(f)
 	r.Comma = ' '
 	r.Comment = '#'
 	r.FieldsPerRecord = -1 // ignore differences
-
-	skipForward(startFrom, r, filename)
-	recNo := copyToPipe(runFor, r, filename, pipe, watcher)
-	log.Printf("EOF: loaded %d records, closing input pipe\n", recNo)
-	close(pipe)
+	return r
 }
 
 // copyToPipe pipes work to the workers
-func copyToPipe(runFor int, r *csv.Reader, filename string, pipe chan []string, watcher *fsnotify.Watcher) int {
+func (lt *Runner) copyToPipe(runFor int, r *csv.Reader, filename string, pipe chan []string, t *tailer) int {
 
-	recNo := 0
+	recNo, filtered, malformed := 0, 0, 0
 forloop:
 	for ; recNo < runFor; recNo++ {
 		record, err := r.Read()
 		switch {
-		case err == io.EOF && conf.Tail:
+		case err == io.EOF && t != nil:
 			// just keep reading, even if we truncate...
-			if watcher == nil {
-				time.Sleep(100 * time.Millisecond)
-			} else {
-				//log.Print("waiting for fsnotify\n")
-				if err = waitForChange(watcher); err != nil {
-					log.Fatalf("Fatal error waiting for fsnotify on %s, %v\n", filename, err)
-				}
+			if err = t.wait(); err != nil {
+				lt.fail(fmt.Errorf("could not follow %s, %v", filename, err))
+				break forloop
+			}
+			if lt.isStopped() {
+				break forloop
 			}
 			continue
 		case err == io.EOF:
-			log.Printf("At EOF on %s, no new work to queue\n", filename)
+			lt.infof("At EOF on %s, no new work to queue\n", filename)
 			break forloop
 		case err != nil:
-			log.Printf("Fatal error mid-way reading %s, stopping: %s\n", filename, err)
+			lt.warnf("Fatal error mid-way reading %s, stopping: %s\n", filename, err)
 			break forloop
 		}
-		if len(record) < 9 {
-			log.Printf("ill-formed record %q ignored\n",
-				record)
+		if t != nil {
+			t.read()
+		}
+		if isDirective(record) {
+			// pass it on in order, so it applies after the records before it
+			if lt.conf.Shuffle && !lt.flushWindow(pipe) {
+				break forloop
+			}
+			if !lt.send(pipe, record) {
+				break forloop
+			}
+			continue
+		}
+		record, err = lt.parse(record)
+		if err != nil {
 			// Warning: this discards real-time part-records
+			line, _ := r.FieldPos(0)
+			if lt.conf.StrictInput {
+				lt.fail(fmt.Errorf("%w, %s line %d is malformed, %v", ErrConfig, filename, line, err))
+				break forloop
+			}
+			lt.warnf("%s line %d is malformed, %v, ignored\n", filename, line, err)
+			malformed++
 			continue
 		}
+		wanted, more := lt.offer(pipe, record)
+		if !wanted {
+			filtered++
+		}
+		if !more {
+			break forloop
+		}
+	}
+	if lt.conf.Shuffle {
+		lt.flushWindow(pipe)
+	}
+	if filtered > 0 {
+		lt.infof("%d records filtered out by path or method\n", filtered)
+	}
+	if malformed > 0 {
+		lt.warnf("%d malformed records ignored\n", malformed)
+	}
+	return recNo
+}
+
+// offer queues a parsed record for the workers, as many times as its
+// weight says. It returns false if the record was filtered out, and
+// false again if no more records should be queued.
+func (lt *Runner) offer(pipe chan []string, record []string) (bool, bool) {
+	record[pathField] = lt.rewritePath(record[pathField])
+	if lt.excluded(record[pathField]) || lt.unwanted(record[operatorField]) {
+		return false, true
+	}
+	if lt.conf.ForceMethod != "" {
+		// whatever the input says, eg, to never write to production
+		record[operatorField] = lt.conf.ForceMethod
+	}
+	if lt.conf.ReadWriteRatio > 0 {
+		// the bytes field is the size of a PUT, or was of a GET
+		record[operatorField] = lt.readOrWrite()
+	}
+	//log.Printf("writing %v to pipe\n", record)
 
-		if conf.Strip != "" {
-			record[pathField] = strings.Replace(record[pathField], conf.Strip, "", 1)
+	if lt.conf.SpeedupFactor > 0 && !lt.waitForTraceTime(record) {
+		return true, false
+	}
+	copies := 1
+	if lt.sampling() {
+		copies = lt.copiesOf(lt.weightOf(record))
+	}
+	for ; copies > 0; copies-- {
+		if lt.capped() {
+			lt.infof("Sent the maximum of %d requests, no new work to queue\n", lt.queued)
+			return true, false
 		}
-		//log.Printf("writing %v to pipe\n", record)
+		if !lt.queue(pipe, record) {
+			return true, false
+		}
+		lt.queued++
+	}
+	return true, true
+}
 
-		pipe <- record
+// send queues a record for the workers. It's false if the run
+// was stopped instead.
+func (lt *Runner) send(pipe chan []string, record []string) bool {
+	select {
+	case pipe <- record:
+		return true
+	case <-lt.stopped:
+		return false
 	}
-	return recNo
 }
 
-// generateLoad starts progressRate new threads every 10 seconds until we hit progressRate
-func generateLoad(pipe chan []string, tpsTarget, progressRate, startTps int, urlPrefix string) {
-	if conf.Debug {
-		log.Printf("generateLoad(pipe, tpsTarget=%d, progressRate=%d, from, for, prefix\n",
+// stop stops the workers, and the reader, without waiting for them,
+// and cancels the requests in flight
+func (lt *Runner) stop() {
+	lt.stopStarting()
+	lt.cancel()
+}
+
+// stopStarting stops the workers and the reader, but leaves the
+// requests in flight to finish, so they can be drained
+func (lt *Runner) stopStarting() {
+	lt.stopOnce.Do(func() { close(lt.stopped) })
+}
+
+// isStopped is true once stop has been called
+func (lt *Runner) isStopped() bool {
+	select {
+	case <-lt.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+// capped is true if we've queued the maximum number of requests
+func (lt *Runner) capped() bool {
+	return lt.conf.MaxRequests > 0 && lt.queued >= lt.conf.MaxRequests
+}
+
+// generateLoad starts progressRate new threads every StepDuration seconds until we hit tpsTarget
+func (lt *Runner) generateLoad(pipe chan []string, tpsTarget, progressRate, startTps int, urlPrefix string) {
+	if lt.conf.Debug {
+		lt.debugf("generateLoad(pipe, tpsTarget=%d, progressRate=%d, from, for, prefix\n",
 			tpsTarget, progressRate)
 	}
 
-	fmt.Print("#yyy-mm-dd hh:mm:ss latency xfertime thinktime bytes url rc op offered\n")
+	lt.printHeader(tpsTarget, progressRate, startTps, urlPrefix)
+	lt.outf(columnNames)
 	switch {
+	case lt.conf.ClosedModel:
+		lt.runClosedModel(pipe)
 	case progressRate != 0:
-		runProgressivelyIncreasingLoad(progressRate, tpsTarget, startTps, pipe)
-	case tpsTarget != 0:
-		runSteadyLoad(tpsTarget, pipe)
-	case tpsTarget <= 0:
-		log.Fatal("A zero or negative tps target is not meaningful, halting\n")
+		lt.runProgressivelyIncreasingLoad(progressRate, tpsTarget, startTps, pipe)
+	default:
+		// RunContext has checked it's positive
+		lt.runSteadyLoad(tpsTarget, pipe)
 	}
 }
 
 // run at a steady tps until the end of the data
-func runSteadyLoad(tpsTarget int, pipe chan []string) {
-	log.Printf("starting, at %d requests/second\n", tpsTarget)
-	OfferedRate = tpsTarget
+func (lt *Runner) runSteadyLoad(tpsTarget int, pipe chan []string) {
+	lt.infof("starting, at %d requests/second\n", tpsTarget)
+	ramped := lt.conf.SteadyRampDuration > 0
+	if ramped {
+		atomic.StoreInt64(&lt.offeredRate, 1)
+	} else {
+		atomic.StoreInt64(&lt.offeredRate, int64(tpsTarget))
+	}
 	// start tpsTarget workers
-	for i := 0; i < tpsTarget; i++ {
-		go worker(pipe)
+	var workers sync.WaitGroup
+	switch {
+	case lt.conf.Deterministic:
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			lt.scheduler(pipe)
+		}()
+	case lt.conf.WorkerPool > 0:
+		lt.startPool(pipe, &workers)
+	}
+	if ramped {
+		lt.rampToSteady(tpsTarget, pipe, &workers)
+	}
+	for i := 0; i < tpsTarget && !lt.paced() && !ramped; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			lt.worker(pipe)
+		}()
 	}
+	if lt.conf.Repeat == 0 {
+		// run until there's no activity
+		return
+	}
+	// otherwise stop once the workers have used up the input
+	workers.Wait()
+	close(lt.closed)
+	lt.drain(lt.conf.DrainTimeout)
+	close(lt.finished)
 }
 
 // runProgressivelyIncreasingLoad, the classic load test
-func runProgressivelyIncreasingLoad(progressRate, tpsTarget, startTps int, pipe chan []string) {
+func (lt *Runner) runProgressivelyIncreasingLoad(progressRate, tpsTarget, startTps int, pipe chan []string) {
 
 	// start the first workers
 	if startTps == 0 {
 		startTps = progressRate
 	}
 	rate := startTps
-	OfferedRate = startTps
-	for i := 0; i < startTps; i++ {
-		go worker(pipe)
+	atomic.StoreInt64(&lt.offeredRate, int64(startTps))
+	switch {
+	case lt.conf.Deterministic:
+		go lt.scheduler(pipe)
+	case lt.conf.WorkerPool > 0:
+		lt.startPool(pipe, &sync.WaitGroup{})
+	}
+	for i := 0; i < startTps && !lt.paced(); i++ {
+		go lt.worker(pipe)
 	}
 	// add to the workers until we have enough
-	log.Printf("now at %d requests/second\n", rate)
-	for range time.Tick(time.Duration(conf.StepDuration) * time.Second) { // nolint
-		//start another progressRate of workers
-		rate += progressRate
-		OfferedRate = rate
+	lt.infof("now at %d requests/second\n", rate)
+	for range time.Tick(time.Duration(lt.conf.StepDuration) * time.Second) { // nolint
+		//start another progressRate of workers, from wherever a directive set it
+		rate = int(atomic.LoadInt64(&lt.offeredRate)) + progressRate
+		atomic.StoreInt64(&lt.offeredRate, int64(rate))
 		if rate > tpsTarget {
 			// OK, we're past the range, quit.
-			log.Printf("completed maximum rate, starting %d sec cleanup timer\n", conf.Timeout)
+			lt.infof("completed maximum rate, starting %d sec cleanup timer\n", lt.conf.Timeout)
 			break
 		}
-		for i := 0; i < progressRate; i++ {
-			go worker(pipe)
+		for i := 0; i < progressRate && !lt.paced(); i++ {
+			go lt.worker(pipe)
 		}
-		log.Printf("now at %d requests/second\n", rate)
-		fmt.Printf("#TPS=%d\n", rate) // add as a column?
+		lt.infof("now at %d requests/second\n", rate)
+		lt.outf("#TPS=%d\n", rate) // add as a column?
 	}
-	// let them run for a cycle and shut down
-	time.Sleep(time.Duration(10 * float64(time.Second)))
+	// stop starting new requests, and let the ones in flight finish
 	// this needs refactoring
-	close(closed)
+	close(lt.closed)
+	lt.drain(lt.conf.DrainTimeout)
+}
+
+// drain waits for in-flight requests, reporting how many finished
+func (lt *Runner) drain(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = defaultDrainTimeout
+	}
+	before := lt.results.snapshot().Requests
+	lt.infof("draining in-flight requests for up to %s\n", timeout)
+	deadline := time.Now().Add(timeout)
+	for atomic.LoadInt64(&lt.inFlight) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	lt.infof("%d requests completed while draining\n",
+		lt.results.snapshot().Requests-before)
 }
 
 // worker reads and executes a task every second until it hits eof
-func worker(pipe chan []string) {
-	if conf.Debug {
-		log.Print("started a worker\n")
+func (lt *Runner) worker(pipe chan []string) {
+	if lt.conf.Debug {
+		lt.debugf("started a worker\n")
 	}
-	if conf.Protocol == TimeBudgetProtocol {
+	atomic.AddInt64(&lt.workers, 1)
+	defer atomic.AddInt64(&lt.workers, -1)
+	wop, gen := lt.workerOp()
+	if lt.conf.Protocol == TimeBudgetProtocol {
 		// Do the operation immediately, once, to measure it's speed
-		doWork()
+		lt.doWork(wop, pipe, lt.start)
 		return
 	}
-	// wait a random fraction of one second before looping, for randomness.
-	time.Sleep(time.Duration(random.Float64() * float64(time.Second)))
+	// wait a random fraction of a tick before looping, so the workers'
+	// requests are spread evenly across it
+	jitter := lt.conf.StartJitter
+	if jitter <= 0 {
+		jitter = workerTick
+	}
+	time.Sleep(time.Duration(lt.randomFloat64() * float64(jitter)))
 
-	for range time.Tick(1 * time.Second) { // nolint
-		done := doWork()
+	if lt.conf.Arrivals != FixedArrivals || lt.conf.ThinkTimeDistribution != NoThinkTime {
+		for {
+			time.Sleep(lt.interval())
+			wop, gen = lt.latestOp(wop, gen)
+			if lt.retire() || lt.doWork(wop, pipe, lt.start) {
+				return
+			}
+		}
+	}
+	for range time.Tick(workerTick) { // nolint
+		wop, gen = lt.latestOp(wop, gen)
+		done := lt.retire() || lt.doWork(wop, pipe, lt.start)
 		if done {
 			return
 		}
 	}
 }
 
-// work is the thing that happens each second.
-func doWork() bool {
+// scheduler replaces the workers in deterministic mode. It sends the
+// requests in input order, at intervals of a tick divided by the offered
+// rate, so two runs send the same requests at the same times. If it
+// falls more than a tick behind, it starts again from now rather than
+// sending a burst to catch up.
+func (lt *Runner) scheduler(pipe chan []string) {
+	if lt.conf.Debug {
+		lt.debugf("started the scheduler\n")
+	}
+	atomic.AddInt64(&lt.workers, 1)
+	defer atomic.AddInt64(&lt.workers, -1)
+	op, gen := lt.workerOp()
+	next := time.Now()
+	for {
+		time.Sleep(time.Until(next))
+		op, gen = lt.latestOp(op, gen)
+		if lt.doWork(op, pipe, lt.start) {
+			return
+		}
+		next = next.Add(lt.interval() / time.Duration(atomic.LoadInt64(&lt.offeredRate)))
+		if time.Since(next) > workerTick {
+			next = time.Now()
+		}
+	}
+}
+
+// workerOp returns the operations a single worker uses. Normally that's
+// the shared op, but with per-worker cookie jars each worker is a
+// separate user, with its own session, and some protocols give each
+// worker a connection of its own. It also returns the generation
+// of op, to see if a directive has changed it since.
+func (lt *Runner) workerOp() (operation, int64) {
+	lt.opLock.Lock()
+	defer lt.opLock.Unlock()
+	gen := atomic.LoadInt64(&lt.opGeneration)
+	if rp, ok := lt.op.(*RestProto); ok && lt.conf.UseCookieJar && lt.conf.WorkerJars {
+		return rp.withCookieJar(), gen
+	}
+	if pw, ok := lt.op.(perWorker); ok {
+		return pw.forWorker(), gen
+	}
+	return lt.op, gen
+}
+
+// doWork gets a record and sends it, with run, in the background or
+// not. It's true at the end of the input.
+func (lt *Runner) doWork(op operation, pipe chan []string, run func(func())) bool {
 	var r []string
 
-	r, eof := getWork()
+	lt.waitIfThrottled()
+	lt.waitIfPaused()
+	r, eof := lt.getWork(pipe)
 	if eof {
 		return true
 	}
 
 	switch {
 	case r == nil:
-		log.Print("worker reached EOF, no more requests to send.\n")
+		lt.infof("worker reached EOF, no more requests to send.\n")
 		return true
-	case len(r) < 9:
-		// bad input data, crash
-		log.Fatalf("number of fields < 9 in %v", r)
-	case r[operatorField] == "GET" && conf.R:
-		go op.Get(r[pathField], r[returnCodeField])
-	case r[operatorField] == "PUT" && conf.W:
-		go op.Put(r[pathField], r[bytesField], r[returnCodeField])
+	case isDirective(r):
+		lt.applyDirective(r, pipe)
+		return false
+	}
+	op = lt.route(op, r)
+	if rp, ok := op.(*RestProto); ok && lt.conf.HostTemplate != "" {
+		op = rp.withHost(lt.expandHost(r))
+	}
+	if rp, ok := op.(*RestProto); ok && lt.conf.LatencyTimeoutFactor > 0 {
+		op = rp.withTimeout(lt.envelope(r))
+	}
+
+	switch {
+	case lt.conf.Lifecycle:
+		lc, ok := op.(lifecycler)
+		if !ok {
+			lt.warnf("this protocol can't do lifecycle tests, %v ignored\n", r)
+			break
+		}
+		run(func() { lc.Lifecycle(r[pathField], r[bytesField]) })
+	case r[operatorField] == "GET" && lt.conf.R:
+		run(func() { op.Get(r[pathField], r[bytesField], r[returnCodeField]) })
+	case r[operatorField] == "PUT" && lt.conf.W:
+		if lt.conf.CleanupAfter {
+			lt.created.add(op, r[pathField])
+		}
+		run(func() { op.Put(r[pathField], r[bytesField], r[returnCodeField]) })
+	case r[operatorField] == "POST" && lt.conf.W:
+		run(func() { op.Post(r[pathField], r[bytesField], r[returnCodeField]) })
+	case r[operatorField] == "PATCH" && lt.conf.W:
+		pa, ok := op.(patcher)
+		if !ok {
+			lt.warnf("this protocol can't PATCH, %v ignored\n", r)
+			break
+		}
+		run(func() { pa.Patch(r[pathField], r[bytesField], r[returnCodeField]) })
 	//case r[operatorField] == "DELE":
 	//	go op.Dele(r[pathField], r[bytesField], r[returnCodeField]) // nolint
 	//case r[operatorField] == "HEAD":
 	//	go op.Head(r[pathField], r[bytesField], r[returnCodeField]) // nolint
 	default:
-		log.Printf("unimplemented operation %s in %v, ignored\n", r[operatorField], r)
+		lt.warnf("unimplemented operation %s in %v, ignored\n", r[operatorField], r)
 	}
 	return false
 }
 
+// start makes a request in the background, counting it as in flight,
+// unless there are already MaxGoroutines goroutines, or MaxQueueDepth
+// requests in flight
+func (lt *Runner) start(request func()) {
+	if lt.overGoroutineLimit() || !lt.admit() {
+		return
+	}
+	go func() {
+		defer atomic.AddInt64(&lt.inFlight, -1)
+		request()
+	}()
+}
+
+// overGoroutineLimit is true if starting another request would risk
+// the generator itself, as the target has stalled and requests are
+// piling up. It's a safety rail, not a way of shaping the load: the
+// requests are dropped, and counted, or with FailFast the run fails.
+func (lt *Runner) overGoroutineLimit() bool {
+	if lt.conf.MaxGoroutines <= 0 || runtime.NumGoroutine() < lt.conf.MaxGoroutines {
+		return false
+	}
+	if atomic.AddInt64(&lt.overLimit, 1) == 1 {
+		lt.warnf("%d goroutines are running, dropping requests until some finish\n",
+			runtime.NumGoroutine())
+	}
+	if lt.conf.FailFast {
+		lt.fail(fmt.Errorf("%w, more than the maximum of %d goroutines are waiting for it",
+			ErrUnreachable, lt.conf.MaxGoroutines))
+	}
+	return true
+}
+
+// randomFloat64 is random.Float64, safe for the workers to share
+func (lt *Runner) randomFloat64() float64 {
+	lt.randomLock.Lock()
+	defer lt.randomLock.Unlock()
+	return lt.random.Float64()
+}
+
+// interval returns the time until a worker's next request. With
+// Poisson arrivals at each worker, the arrivals of all of them are
+// also Poisson, at the sum of their rates.
+func (lt *Runner) interval() time.Duration {
+	lt.randomLock.Lock()
+	defer lt.randomLock.Unlock()
+	if lt.conf.ThinkTimeDistribution != NoThinkTime {
+		return lt.thinkTime()
+	}
+	switch lt.conf.Arrivals {
+	case PoissonArrivals:
+		return time.Duration(lt.random.ExpFloat64() * float64(workerTick))
+	case UniformArrivals:
+		return time.Duration((0.5 + lt.random.Float64()) * float64(workerTick))
+	}
+	return workerTick
+}
+
 // getWork gets stuff for worker to do
-func getWork() ([]string, bool) {
+func (lt *Runner) getWork(pipe chan []string) ([]string, bool) {
 	var r []string
 	var ok bool
 
 	select {
-	case <-closed:
+	case <-lt.stopped:
+		return nil, true
+	case <-lt.closed:
 		// peculiar to increasing load test, refactor
-		if conf.Debug {
-			log.Print("pipe closed, no more requests to process.\n")
+		if lt.conf.Debug {
+			lt.debugf("pipe closed, no more requests to process.\n")
 		}
 		return nil, true
 	case r, ok = <-pipe:
@@ -356,59 +1263,106 @@ func getWork() ([]string, bool) {
 			// We're at eof
 			return nil, true
 		}
-		if conf.Debug {
-			log.Printf("got %v\n", r)
+		if lt.conf.Debug {
+			lt.debugf("got %v\n", r)
 		}
 		return r, false
 	}
 }
 
-// waitForChange waits for the tail of a file to be written to
-// cargo courtesy Satyajit Ranjeev, http://satran.in/2017/11/15/Implementing_tails_follow_in_go.html
-func waitForChange(w *fsnotify.Watcher) error {
-	for {
-		select {
-		case event := <-w.Events:
-			if event.Op&fsnotify.Write == fsnotify.Write {
-				return nil
-			}
-		case err := <-w.Errors:
-			return err
-		}
-	}
-}
-
 // reportPerformance in standard format
-func reportPerformance(initial time.Time, latency time.Duration,
+func (lt *Runner) reportPerformance(initial time.Time, latency time.Duration,
 	transferTime time.Duration, body []byte, path string,
 	rc int, oldRc string) {
 	var annotation = ""
 
-	if oldRc != "" {
-		old, _ := strconv.Atoi(oldRc)
-		if rc != old && old != 0 {
-			annotation = fmt.Sprintf(" expected=%d", old)
-		}
+	if codeMismatch(rc, oldRc) {
+		annotation = " expected=" + oldRc
+		lt.results.addWrongCode()
 	}
-	fmt.Printf("%s %f %f 0 %d %s %d GET %d %s\n",
+	lt.outf("%s %f %f 0 %d %s %d GET %d %s\n",
 		initial.Format("2006-01-02 15:04:05.000"),
 		latency.Seconds(), transferTime.Seconds(), len(body), path,
-		rc, OfferedRate, annotation)
+		rc, atomic.LoadInt64(&lt.offeredRate), annotation)
+	failed := lt.failed(rc, oldRc)
+	lt.results.add("GET", path, latency+transferTime, rc, failed)
+	lt.countRead(body)
+	lt.countAnswer(rc)
+	if lt.recorder != nil {
+		if err := lt.recorder.record(initial, latency, transferTime, strconv.Itoa(len(body)), path, rc, "GET"); err != nil {
+			lt.fail(err)
+		}
+	}
+	lt.notify("GET", initial, latency, transferTime, strconv.Itoa(len(body)), path, rc, failed)
+	if failed && lt.conf.FailFast {
+		lt.failFast("GET", path, rc)
+	}
+}
+
+// reportPut reports a PUT in standard format
+func (lt *Runner) reportPut(initial time.Time, latency, transferTime time.Duration,
+	size, path string, rc int, oldRc string) {
+	lt.reportWrite("PUT", initial, latency, transferTime, size, path, rc, oldRc)
+}
+
+// reportWrite reports a PUT or POST in standard format
+func (lt *Runner) reportWrite(op string, initial time.Time, latency, transferTime time.Duration,
+	size, path string, rc int, oldRc string) {
+	lt.outf("%s %f %f 0 %s %s %d %s\n",
+		initial.Format("2006-01-02 15:04:05.000"),
+		latency.Seconds(), transferTime.Seconds(), size, path, rc, op)
+	if codeMismatch(rc, oldRc) {
+		lt.results.addWrongCode()
+	}
+	failed := lt.failed(rc, oldRc)
+	lt.results.add(op, path, latency+transferTime, rc, failed)
+	lt.countWritten(size, rc)
+	lt.countAnswer(rc)
+	if lt.recorder != nil {
+		if err := lt.recorder.record(initial, latency, transferTime, size, path, rc, op); err != nil {
+			lt.fail(err)
+		}
+	}
+	lt.notify(op, initial, latency, transferTime, size, path, rc, failed)
+	if failed && lt.conf.FailFast {
+		lt.failFast(op, path, rc)
+	}
+}
+
+// failFast passes the first failure to Run, which stops the run
+func (lt *Runner) failFast(op, path string, rc int) {
+	descr, _ := codeDescr(rc)
+	kind := ErrTooManyErrors
+	if rc == 599 {
+		// it couldn't connect
+		kind = ErrUnreachable
+	}
+	lt.fail(fmt.Errorf("%w, %s %s failed, %s", kind, op, path, descr))
+}
+
+// fail passes an error to Run, which stops the run, unless there
+// already is one
+func (lt *Runner) fail(err error) {
+	select {
+	case lt.failure <- err:
+	default:
+		// another request failed first
+	}
 }
 
 // reportRusage reports cpu-seconds, memory and IOPS used
-func reportRUsage(name string, start time.Time) {
+func (lt *Runner) reportRUsage(name string, start time.Time) {
 	var r syscall.Rusage
 
 	err := syscall.Getrusage(syscall.RUSAGE_SELF, &r)
 	if err != nil {
-		log.Fatal(err)
-		log.Printf("%s %s %d no resource usage available\n",
+		lt.warnf("%v\n", err)
+		lt.infof("%s %s %d no resource usage available\n",
 			start.Format("2006-01-02 15:04:05.000"), name, os.Getpid())
 		return
 	}
-	log.Printf("#date      time         name        pid  utime stime maxrss inblock outblock\n")
-	log.Printf("%s %s %d %f %f %d %d %d\n", start.Format("2006-01-02 15:04:05.000"),
+	lt.infof("#date      time         name        pid  utime stime maxrss inblock outblock\n")
+	lt.infof("%s %s %d %f %f %d %d %d\n", start.Format("2006-01-02 15:04:05.000"),
 		name, os.Getpid(), seconds(r.Utime), seconds(r.Stime), r.Maxrss*1024, r.Inblock, r.Oublock)
 }
 
@@ -0,0 +1,55 @@
+package loadTesting
+
+// Run tags label a run, eg with the git SHA it tested and the
+// environment it ran against, so archived results can be told apart
+// without keeping notes elsewhere. They're copied into the Results,
+// and so the results file, listed in the header of the output, and
+// added to the OpenMetrics file as the labels of loadtest_run_info.
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// tagName is a tag that can also be a metric label
+var tagName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// checkRunTags returns an error for a tag that can't be a label
+func checkRunTags(tags map[string]string) error {
+	for name := range tags {
+		if !tagName.MatchString(name) {
+			return fmt.Errorf("run tag %q must be letters, digits and underscores, not starting with a digit", name)
+		}
+	}
+	return nil
+}
+
+// formatTags returns the tags as name=value pairs, in order
+func formatTags(tags map[string]string, quote bool) string {
+	var pairs []string
+	for name, value := range tags {
+		if quote {
+			value = fmt.Sprintf("%q", value)
+		}
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	if quote {
+		return strings.Join(pairs, ",")
+	}
+	return strings.Join(pairs, " ")
+}
+
+// copyTags copies the tags, so the Results don't share the Config's map
+func copyTags(tags map[string]string) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	c := make(map[string]string, len(tags))
+	for name, value := range tags {
+		c[name] = value
+	}
+	return c
+}
@@ -0,0 +1,85 @@
+package loadTesting
+
+// Sampling lets some records be sent more often than others, to make
+// a skewed workload from a uniform trace. A record's weight comes from
+// a column in the input, or from the first path pattern it matches,
+// and is 1 otherwise. A weight of 3 sends it three times, 0.1 sends
+// it one time in ten.
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// pathWeight is a compiled path pattern and its weight
+type pathWeight struct {
+	re     *regexp.Regexp
+	weight float64
+}
+
+// compileWeights compiles the patterns, in sorted order so that
+// which of several patterns matches is also reproducible
+func compileWeights(weights map[string]float64) ([]pathWeight, error) {
+	var compiled []pathWeight
+
+	patterns := make([]string, 0, len(weights))
+	for p := range weights {
+		patterns = append(patterns, p)
+	}
+	sort.Strings(patterns)
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("path weight pattern %q is not a regular expression, %v", p, err)
+		}
+		if weights[p] < 0 {
+			return nil, fmt.Errorf("path weight %q=%f is negative", p, weights[p])
+		}
+		compiled = append(compiled, pathWeight{re: re, weight: weights[p]})
+	}
+	return compiled, nil
+}
+
+// sampling is true if records are to be weighted
+func (lt *Runner) sampling() bool {
+	return lt.conf.WeightField > 0 || len(lt.pathWeights) > 0
+}
+
+// weightOf returns the weight of a record
+func (lt *Runner) weightOf(record []string) float64 {
+	if lt.conf.WeightField > 0 && len(record) > lt.conf.WeightField {
+		w, err := strconv.ParseFloat(record[lt.conf.WeightField], 64)
+		if err == nil && w >= 0 {
+			return w
+		}
+		lt.warnf("weight %q in %q is not a number, using 1\n", record[lt.conf.WeightField], record)
+		return 1
+	}
+	for _, pw := range lt.pathWeights {
+		if pw.re.MatchString(record[pathField]) {
+			return pw.weight
+		}
+	}
+	return 1
+}
+
+// copiesOf turns a weight into a number of copies to send: 2.5 means
+// two copies, plus a third half of the time
+func (lt *Runner) copiesOf(weight float64) int {
+	n := int(weight)
+	if lt.sampler.Float64() < weight-float64(n) {
+		n++
+	}
+	return n
+}
+
+// readOrWrite picks GET or PUT at the configured ratio, so one trace
+// can be replayed with any mix of reads and writes
+func (lt *Runner) readOrWrite() string {
+	if lt.sampler.Float64() < lt.conf.ReadWriteRatio {
+		return "GET"
+	}
+	return "PUT"
+}
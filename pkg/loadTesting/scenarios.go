@@ -0,0 +1,160 @@
+package loadTesting
+
+// Scenarios mix several kinds of user in one run, eg browsing 70% of
+// the time, checking out 20% and searching 10%. Each has its own
+// script, a load file in the usual format, and a share of the
+// requests. Instead of reading the input, each record is taken from
+// a scenario chosen at random by its share, in turn from that
+// scenario's script, which starts again when it's finished. So the
+// shares hold under the one TPS target however long the scripts are,
+// and the run goes on until RunDuration or MaxRequests ends it.
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Scenario is a script of requests, and its share of the load
+type Scenario struct {
+	Name  string  // eg "checkout", for the log
+	File  string  // its script, a load file
+	Share float64 // its part of the requests, relative to the others, eg 20
+}
+
+// scenarioScript is a scenario's script, as it's being played
+type scenarioScript struct {
+	Scenario
+	f    *os.File
+	r    *csv.Reader
+	sent int // records queued from it
+}
+
+// checkScenarios returns an error if the scenarios can't be played
+func checkScenarios(c Config) error {
+	if len(c.Scenarios) == 0 {
+		return nil
+	}
+	switch {
+	case c.RunDuration == 0 && c.MaxRequests == 0:
+		return fmt.Errorf("scenarios repeat until stopped, so need a run duration or a maximum number of requests")
+	case c.Tail || c.SpeedupFactor > 0:
+		return fmt.Errorf("scenarios have no single trace to tail or replay at its own speed")
+	case c.SeedObjects:
+		return fmt.Errorf("objects can't be seeded from scenarios")
+	}
+	for _, s := range c.Scenarios {
+		switch {
+		case s.File == "":
+			return fmt.Errorf("scenario %q has no script", s.Name)
+		case s.Share <= 0:
+			return fmt.Errorf("scenario %q has a share of %g, and would never run", s.Name, s.Share)
+		}
+	}
+	return nil
+}
+
+// openScenarios opens the scripts of the scenarios
+func (lt *Runner) openScenarios() ([]*scenarioScript, error) {
+	var scripts []*scenarioScript
+
+	for _, s := range lt.conf.Scenarios {
+		f, err := os.Open(s.File)
+		if err != nil {
+			closeScenarios(scripts)
+			return nil, fmt.Errorf("%w, can't open the script of scenario %q, %v", ErrConfig, s.Name, err)
+		}
+		if s.Name == "" {
+			s.Name = s.File
+		}
+		scripts = append(scripts, &scenarioScript{Scenario: s, f: f, r: newPerfReader(newDirectiveReader(f))})
+	}
+	return scripts, nil
+}
+
+// closeScenarios closes the scripts
+func closeScenarios(scripts []*scenarioScript) {
+	for _, s := range scripts {
+		s.f.Close() // nolint
+	}
+}
+
+// playScenarios queues runFor records from the scenarios, or as many
+// as the run takes, chosen by their shares
+func (lt *Runner) playScenarios(scripts []*scenarioScript, runFor int, pipe chan []string) {
+	var total float64
+
+	for _, s := range scripts {
+		total += s.Share
+	}
+	for recNo := 0; recNo < runFor; recNo++ {
+		s := pickScenario(scripts, total*lt.sampler.Float64())
+		record, err := s.next()
+		if err != nil {
+			lt.warnf("Fatal error reading the script of scenario %q, stopping: %s\n", s.Name, err)
+			break
+		}
+		if isDirective(record) {
+			if lt.conf.Shuffle && !lt.flushWindow(pipe) {
+				break
+			}
+			if !lt.send(pipe, record) {
+				break
+			}
+			continue
+		}
+		if record, err = lt.parse(record); err != nil {
+			if lt.conf.StrictInput {
+				lt.fail(fmt.Errorf("%w, the script of scenario %q is malformed, %v", ErrConfig, s.Name, err))
+				break
+			}
+			lt.warnf("the script of scenario %q is malformed, %v, ignored\n", s.Name, err)
+			continue
+		}
+		wanted, more := lt.offer(pipe, record)
+		if wanted {
+			s.sent++
+		}
+		if !more {
+			break
+		}
+	}
+	if lt.conf.Shuffle {
+		lt.flushWindow(pipe)
+	}
+	for _, s := range scripts {
+		lt.infof("scenario %s: %d records queued\n", s.Name, s.sent)
+	}
+}
+
+// pickScenario returns the scenario that x, from 0 to the sum of the
+// shares, falls in
+func pickScenario(scripts []*scenarioScript, x float64) *scenarioScript {
+	for _, s := range scripts {
+		if x < s.Share {
+			return s
+		}
+		x -= s.Share
+	}
+	return scripts[len(scripts)-1]
+}
+
+// next returns the next record of a script, starting it again at the end
+func (s *scenarioScript) next() ([]string, error) {
+	for restarted := false; ; restarted = true {
+		record, err := s.r.Read()
+		switch {
+		case err == nil:
+			return record, nil
+		case err != io.EOF:
+			return nil, err
+		case restarted:
+			return nil, fmt.Errorf("%s has no records", s.File)
+		}
+		if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		s.r = newPerfReader(newDirectiveReader(s.f))
+	}
+}
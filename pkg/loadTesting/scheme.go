@@ -0,0 +1,40 @@
+package loadTesting
+
+// Schemes: a REST base URL without one, such as example.com/foo,
+// would fail every request with "unsupported protocol scheme", and
+// say nothing about why. So the base URLs of REST operations, the
+// run's and any a record is routed to, are checked before they're
+// used. Without a scheme, they get DefaultScheme if it's set, and are
+// refused if not. A trailing slash is dropped, as each path is joined
+// to the base with one.
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// withScheme returns a REST base URL with a scheme, or an error saying
+// why it can't have one
+func withScheme(baseURL, defaultScheme string) (string, error) {
+	if baseURL == "" {
+		return "", fmt.Errorf("the rest protocol needs a base URL, eg https://example.com")
+	}
+	if !strings.Contains(baseURL, "://") {
+		if defaultScheme == "" {
+			return "", fmt.Errorf("base URL %q has no scheme, use eg https://%s, or set a default scheme",
+				baseURL, baseURL)
+		}
+		baseURL = defaultScheme + "://" + baseURL
+	}
+	u, err := url.Parse(baseURL)
+	switch {
+	case err != nil:
+		return "", fmt.Errorf("base URL %q is not a URL, %v", baseURL, err)
+	case u.Scheme != "http" && u.Scheme != "https":
+		return "", fmt.Errorf("base URL %q is not http or https", baseURL)
+	case u.Host == "":
+		return "", fmt.Errorf("base URL %q has no host", baseURL)
+	}
+	return strings.TrimSuffix(baseURL, "/"), nil
+}
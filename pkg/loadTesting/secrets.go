@@ -0,0 +1,62 @@
+package loadTesting
+
+// Secrets needn't be written in a run file: any string in it can
+// refer to an environment variable as ${env:NAME}, eg
+//	{"S3Secret": "${env:S3_SECRET}",
+//	 "HeaderMap": {"Authorization": "Bearer ${env:API_TOKEN}"}}
+// and LoadConfig replaces it with the variable's value, so the file
+// can be kept in source control without the credentials. A variable
+// that isn't set is an error, rather than an empty secret.
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// envReference is ${env:NAME}
+var envReference = regexp.MustCompile(`\$\{env:([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// resolveSecrets replaces the references to environment variables in
+// the strings of the fields, however deeply they're nested
+func resolveSecrets(fields map[string]interface{}) error {
+	for key, value := range fields {
+		resolved, err := resolveValue(value)
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+		fields[key] = resolved
+	}
+	return nil
+}
+
+// resolveValue resolves the references in a string, map or list
+func resolveValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		var missing string
+		s := envReference.ReplaceAllStringFunc(v, func(ref string) string {
+			name := envReference.FindStringSubmatch(ref)[1]
+			value, present := os.LookupEnv(name)
+			if !present && missing == "" {
+				missing = name
+			}
+			return value
+		})
+		if missing != "" {
+			return nil, fmt.Errorf("environment variable %s is not set", missing)
+		}
+		return s, nil
+	case map[string]interface{}:
+		return v, resolveSecrets(v)
+	case []interface{}:
+		for i := range v {
+			resolved, err := resolveValue(v[i])
+			if err != nil {
+				return nil, err
+			}
+			v[i] = resolved
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,128 @@
+package loadTesting
+
+// Seeding makes a read-only trace runnable against an empty bucket or
+// server. With SeedObjects, before the run, each path the input reads
+// is written once, with junk data of the size it was read with, so
+// the GETs find real data instead of 404s. Seeding isn't timed or
+// reported, and the objects are remembered like the PUTs of a run, so
+// CleanupAfter deletes them again. The whole input is read, whatever
+// part of it the run will use, so it can't be a pipe.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+const seedWorkers = 16 // writes to have in flight at once
+
+// seeder is an operation that can write an object without timing it
+type seeder interface {
+	Seed(ctx context.Context, path string, size int64) error
+}
+
+// seed is an object to write before the run
+type seed struct {
+	sd   seeder
+	op   operation
+	path string
+	size int64
+}
+
+// readSeeds finds the unique paths the input reads, and their largest
+// size, then rewinds the input for the run
+func (lt *Runner) readSeeds(f *os.File, filename string) ([]seed, int64, error) {
+	var seeds []seed
+	var largest int64
+
+	if lt.streaming {
+		return nil, 0, fmt.Errorf("%w, %s is a pipe, so objects can't be seeded from it", ErrConfig, filename)
+	}
+	start, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w, can't seed objects from %s, %v", ErrConfig, filename, err)
+	}
+	seen := make(map[string]bool)
+	r := newPerfReader(f)
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w, can't seed objects from %s, %v", ErrConfig, filename, err)
+		}
+		if isDirective(record) {
+			continue
+		}
+		if record, err = lt.parse(record); err != nil {
+			continue // reported by the run
+		}
+		path := lt.rewritePath(record[pathField])
+		if seen[path] || lt.excluded(path) || lt.unwanted(record[operatorField]) {
+			continue
+		}
+		if record[operatorField] != "GET" && lt.conf.ForceMethod != "GET" && lt.conf.ReadWriteRatio == 0 {
+			continue
+		}
+		size, err := strconv.ParseInt(record[bytesField], 10, 64)
+		if err != nil || size < 0 {
+			continue // no size to write
+		}
+		op := lt.route(lt.op, record)
+		sd, ok := op.(seeder)
+		if !ok {
+			return nil, 0, fmt.Errorf("%w, this protocol can't seed objects", ErrConfig)
+		}
+		seen[path] = true
+		seeds = append(seeds, seed{sd: sd, op: op, path: path, size: size})
+		if size > largest {
+			largest = size
+		}
+	}
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		return nil, 0, fmt.Errorf("%w, can't rewind %s after seeding, %v", ErrConfig, filename, err)
+	}
+	return seeds, largest, nil
+}
+
+// seedObjects writes the objects before the run, from the junk data
+// file. If ctx is cancelled, it stops, and returns ctx's error.
+func (lt *Runner) seedObjects(ctx context.Context, seeds []seed) error {
+	var written, failed int64
+
+	work := make(chan seed)
+	var wg sync.WaitGroup
+	for i := 0; i < seedWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for s := range work {
+				if lt.conf.CleanupAfter {
+					// even if it failed, it may have been partly written
+					lt.created.add(s.op, s.path)
+				}
+				if err := s.sd.Seed(ctx, s.path, s.size); err != nil {
+					lt.warnf("could not seed %s, %v\n", s.path, err)
+					atomic.AddInt64(&failed, 1)
+					continue
+				}
+				atomic.AddInt64(&written, 1)
+			}
+		}()
+	}
+	for _, s := range seeds {
+		if ctx.Err() != nil {
+			break
+		}
+		work <- s
+	}
+	close(work)
+	wg.Wait()
+	lt.infof("Seeded %d objects, %d could not be written\n", written, failed)
+	return ctx.Err()
+}
@@ -0,0 +1,45 @@
+package loadTesting
+
+// Shuffling sends the records in a random order, so a sorted trace
+// doesn't hit the same objects in the same order every time, warming
+// caches as real clients wouldn't. The reader holds a window of
+// ShuffleWindow records and, as each new one arrives, sends a random
+// one of them in its place, so memory is bounded however large the
+// input. The order comes from the RandomSeed, so a run can be
+// repeated. Directives still apply after the records read before them.
+
+const defaultShuffleWindow = 1000 // records to shuffle at once
+
+// queue sends a record to the workers, or with Shuffle, one chosen at
+// random from the window. It's false if the run was stopped instead.
+func (lt *Runner) queue(pipe chan []string, record []string) bool {
+	if !lt.conf.Shuffle {
+		return lt.send(pipe, record)
+	}
+	size := lt.conf.ShuffleWindow
+	if size == 0 {
+		size = defaultShuffleWindow
+	}
+	if len(lt.window) < size {
+		lt.window = append(lt.window, record)
+		return true
+	}
+	i := lt.sampler.Intn(size)
+	record, lt.window[i] = lt.window[i], record
+	return lt.send(pipe, record)
+}
+
+// flushWindow sends the rest of the window, in a random order
+func (lt *Runner) flushWindow(pipe chan []string) bool {
+	window := lt.window
+	lt.window = nil
+	lt.sampler.Shuffle(len(window), func(i, j int) {
+		window[i], window[j] = window[j], window[i]
+	})
+	for _, record := range window {
+		if !lt.send(pipe, record) {
+			return false
+		}
+	}
+	return true
+}
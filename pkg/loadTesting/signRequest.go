@@ -0,0 +1,48 @@
+package loadTesting
+
+// SignRequest lets a program that uses this package sign REST requests
+// for APIs with their own schemes, such as an HMAC of the canonical
+// request and a timestamp header, which basic or bearer auth in the
+// headers can't do. It's called just before each request is sent, and
+// again before each retry, so timestamps are fresh, after the headers,
+// query and Host are set. It runs on the hot path, from every worker
+// at once, so it must be quick and thread-safe. If it reads the body,
+// it must replace it, eg from req.GetBody. A request it fails to sign
+// isn't sent, and is reported as failing, with a code of -1.
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// signingError is a request SignRequest couldn't sign
+type signingError struct {
+	err error
+}
+
+func (e *signingError) Error() string {
+	return fmt.Sprintf("could not sign the request, %v", e.err)
+}
+
+func (e *signingError) Unwrap() error {
+	return e.err
+}
+
+// sign signs a request with SignRequest, if there is one
+func (p *RestProto) sign(req *http.Request) error {
+	if p.conf.SignRequest == nil {
+		return nil
+	}
+	if err := p.conf.SignRequest(req); err != nil {
+		p.warnf("%s %s: could not sign the request, %v\n", req.Method, req.URL, err)
+		return &signingError{err: err}
+	}
+	return nil
+}
+
+// isSigningError is true if a request wasn't sent as it couldn't be signed
+func isSigningError(err error) bool {
+	var signErr *signingError
+	return errors.As(err, &signErr)
+}
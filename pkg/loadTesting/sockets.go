@@ -0,0 +1,87 @@
+package loadTesting
+
+// Socket tuning, for high connection rates. Each connection we close
+// leaves its local port in TIME_WAIT for a minute or so, so a
+// generator opening thousands a second, with keep-alives off or short
+// connection lifetimes, runs out of ephemeral ports, and every dial
+// fails with "cannot assign requested address". LingerZero closes
+// connections with a reset instead, so they skip TIME_WAIT, and
+// KeepAlivePeriod sets how often idle ones are probed. Running out of
+// ports is reported once, plainly, and counted, rather than left to
+// look like the target refusing connections. The sysctls that raise
+// the limits are in the manual.
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultKeepAlivePeriod = 30 * time.Second
+	timeWaitDuration       = 60 * time.Second // Linux's, which can't be tuned
+	defaultLocalPorts      = 60999 - 32768 + 1
+	localPortRangeFile     = "/proc/sys/net/ipv4/ip_local_port_range"
+)
+
+// newDialer returns a dialer with the connect timeout and keep-alives
+func (lt *Runner) newDialer() *net.Dialer {
+	keepAlive := lt.conf.KeepAlivePeriod
+	if keepAlive == 0 {
+		keepAlive = defaultKeepAlivePeriod
+	}
+	return &net.Dialer{
+		Timeout:   lt.conf.ConnectTimeout,
+		KeepAlive: keepAlive, // negative for none
+	}
+}
+
+// tuneConn sets the options of a newly dialed connection
+func (lt *Runner) tuneConn(conn net.Conn) {
+	if tc, ok := conn.(*net.TCPConn); ok && lt.conf.LingerZero {
+		tc.SetLinger(0) // nolint
+	}
+}
+
+// checkPorts reports a dial that failed as we're out of local ports,
+// the first time it happens, and counts them all
+func (lt *Runner) checkPorts(err error) {
+	if !errors.Is(err, syscall.EADDRNOTAVAIL) {
+		return
+	}
+	if lt.results.addNoPorts() == 1 {
+		lt.warnf("the load generator has run out of local ports, so can't connect: "+
+			"see -linger-zero and the sysctls in the manual, %v\n", err)
+	}
+}
+
+// adviseSockets warns if the TPS would open connections faster than
+// closed ones' ports come out of TIME_WAIT
+func (lt *Runner) adviseSockets(tps int) {
+	if !lt.conf.DisableKeepAlives || lt.conf.LingerZero || lt.conf.Protocol != RESTProtocol {
+		return
+	}
+	ports := localPorts()
+	if limit := float64(ports) / timeWaitDuration.Seconds(); float64(tps) > limit {
+		lt.warnf("without keep-alives, %d TPS leaves more ports in TIME_WAIT than the %d "+
+			"there are, so at more than %.0f TPS, dials will fail: see -linger-zero\n", tps, ports, limit)
+	}
+}
+
+// localPorts returns the number of ephemeral ports, from Linux's range,
+// or its default elsewhere
+func localPorts() int {
+	var low, high int
+
+	data, err := ioutil.ReadFile(localPortRangeFile)
+	if err != nil {
+		return defaultLocalPorts
+	}
+	if n, _ := fmt.Sscan(string(data), &low, &high); n != 2 || high < low {
+		return defaultLocalPorts
+	}
+	return high - low + 1
+}
@@ -0,0 +1,792 @@
+package loadTesting
+
+// Stats accumulates the results of requests as they complete, for
+// progress reports and for the summary at the end of a run.
+// Latencies go into a histogram of 1%-wide buckets, so percentiles
+// are accurate to 1% and memory doesn't grow with the length of a run.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	histBase    = 1.01 // each bucket is 1% wider than the last
+	histBuckets = 2100 // enough for 1000 seconds, in microseconds
+)
+
+// histogram counts latencies in logarithmic buckets
+type histogram struct {
+	counts [histBuckets]int64
+	n      int64
+}
+
+// add a latency to the histogram
+func (h *histogram) add(d time.Duration) {
+	h.counts[bucketOf(d)]++
+	h.n++
+}
+
+// percentile returns the upper limit of the bucket containing the p'th percentile
+func (h *histogram) percentile(p float64) time.Duration {
+	if h.n == 0 {
+		return 0
+	}
+	want := int64(math.Ceil(float64(h.n) * p / 100))
+	var seen int64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= want && c > 0 {
+			return bucketLimit(i)
+		}
+	}
+	return bucketLimit(histBuckets - 1)
+}
+
+// summary returns the count and percentiles of a phase
+func (h *histogram) summary() PhaseLatency {
+	return PhaseLatency{
+		Count: h.n,
+		P50:   h.percentile(50),
+		P90:   h.percentile(90),
+		P99:   h.percentile(99),
+	}
+}
+
+// minus returns the requests in h that weren't in an earlier copy of it
+func (h histogram) minus(earlier histogram) histogram {
+	for i := range h.counts {
+		h.counts[i] -= earlier.counts[i]
+	}
+	h.n -= earlier.n
+	return h
+}
+
+// writePercentiles writes the distribution in HdrHistogram's
+// percentile format, in milliseconds, one line per non-empty bucket.
+// Means are computed from the bucket limits, so are accurate to 1%.
+func (h *histogram) writePercentiles(w io.Writer) error {
+	var seen int64
+	var sum, sumSquares, max float64
+
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "%12s %14s %10s %14s\n\n", "Value", "Percentile", "TotalCount", "1/(1-Percentile)") // nolint
+	for i, c := range h.counts {
+		if c == 0 {
+			continue
+		}
+		seen += c
+		ms := bucketLimit(i).Seconds() * 1000
+		sum += ms * float64(c)
+		sumSquares += ms * ms * float64(c)
+		max = ms
+		p := float64(seen) / float64(h.n)
+		if seen == h.n {
+			fmt.Fprintf(b, "%12.3f %2.12f %10d\n", ms, p, seen) // nolint
+			continue
+		}
+		fmt.Fprintf(b, "%12.3f %2.12f %10d %14.2f\n", ms, p, seen, 1/(1-p)) // nolint
+	}
+	var mean, stdDev float64
+	if h.n > 0 {
+		mean = sum / float64(h.n)
+		stdDev = math.Sqrt(math.Max(sumSquares/float64(h.n)-mean*mean, 0))
+	}
+	fmt.Fprintf(b, "#[Mean    = %12.3f, StdDeviation   = %12.3f]\n", mean, stdDev) // nolint
+	fmt.Fprintf(b, "#[Max     = %12.3f, Total count    = %12d]\n", max, h.n)       // nolint
+	fmt.Fprintf(b, "#[Buckets = %12d, SubBuckets     = %12d]\n", histBuckets, 1)   // nolint
+	return b.Flush()
+}
+
+// bucketOf returns the bucket a latency falls into
+func bucketOf(d time.Duration) int {
+	us := float64(d) / float64(time.Microsecond)
+	if us < 1 {
+		return 0
+	}
+	i := int(math.Log(us)/math.Log(histBase)) + 1
+	if i >= histBuckets {
+		return histBuckets - 1
+	}
+	return i
+}
+
+// bucketLimit returns the upper limit of a bucket
+func bucketLimit(i int) time.Duration {
+	return time.Duration(math.Pow(histBase, float64(i)) * float64(time.Microsecond))
+}
+
+// Results is a snapshot of the requests made so far
+type Results struct {
+	Start    time.Time
+	Duration time.Duration
+	Requests int64
+	Errors   int64
+	Codes    map[int]int64 // count of each return code
+	P50      time.Duration
+	P90      time.Duration
+	P99      time.Duration
+	Methods  map[string]int64 // count of each method, eg GET, PUT or PATCH
+
+	// REST requests, broken down by phase
+	DNS      PhaseLatency
+	Connect  PhaseLatency
+	TLS      PhaseLatency
+	Server   PhaseLatency
+	Transfer PhaseLatency
+
+	// and by outcome, as a flood of fast errors can hide slow successes
+	Succeeded PhaseLatency
+	Failed    PhaseLatency
+
+	CodeLatency map[int]PhaseLatency // and by return code
+
+	NewConns    int64 // REST requests that opened a connection, and
+	ReusedConns int64 // those that reused one, kept alive
+
+	Throttles int64         // responses with a Retry-After we honored
+	Throttled time.Duration // time the workers were paused for them
+
+	Thinks    int64         // times virtual users thought, with ClosedModel, and
+	ThinkTime time.Duration // the time they spent thinking
+
+	Paused time.Duration // time the run was paused, which isn't part of its Duration
+
+	BytesRead    int64 // in the bodies of GETs
+	BytesWritten int64 // in the bodies of PUTs and POSTs that were answered
+
+	// writes sent again, with DuplicateWrites, which got
+	DuplicatesSame    int64 // the same response, as an idempotent server would give
+	DuplicatesRefused int64 // a 409 Conflict, which is also idempotent
+	DuplicatesDiffer  int64 // a different response, so may have written twice
+	DuplicatesFailed  int64 // no response
+
+	Retries int64 // REST requests sent again, with Retries
+
+	MaxOutstanding int64 // the most requests outstanding at once
+	Rejected       int64 // requests shed, with MaxQueueDepth
+
+	NoPorts int64 // dials that failed as we ran out of local ports
+
+	ByteMismatches   int64 // successful GETs of the wrong size, with VerifyBytes
+	CodeMismatches   int64 // requests that didn't get their recorded return code
+	HeaderMismatches int64 // successful GETs without their ExpectHeaders
+	OverEnvelope     int64 // requests cut off, with LatencyTimeoutFactor
+
+	Intervals []IntervalResult // every ProgressInterval, if set
+
+	Tags map[string]string `json:",omitempty"` // the RunTags, eg git_sha: abc123
+
+	Assertions []Assertion `json:",omitempty"` // the checks of the run, and their verdicts
+}
+
+// ErrorRate is the fraction of requests that failed
+func (r Results) ErrorRate() float64 {
+	if r.Requests == 0 {
+		return 0
+	}
+	return float64(r.Errors) / float64(r.Requests)
+}
+
+// ConnReuseRate is the fraction of REST requests that reused a connection
+func (r Results) ConnReuseRate() float64 {
+	if r.NewConns+r.ReusedConns == 0 {
+		return 0
+	}
+	return float64(r.ReusedConns) / float64(r.NewConns+r.ReusedConns)
+}
+
+// TPS is the average rate of completed requests
+func (r Results) TPS() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Requests) / r.Duration.Seconds()
+}
+
+// stats is the running total behind Results
+type stats struct {
+	sync.Mutex
+	start    time.Time
+	end      time.Time // zero until the run finishes
+	requests int64
+	errors   int64
+	codes    map[int]int64
+	methods  map[string]int64
+	latency  histogram
+	paths    map[string]*pathStats // by normalized path, nil if not wanted
+	phases   [numPhases]histogram
+
+	succeeded histogram // latencies of requests that succeeded, and
+	failed    histogram // of those that didn't
+
+	byCode map[int]*histogram // latencies by return code
+
+	newConns    int64 // connections opened, and
+	reusedConns int64 // reused
+
+	throttles int64         // Retry-Afters honored, and
+	throttled time.Duration // the time they paused us
+
+	thinks    int64         // times virtual users thought, and
+	thinkTime time.Duration // for how long
+
+	paused time.Duration // the run was paused, by Pause, not counting in its duration
+
+	bytesRead    int64 // bodies read, and
+	bytesWritten int64 // written
+
+	duplicates [duplicateFailed + 1]int64 // duplicate writes, by outcome
+
+	retries int64 // requests sent again
+	noPorts int64 // dials without a local port
+
+	maxOutstanding int64 // the deepest the queue got, and
+	rejected       int64 // requests shed as it was too deep
+
+	mismatches   int64 // GETs of the wrong size, with VerifyBytes
+	wrongCodes   int64 // requests without their recorded return code
+	wrongHeaders int64 // GETs without their expected headers
+	overEnvelope int64 // requests cut off at their envelope
+
+	intervals []IntervalResult // each ProgressInterval, oldest first
+
+	tags map[string]string // the run's RunTags
+}
+
+// pathStats are the totals for one path
+type pathStats struct {
+	requests int64
+	errors   int64
+	latency  histogram
+}
+
+const (
+	maxPaths = 1000 // paths to keep stats for, the rest are "other"
+	topPaths = 10   // slowest paths to report
+)
+
+// newStats creates an empty set of stats, starting now, for a run with tags
+func newStats(tags map[string]string) *stats {
+	return &stats{start: time.Now(), codes: make(map[int]int64), methods: make(map[string]int64),
+		byCode: make(map[int]*histogram), tags: copyTags(tags)}
+}
+
+// add the result of a single request
+func (s *stats) add(method, path string, latency time.Duration, rc int, failed bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.requests++
+	if failed {
+		s.errors++
+	}
+	s.codes[rc]++
+	s.methods[method]++
+	s.latency.add(latency)
+	if failed {
+		s.failed.add(latency)
+	} else {
+		s.succeeded.add(latency)
+	}
+	if s.byCode[rc] == nil {
+		s.byCode[rc] = &histogram{}
+	}
+	s.byCode[rc].add(latency)
+	if s.paths != nil {
+		s.addPath(normalizePath(path), latency, failed)
+	}
+}
+
+// finish ends the run, so its duration stops growing
+func (s *stats) finish() {
+	s.Lock()
+	defer s.Unlock()
+	s.end = time.Now()
+}
+
+// elapsed is the time since the start, or to the end once finished
+func (s *stats) elapsed() time.Duration {
+	if s.end.IsZero() {
+		return time.Since(s.start)
+	}
+	return s.end.Sub(s.start)
+}
+
+// addPhases adds the phases of a request, those it had
+func (s *stats) addPhases(phases [numPhases]time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	for i, d := range phases {
+		if d > 0 {
+			s.phases[i].add(d)
+		}
+	}
+}
+
+// addConn counts a request's connection, if it had one, as new or reused
+func (s *stats) addConn(got, reused bool) {
+	s.Lock()
+	defer s.Unlock()
+	switch {
+	case !got:
+		return
+	case reused:
+		s.reusedConns++
+	default:
+		s.newConns++
+	}
+}
+
+// addThrottle counts a Retry-After, and the time it paused us
+func (s *stats) addThrottle(d time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	s.throttles++
+	s.throttled += d
+}
+
+// addThink counts a virtual user's think time
+func (s *stats) addThink(d time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	s.thinks++
+	s.thinkTime += d
+}
+
+// addDuplicate counts a duplicate write, by outcome
+func (s *stats) addDuplicate(outcome int) {
+	s.Lock()
+	defer s.Unlock()
+	s.duplicates[outcome]++
+}
+
+// addPause adds a pause, which isn't part of the duration
+func (s *stats) addPause(d time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	s.paused += d
+}
+
+// pausedFor returns the time paused so far, not counting any pause
+// still going on
+func (s *stats) pausedFor() time.Duration {
+	s.Lock()
+	defer s.Unlock()
+	return s.paused
+}
+
+// addOutstanding notes the number of requests outstanding, as one starts
+func (s *stats) addOutstanding(n int64) {
+	s.Lock()
+	defer s.Unlock()
+	if n > s.maxOutstanding {
+		s.maxOutstanding = n
+	}
+}
+
+// addRejected counts a request shed, as too many were outstanding
+func (s *stats) addRejected() {
+	s.Lock()
+	defer s.Unlock()
+	s.rejected++
+}
+
+// addWrongCode counts a request that didn't get its recorded return code
+func (s *stats) addWrongCode() {
+	s.Lock()
+	defer s.Unlock()
+	s.wrongCodes++
+}
+
+// addNoPorts counts a dial that failed for lack of a local port, and
+// returns the number so far
+func (s *stats) addNoPorts() int64 {
+	s.Lock()
+	defer s.Unlock()
+	s.noPorts++
+	return s.noPorts
+}
+
+// addRetry counts a request sent again
+func (s *stats) addRetry() {
+	s.Lock()
+	defer s.Unlock()
+	s.retries++
+}
+
+// addMismatch counts a GET of the wrong size
+func (s *stats) addMismatch() {
+	s.Lock()
+	defer s.Unlock()
+	s.mismatches++
+}
+
+// addHeaderMismatch counts a GET without its expected headers
+func (s *stats) addHeaderMismatch() {
+	s.Lock()
+	defer s.Unlock()
+	s.wrongHeaders++
+}
+
+// addOverEnvelope counts a request cut off at its envelope
+func (s *stats) addOverEnvelope() {
+	s.Lock()
+	defer s.Unlock()
+	s.overEnvelope++
+}
+
+// addInterval keeps the results of an interval
+func (s *stats) addInterval(r IntervalResult) {
+	s.Lock()
+	defer s.Unlock()
+	s.intervals = append(s.intervals, r)
+}
+
+// addPath adds a request to the stats for its path
+func (s *stats) addPath(path string, latency time.Duration, failed bool) {
+	ps, present := s.paths[path]
+	if !present {
+		if len(s.paths) >= maxPaths {
+			path = "other"
+		}
+		if ps, present = s.paths[path]; !present {
+			ps = &pathStats{}
+			s.paths[path] = ps
+		}
+	}
+	ps.requests++
+	if failed {
+		ps.errors++
+	}
+	ps.latency.add(latency)
+}
+
+// normalizePath collapses the parts of a path that are ids, such as
+// numbers, uuids and hashes, to {id}, so that requests for different
+// objects of the same kind are counted together
+func normalizePath(path string) string {
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if idPattern.MatchString(part) {
+			parts[i] = "{id}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// idPattern matches numbers, uuids and long hex strings
+var idPattern = regexp.MustCompile(`^([0-9]+|[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}|[0-9a-fA-F]{16,})$`)
+
+// slowestPaths describes the paths with the worst p99s
+func (s *stats) slowestPaths() []string {
+	type pathP99 struct {
+		path string
+		p99  time.Duration
+		ps   *pathStats
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	slowest := make([]pathP99, 0, len(s.paths))
+	for path, ps := range s.paths {
+		slowest = append(slowest, pathP99{path, ps.latency.percentile(99), ps})
+	}
+	sort.Slice(slowest, func(i, j int) bool {
+		if slowest[i].p99 == slowest[j].p99 {
+			return slowest[i].path < slowest[j].path
+		}
+		return slowest[i].p99 > slowest[j].p99
+	})
+	if len(slowest) > topPaths {
+		slowest = slowest[:topPaths]
+	}
+	lines := make([]string, len(slowest))
+	for i, p := range slowest {
+		lines[i] = fmt.Sprintf("p99 %.6f s, p50 %.6f s, %d requests, %d errors: %s",
+			p.p99.Seconds(), p.ps.latency.percentile(50).Seconds(),
+			p.ps.requests, p.ps.errors, p.path)
+	}
+	return lines
+}
+
+// snapshot returns the Results so far
+func (s *stats) snapshot() Results {
+	s.Lock()
+	defer s.Unlock()
+	codes := make(map[int]int64, len(s.codes))
+	for k, v := range s.codes {
+		codes[k] = v
+	}
+	codeLatency := make(map[int]PhaseLatency, len(s.byCode))
+	for k, h := range s.byCode {
+		codeLatency[k] = h.summary()
+	}
+	methods := make(map[string]int64, len(s.methods))
+	for k, v := range s.methods {
+		methods[k] = v
+	}
+	return Results{
+		Start:    s.start,
+		Duration: s.elapsed() - s.paused,
+		Requests: s.requests,
+		Errors:   s.errors,
+		Codes:    codes,
+		P50:      s.latency.percentile(50),
+		P90:      s.latency.percentile(90),
+		P99:      s.latency.percentile(99),
+		Methods:  methods,
+		DNS:      s.phases[dnsPhase].summary(),
+		Connect:  s.phases[connectPhase].summary(),
+		TLS:      s.phases[tlsPhase].summary(),
+		Server:   s.phases[serverPhase].summary(),
+		Transfer: s.phases[transferPhase].summary(),
+
+		Succeeded: s.succeeded.summary(),
+		Failed:    s.failed.summary(),
+
+		CodeLatency: codeLatency,
+
+		NewConns:    s.newConns,
+		ReusedConns: s.reusedConns,
+
+		Throttles: s.throttles,
+		Throttled: s.throttled,
+
+		Thinks:    s.thinks,
+		ThinkTime: s.thinkTime,
+
+		Paused: s.paused,
+
+		BytesRead:    s.bytesRead,
+		BytesWritten: s.bytesWritten,
+
+		DuplicatesSame:    s.duplicates[duplicateSame],
+		DuplicatesRefused: s.duplicates[duplicateRefused],
+		DuplicatesDiffer:  s.duplicates[duplicateDiffers],
+		DuplicatesFailed:  s.duplicates[duplicateFailed],
+
+		Retries: s.retries,
+		NoPorts: s.noPorts,
+
+		MaxOutstanding: s.maxOutstanding,
+		Rejected:       s.rejected,
+
+		ByteMismatches:   s.mismatches,
+		CodeMismatches:   s.wrongCodes,
+		HeaderMismatches: s.wrongHeaders,
+		OverEnvelope:     s.overEnvelope,
+
+		Intervals: append([]IntervalResult(nil), s.intervals...),
+
+		Tags: copyTags(s.tags),
+	}
+}
+
+// sample returns the errors so far, and a copy of the latency
+// histogram, which counts the requests
+func (s *stats) sample() (int64, histogram) {
+	s.Lock()
+	defer s.Unlock()
+	return s.errors, s.latency
+}
+
+// latencies returns a copy of the latency histogram
+func (s *stats) latencies() histogram {
+	s.Lock()
+	defer s.Unlock()
+	return s.latency
+}
+
+// failed is true for requests that got no response, or a 4XX or 5XX.
+// If there's a list of success codes, it's true for anything not in
+// it, but getting the code the input file expected is always a success.
+func (lt *Runner) failed(rc int, oldRc string) bool {
+	if len(lt.conf.SuccessCodes) == 0 {
+		return rc < 100 || rc >= 400
+	}
+	if expected, err := strconv.Atoi(oldRc); err == nil && expected != 0 && rc == expected {
+		return false
+	}
+	for _, code := range lt.conf.SuccessCodes {
+		if rc == code {
+			return false
+		}
+	}
+	return true
+}
+
+// reportProgress writes a snapshot every interval, until done is closed.
+// The TPS is for the last interval, the rest are for the whole run.
+func (lt *Runner) reportProgress(interval time.Duration, w io.Writer, done chan bool) {
+	var last int64
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			r := lt.results.snapshot()
+			s := fmt.Sprintf("progress: %.1f TPS, %d requests, %.2f%% errors, p99 %.6f s, "+
+				"%d outstanding, at most %d\n",
+				float64(r.Requests-last)/interval.Seconds(), r.Requests,
+				100*r.ErrorRate(), r.P99.Seconds(), lt.outstanding(), r.MaxOutstanding)
+			last = r.Requests
+			if w == nil {
+				lt.infof("%v", s)
+				continue
+			}
+			fmt.Fprint(w, s) // nolint
+		}
+	}
+}
+
+// reportSummary logs the results of the whole run
+func (lt *Runner) reportSummary() {
+	r := lt.finalResults()
+	lt.infof("%d requests in %.3f s, %.1f TPS, %.2f%% errors\n",
+		r.Requests, r.Duration.Seconds(), r.TPS(), 100*r.ErrorRate())
+	lt.infof("latency p50 %.6f s, p90 %.6f s, p99 %.6f s\n",
+		r.P50.Seconds(), r.P90.Seconds(), r.P99.Seconds())
+	if r.Errors > 0 {
+		for _, outcome := range []struct {
+			name    string
+			latency PhaseLatency
+		}{{"succeeded", r.Succeeded}, {"failed", r.Failed}} {
+			lt.infof("%s p50 %.6f s, p90 %.6f s, p99 %.6f s, of %d requests\n", outcome.name,
+				outcome.latency.P50.Seconds(), outcome.latency.P90.Seconds(),
+				outcome.latency.P99.Seconds(), outcome.latency.Count)
+		}
+	}
+	if lt.conf.IfNoneMatch != "" || lt.conf.IfModSince != "" {
+		// conditional GETs, so distinguish revalidations from full responses
+		lt.infof("%d not modified (304), %d full responses (200)\n",
+			r.Codes[http.StatusNotModified], r.Codes[http.StatusOK])
+	}
+	for i, phase := range []PhaseLatency{r.DNS, r.Connect, r.TLS, r.Server, r.Transfer} {
+		if phase.Count > 0 {
+			lt.infof("%s p50 %.6f s, p90 %.6f s, p99 %.6f s, of %d requests\n", phaseNames[i],
+				phase.P50.Seconds(), phase.P90.Seconds(), phase.P99.Seconds(), phase.Count)
+		}
+	}
+	if n := r.NewConns + r.ReusedConns; n > 0 {
+		lt.infof("%.1f%% of %d requests reused a connection, %d opened a new one\n",
+			100*r.ConnReuseRate(), n, r.NewConns)
+	}
+	if r.BytesRead+r.BytesWritten > 0 {
+		lt.reportBytes(r)
+	}
+	if lt.conf.WorkerPool > 0 {
+		lt.reportPool(r)
+	}
+	if lt.conf.ClosedModel {
+		lt.reportClosedModel(r)
+	}
+	if lt.conf.DuplicateWrites {
+		lt.reportDuplicates(r)
+	}
+	if r.Paused > 0 {
+		lt.infof("paused for %.3f s, which isn't part of the time or TPS\n", r.Paused.Seconds())
+	}
+	if r.Throttles > 0 {
+		lt.infof("throttled by Retry-After %d times, paused for %.3f s\n",
+			r.Throttles, r.Throttled.Seconds())
+	}
+	if r.Retries > 0 {
+		lt.infof("%d requests were retried, after a failure to connect or a 502, 503 or 504\n", r.Retries)
+	}
+	if r.ByteMismatches > 0 {
+		lt.warnf("%d successful GETs returned the wrong number of bytes\n", r.ByteMismatches)
+	}
+	if r.HeaderMismatches > 0 {
+		lt.warnf("%d successful GETs didn't have the headers expected\n", r.HeaderMismatches)
+	}
+	if r.CodeMismatches > 0 {
+		lt.warnf("%d requests didn't get the return code recorded for them\n", r.CodeMismatches)
+	}
+	if r.OverEnvelope > 0 {
+		lt.warnf("%d requests took more than %gx their recorded latency, and were cut off\n",
+			r.OverEnvelope, lt.conf.LatencyTimeoutFactor)
+	}
+	for _, t := range trends(r.Intervals) {
+		lt.warnf("%s\n", t)
+	}
+	if r.MaxOutstanding > 0 {
+		lt.infof("at most %d requests were outstanding at once\n", r.MaxOutstanding)
+	}
+	if r.Rejected > 0 {
+		lt.warnf("%d requests were rejected, as %d were already outstanding\n", r.Rejected, lt.conf.MaxQueueDepth)
+	}
+	if n := atomic.LoadInt64(&lt.overLimit); n > 0 {
+		lt.warnf("%d requests not sent, as the maximum of %d goroutines were running\n", n, lt.conf.MaxGoroutines)
+	}
+	if n := lt.droppedOutput(); n > 0 {
+		lt.warnf("%d lines of output were dropped, as it couldn't keep up\n", n)
+	}
+	if r.Codes[599] > 0 {
+		lt.warnf("%d requests could not connect (599)\n", r.Codes[599])
+	}
+	if r.NoPorts > 0 {
+		lt.warnf("%d connections failed as the load generator ran out of local ports\n", r.NoPorts)
+	}
+	codes := make([]int, 0, len(r.Codes))
+	for rc := range r.Codes {
+		codes = append(codes, rc)
+	}
+	sort.Ints(codes)
+	for _, rc := range codes {
+		l := r.CodeLatency[rc]
+		lt.infof("return code %d: %d, p50 %.6f s, p90 %.6f s, p99 %.6f s\n",
+			rc, r.Codes[rc], l.P50.Seconds(), l.P90.Seconds(), l.P99.Seconds())
+	}
+	if len(r.Methods) > 1 {
+		methods := make([]string, 0, len(r.Methods))
+		for m := range r.Methods {
+			methods = append(methods, m)
+		}
+		sort.Strings(methods)
+		for _, m := range methods {
+			lt.infof("method %s: %d\n", m, r.Methods[m])
+		}
+	}
+	if lt.conf.PerPathStats {
+		lt.infof("slowest paths:\n")
+		for _, line := range lt.results.slowestPaths() {
+			lt.infof("%s\n", line)
+		}
+	}
+	lt.reportAssertions(r.Assertions)
+}
+
+// writeHistogram writes the latency distribution of the whole run to
+// a file, for hdr-plot or any other tool that reads HdrHistogram output
+func (lt *Runner) writeHistogram(name string) {
+	h := lt.results.latencies()
+	f, err := os.Create(name)
+	if err != nil {
+		lt.warnf("could not create histogram file %q, %v\n", name, err)
+		return
+	}
+	err = h.writePercentiles(f)
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		lt.warnf("error writing histogram file %q, %v\n", name, err)
+	}
+}
@@ -0,0 +1,67 @@
+package loadTesting
+
+// A steady load normally starts all its workers at once, so the target
+// gets the full rate in the first second, which can trip its cold-start
+// protections and show errors no real client would cause. With
+// SteadyRampDuration, the rate instead climbs linearly to the target
+// over that time, every rampTick, and then holds. The workers, the
+// pool's pacer and the scheduler all follow the rate as it climbs. A
+// directive that sets the rate during the ramp ends it.
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const rampTick = 100 * time.Millisecond
+
+// rampRate is the rate elapsed into a ramp to tpsTarget, at least 1
+func rampRate(tpsTarget int, elapsed, ramp time.Duration) int {
+	if elapsed >= ramp {
+		return tpsTarget
+	}
+	rate := int(int64(tpsTarget) * int64(elapsed) / int64(ramp))
+	if rate < 1 {
+		rate = 1
+	}
+	return rate
+}
+
+// rampToSteady raises the offered rate to tpsTarget over
+// SteadyRampDuration, starting workers as it goes unless they're paced
+func (lt *Runner) rampToSteady(tpsTarget int, pipe chan []string, workers *sync.WaitGroup) {
+	ramp := lt.conf.SteadyRampDuration
+	start := time.Now()
+	started := 0
+	lt.infof("ramping up to %d requests/second over %s\n", tpsTarget, ramp)
+	ticker := time.NewTicker(rampTick)
+	defer ticker.Stop()
+	for {
+		rate := rampRate(tpsTarget, time.Since(start), ramp)
+		if started > 0 && !atomic.CompareAndSwapInt64(&lt.offeredRate, int64(started), int64(rate)) {
+			lt.infof("the rate was set during the ramp, at %d requests/second, ending it\n", started)
+			return
+		}
+		atomic.StoreInt64(&lt.offeredRate, int64(rate))
+		for ; started < rate; started++ {
+			if lt.paced() {
+				continue
+			}
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+				lt.worker(pipe)
+			}()
+		}
+		if rate == tpsTarget {
+			lt.infof("now at %d requests/second\n", rate)
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-lt.stopped:
+			return
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package loadTesting
+
+import (
+	"testing"
+	"time"
+)
+
+// TestRampRate sees if a ramp starts at 1, climbs linearly and holds
+func TestRampRate(t *testing.T) {
+	ramp := 10 * time.Second
+	for _, c := range []struct {
+		name      string
+		tpsTarget int
+		elapsed   time.Duration
+		expected  int
+	}{
+		{"at the start", 100, 0, 1},
+		{"half way", 100, 5 * time.Second, 50},
+		{"just before the end", 100, ramp - time.Millisecond, 99},
+		{"at the end", 100, ramp, 100},
+		{"after the end", 100, 2 * ramp, 100},
+		{"below 1 rounds up", 5, time.Second, 1},
+		{"rounds down", 5, 3 * time.Second, 1},
+		{"rounds down above 1", 5, 5 * time.Second, 2},
+	} {
+		if rate := rampRate(c.tpsTarget, c.elapsed, ramp); rate != c.expected {
+			t.Errorf("%s, rampRate(%d, %s, %s) is %d, expected %d",
+				c.name, c.tpsTarget, c.elapsed, ramp, rate, c.expected)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package loadTesting
+
+// Tail follows an input file that's being appended to, like tail -f.
+// It waits for fsnotify to say the file has been written, or if that's
+// not available, polls with an increasing delay, so an idle input
+// doesn't use any CPU. If the file is truncated, it starts again at
+// the beginning. If it's rotated, that is, renamed and recreated, it
+// can reopen the file by name and read the new one.
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"gopkg.in/fsnotify.v1"
+)
+
+const (
+	minTailDelay = 10 * time.Millisecond
+	maxTailDelay = time.Second // also the longest we trust fsnotify for
+)
+
+// tailer follows a file
+type tailer struct {
+	f       *os.File
+	name    string
+	watcher *fsnotify.Watcher // nil if we're polling
+	delay   time.Duration     // the next polling delay
+	follow  bool              // reopen the file if it's rotated
+	opened  bool              // we opened f, so we close it
+	stopped <-chan bool       // closed when the run stops, to stop waiting
+	logger
+}
+
+// createTailer seeks to the end of a file and starts watching it,
+// until stopped is closed
+func createTailer(f *os.File, name string, follow bool, stopped <-chan bool, l logger) (*tailer, error) {
+	_, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("could not seek to the end of %s, %v", name, err)
+	}
+	t := &tailer{f: f, name: name, delay: minTailDelay, follow: follow, stopped: stopped, logger: l}
+	t.watcher, err = fsnotify.NewWatcher()
+	if err == nil {
+		err = t.watcher.Add(name)
+	}
+	if err != nil {
+		t.warnf("can't use fsnotify on %s, polling instead: %s\n", name, err)
+		t.close()
+	}
+	t.infof("seeked to the end of %s, doing a tail -f with normal timeouts\n",
+		name)
+	return t, nil
+}
+
+// Read reads from the file we're currently following
+func (t *tailer) Read(b []byte) (int, error) {
+	return t.f.Read(b)
+}
+
+// wait waits until there may be more to read, or the run is stopped
+func (t *tailer) wait() error {
+	truncated, err := t.truncated()
+	if err != nil {
+		return err
+	}
+	if truncated || t.rotated() {
+		return nil
+	}
+	if t.watcher != nil {
+		return waitForChange(t.watcher, t.stopped)
+	}
+	select {
+	case <-time.After(t.delay):
+	case <-t.stopped:
+		return nil
+	}
+	t.delay *= 2
+	if t.delay > maxTailDelay {
+		t.delay = maxTailDelay
+	}
+	return nil
+}
+
+// read says we've read something, so the next poll is a quick one
+func (t *tailer) read() {
+	t.delay = minTailDelay
+}
+
+// truncated checks if the file is now shorter than what we've read,
+// and if so, starts again from the beginning
+func (t *tailer) truncated() (bool, error) {
+	offset, err := t.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return false, nil
+	}
+	info, err := t.f.Stat()
+	if err != nil || info.Size() >= offset {
+		return false, nil
+	}
+	t.infof("%s was truncated, reading from the beginning\n", t.name)
+	_, err = t.f.Seek(0, io.SeekStart)
+	if err != nil {
+		return false, fmt.Errorf("could not seek to the beginning of %s, %v", t.name, err)
+	}
+	return true, nil
+}
+
+// rotated checks if the file has been replaced by a new one of the
+// same name, and if so, switches to reading the new one
+func (t *tailer) rotated() bool {
+	if !t.follow {
+		return false
+	}
+	old, err := t.f.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(t.name)
+	if err != nil || os.SameFile(old, current) {
+		// not rotated, or the new one isn't there yet
+		return false
+	}
+	f, err := os.Open(t.name)
+	if err != nil {
+		t.warnf("%s was rotated, but can't be reopened yet: %s\n", t.name, err)
+		return false
+	}
+	t.infof("%s was rotated, reading the new one\n", t.name)
+	if t.opened {
+		t.f.Close() // nolint
+	}
+	t.f, t.opened = f, true
+	if t.watcher != nil {
+		t.watcher.Remove(t.name) // nolint, as the old one may be gone
+		if err = t.watcher.Add(t.name); err != nil {
+			t.warnf("can't use fsnotify on the new %s, polling instead: %s\n", t.name, err)
+			t.watcher.Close() // nolint
+			t.watcher = nil
+		}
+	}
+	return true
+}
+
+// close stops watching, and closes any file we opened
+func (t *tailer) close() {
+	if t.watcher != nil {
+		t.watcher.Close() // nolint
+		t.watcher = nil
+	}
+	if t.opened {
+		t.f.Close() // nolint
+		t.opened = false
+	}
+}
+
+// waitForChange waits for the tail of a file to be written to, or
+// for a while, in case we missed it, or until stopped is closed
+// cargo courtesy Satyajit Ranjeev, http://satran.in/2017/11/15/Implementing_tails_follow_in_go.html
+func waitForChange(w *fsnotify.Watcher, stopped <-chan bool) error {
+	timeout := time.After(maxTailDelay)
+	for {
+		select {
+		case event := <-w.Events:
+			if event.Op&fsnotify.Write == fsnotify.Write {
+				return nil
+			}
+		case err := <-w.Errors:
+			return err
+		case <-timeout:
+			return nil
+		case <-stopped:
+			return nil
+		}
+	}
+}
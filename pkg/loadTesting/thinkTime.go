@@ -0,0 +1,79 @@
+package loadTesting
+
+// Think times shape the gaps between each worker's requests, when
+// they aren't taken from the trace, to model different users. They
+// replace the Arrivals, which all average one tick, with a choice of
+// distributions and a mean of ThinkTimeMean. Exponential think times
+// make each worker's requests a Poisson process, as with
+// PoissonArrivals; log-normal ones model users who mostly pause
+// briefly but sometimes wander off. The workers are still started at
+// one per request per second, so a mean other than a second scales
+// the rate: with a mean of 2s, half as many requests are sent.
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// ThinkTimeDistributions: how a worker's time between requests is drawn
+const (
+	NoThinkTime          = ""            // use the Arrivals
+	ConstantThinkTime    = "constant"    // always the mean
+	UniformThinkTime     = "uniform"     // within ThinkTimeSpread of the mean, either side
+	ExponentialThinkTime = "exponential" // memoryless, for open-model arrivals
+	LogNormalThinkTime   = "lognormal"   // skewed, with a sigma of ThinkTimeSpread
+)
+
+const defaultThinkTimeSpread = 0.5
+
+// checkThinkTime returns an error if the think times can't be drawn
+func checkThinkTime(c Config) error {
+	switch c.ThinkTimeDistribution {
+	case NoThinkTime:
+		if c.ThinkTimeMean != 0 || c.ThinkTimeSpread != 0 {
+			return fmt.Errorf("a think time mean or spread needs a think time distribution")
+		}
+		return nil
+	case ConstantThinkTime, UniformThinkTime, ExponentialThinkTime, LogNormalThinkTime:
+	default:
+		return fmt.Errorf("think time distribution %q is not %s, %s, %s or %s",
+			c.ThinkTimeDistribution, ConstantThinkTime, UniformThinkTime,
+			ExponentialThinkTime, LogNormalThinkTime)
+	}
+	switch {
+	case c.Arrivals != FixedArrivals:
+		return fmt.Errorf("think times replace the arrivals, so can't be used with %s arrivals", c.Arrivals)
+	case c.ThinkTimeMean < 0:
+		return fmt.Errorf("a negative think time (%s) is meaningless", c.ThinkTimeMean)
+	case c.ThinkTimeSpread < 0:
+		return fmt.Errorf("a negative think time spread (%g) is meaningless", c.ThinkTimeSpread)
+	case c.ThinkTimeDistribution == UniformThinkTime && c.ThinkTimeSpread > 1:
+		return fmt.Errorf("a uniform think time spread of more than 1 (%g) would be negative", c.ThinkTimeSpread)
+	}
+	return nil
+}
+
+// thinkTime draws the time until a worker's next request. It's
+// called with randomLock held.
+func (lt *Runner) thinkTime() time.Duration {
+	mean := float64(lt.conf.ThinkTimeMean)
+	if mean == 0 {
+		mean = float64(workerTick)
+	}
+	spread := lt.conf.ThinkTimeSpread
+	if spread == 0 {
+		spread = defaultThinkTimeSpread
+	}
+	switch lt.conf.ThinkTimeDistribution {
+	case UniformThinkTime:
+		return time.Duration(mean * (1 - spread + 2*spread*lt.random.Float64()))
+	case ExponentialThinkTime:
+		return time.Duration(mean * lt.random.ExpFloat64())
+	case LogNormalThinkTime:
+		// mu is chosen so the mean is the mean, not the median
+		mu := math.Log(mean) - spread*spread/2
+		return time.Duration(math.Exp(mu + spread*lt.random.NormFloat64()))
+	}
+	return time.Duration(mean)
+}
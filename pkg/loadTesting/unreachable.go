@@ -0,0 +1,42 @@
+package loadTesting
+
+// Unreachable targets: a request that couldn't connect is reported as
+// a 599, so a target that was never up, or that died mid-run, leaves
+// a run of them. If none of the requests connected, or the last
+// unansweredLimit of them in a row didn't, the run returns an
+// ErrUnreachable, so a program can tell a target that was down from
+// one that answered badly. With FailFast, the first one stops the run.
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+const unansweredLimit = 10 // 599s in a row that mean the target is down
+
+// countAnswer notes whether a request connected. Ones that were
+// never sent, with a zero or negative code, don't count either way.
+func (lt *Runner) countAnswer(rc int) {
+	switch {
+	case rc == 599:
+		atomic.AddInt64(&lt.unanswered, 1)
+	case rc > 0:
+		atomic.StoreInt64(&lt.unanswered, 0)
+		atomic.StoreInt64(&lt.answered, 1)
+	}
+}
+
+// checkReachable returns an ErrUnreachable if the target never
+// answered, or stopped answering
+func (lt *Runner) checkReachable() error {
+	n := atomic.LoadInt64(&lt.unanswered)
+	switch {
+	case n == 0:
+		return nil
+	case atomic.LoadInt64(&lt.answered) == 0:
+		return fmt.Errorf("%w, none of the %d requests could connect", ErrUnreachable, n)
+	case n >= unansweredLimit:
+		return fmt.Errorf("%w, the last %d requests could not connect", ErrUnreachable, n)
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package loadTesting
+
+// Validate checks a Config for settings that can't work, so a run
+// stops before generating any load, rather than part way through.
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Validate returns an error describing the first impossible setting, if any
+func (c Config) Validate() error {
+	switch {
+	case c.Protocol < FilesystemProtocol || c.Protocol > WebSocketProtocol:
+		return fmt.Errorf("protocol %d is not a known protocol", c.Protocol)
+	case c.Protocol == CephProtocol:
+		return fmt.Errorf("the native ceph protocol is not implemented yet")
+	case c.Protocol == S3Protocol && (c.S3Key == "" || c.S3Secret == ""):
+		return fmt.Errorf("the s3 protocol needs both a key and a secret")
+	case c.Protocol == AzureBlobProtocol && c.AzureConnStr == "" && c.AzureKey == "":
+		return fmt.Errorf("azure needs either a connection string or an account key")
+	case c.Timeout <= 0:
+		return fmt.Errorf("a timeout of %d seconds would end the run immediately", c.Timeout)
+	case c.StepDuration < 0:
+		return fmt.Errorf("a negative step duration (%d) is meaningless, use zero for the default", c.StepDuration)
+	case c.BufSize < 0:
+		return fmt.Errorf("a negative size for data files (%d) is meaningless", c.BufSize)
+	case c.WorkerPool < 0:
+		return fmt.Errorf("a negative worker pool size (%d) is meaningless", c.WorkerPool)
+	case c.WorkerPool > 0 && c.Deterministic:
+		return fmt.Errorf("a deterministic run has one worker, so can't have a pool")
+	case c.WorkerPool > 0 && c.Protocol == TimeBudgetProtocol:
+		return fmt.Errorf("the time budget protocol needs one worker per request, not a pool")
+	case c.DuplicateWrites && (c.Protocol != RESTProtocol || !c.W):
+		return fmt.Errorf("duplicate writes are only implemented for the rest protocol, and need writes to be allowed")
+	case c.IdempotencyHeader != "" && !c.DuplicateWrites:
+		return fmt.Errorf("an idempotency key header is only for duplicate writes")
+	case c.Lifecycle && c.Protocol != RESTProtocol:
+		return fmt.Errorf("lifecycle tests are only implemented for the rest protocol")
+	case c.Lifecycle && !c.W:
+		return fmt.Errorf("lifecycle tests write objects, so need writes to be allowed")
+	case c.CleanupAfter && !c.W && !c.SeedObjects:
+		return fmt.Errorf("cleaning up deletes the objects written, so needs writes to be allowed, or seeding")
+	case c.PreflightPath != "" && !c.Preflight:
+		return fmt.Errorf("a preflight path needs the preflight check turned on")
+	case c.WorkerJars && !c.UseCookieJar:
+		return fmt.Errorf("per-worker cookie jars need cookies to be kept")
+	case c.ForceMethod != "" && c.ForceMethod != "GET" && c.ForceMethod != "PUT" && c.ForceMethod != "POST":
+		return fmt.Errorf("can only force requests to be GET, PUT or POST, not %q", c.ForceMethod)
+	case c.ReadWriteRatio < 0 || c.ReadWriteRatio > 1:
+		return fmt.Errorf("a read/write ratio must be between 0 and 1, not %g", c.ReadWriteRatio)
+	case c.ReadWriteRatio > 0 && c.ForceMethod != "":
+		return fmt.Errorf("can't both force a method and mix reads and writes")
+	case c.ReadWriteRatio > 0 && c.ReadWriteRatio < 1 && !c.W:
+		return fmt.Errorf("mixing reads and writes needs writes to be allowed")
+	case c.InputFormat != PerfFormat && c.InputFormat != PathListFormat:
+		return fmt.Errorf("the input format must be %q or the default, not %q", PathListFormat, c.InputFormat)
+	case c.Repeat < RepeatForever:
+		return fmt.Errorf("a negative number of repeats (%d) is meaningless, use %d for forever", c.Repeat, RepeatForever)
+	case c.Repeat != 0 && c.Tail:
+		return fmt.Errorf("a tailed input can't be repeated, as it never ends")
+	case c.OutputBuffer < 0:
+		return fmt.Errorf("a negative output buffer (%d) is meaningless", c.OutputBuffer)
+	case c.OutputMaxSize < 0 || c.OutputRotate < 0:
+		return fmt.Errorf("a negative output size or number of files to keep is meaningless")
+	case c.OutputMaxSize > 0 && c.OutputFile == "":
+		return fmt.Errorf("only an output file can be rotated, not stdout")
+	case c.OutputRotate > 0 && c.OutputMaxSize == 0:
+		return fmt.Errorf("output files to keep need a size to rotate them at")
+	case c.ShuffleWindow < 0:
+		return fmt.Errorf("a negative shuffle window (%d records) is meaningless", c.ShuffleWindow)
+	case c.Shuffle && c.SpeedupFactor > 0:
+		return fmt.Errorf("shuffled records can't be sent at their times in the trace")
+	case c.Shuffle && c.Tail:
+		return fmt.Errorf("records from a tailed log can't be shuffled, as the window might never fill")
+	case c.SpeedupFactor < 0:
+		return fmt.Errorf("a negative speedup (%g) is meaningless, use zero to ignore the trace's times", c.SpeedupFactor)
+	case c.RunDuration < 0:
+		return fmt.Errorf("a negative run duration (%s) is meaningless", c.RunDuration)
+	case c.SteadyRampDuration < 0:
+		return fmt.Errorf("a negative ramp (%s) is meaningless", c.SteadyRampDuration)
+	case c.SteadyRampDuration > 0 && c.ClosedModel:
+		return fmt.Errorf("the closed model's virtual users start at once, so can't be ramped")
+	case c.MaxGoroutines < 0:
+		return fmt.Errorf("a negative maximum number of goroutines (%d) is meaningless", c.MaxGoroutines)
+	case c.MaxTotalBytes < 0:
+		return fmt.Errorf("a negative maximum number of bytes (%d) is meaningless", c.MaxTotalBytes)
+	case c.MaxRequests < 0:
+		return fmt.Errorf("a negative maximum number of requests (%d) is meaningless", c.MaxRequests)
+	case c.ConnectTimeout < 0 || c.RequestTimeout < 0 || c.DrainTimeout < 0 || c.MinRequestTimeout < 0:
+		return fmt.Errorf("negative timeouts are meaningless")
+	case c.MaxP99 < 0 || c.MinTPS < 0:
+		return fmt.Errorf("a negative maximum p99 (%s) or minimum TPS (%g) can't be asserted", c.MaxP99, c.MinTPS)
+	case c.MaxErrorRate < 0 || c.MaxErrorRate > 1:
+		return fmt.Errorf("the maximum error rate (%g) must be a fraction, eg 0.01 for 1%%", c.MaxErrorRate)
+	case c.MaxQueueDepth < 0:
+		return fmt.Errorf("a negative maximum queue depth (%d) is meaningless", c.MaxQueueDepth)
+	case c.MaxQueueDepth > 0 && c.WorkerPool > 0:
+		return fmt.Errorf("a worker pool already limits the requests outstanding, to its size")
+	case c.Retries < 0:
+		return fmt.Errorf("a negative number of retries (%d) is meaningless", c.Retries)
+	case c.RetryNonIdempotent && c.Retries == 0:
+		return fmt.Errorf("retrying POSTs and PATCHes needs a number of retries")
+	case c.LatencyTimeoutFactor < 0:
+		return fmt.Errorf("a negative latency timeout factor (%g) is meaningless", c.LatencyTimeoutFactor)
+	case c.MaxBytesPerSec < 0:
+		return fmt.Errorf("a negative bandwidth (%d bytes/second) is meaningless", c.MaxBytesPerSec)
+	case c.MaxReadBytes < 0:
+		return fmt.Errorf("a negative maximum read size (%d bytes) is meaningless", c.MaxReadBytes)
+	case c.VerifyTolerance < 0:
+		return fmt.Errorf("a negative size tolerance (%g) is meaningless", c.VerifyTolerance)
+	case c.MaxConnLifetime < 0 || c.IdleConnTimeout < 0:
+		return fmt.Errorf("negative connection lifetimes are meaningless")
+	case c.Arrivals != FixedArrivals && c.Arrivals != UniformArrivals && c.Arrivals != PoissonArrivals:
+		return fmt.Errorf("arrivals must be %q or %q, not %q", UniformArrivals, PoissonArrivals, c.Arrivals)
+	case c.StartJitter < 0:
+		return fmt.Errorf("a negative start jitter (%s) is meaningless", c.StartJitter)
+	case c.MultipartThreshold < 0 || c.PartSize < 0 || c.PartConcurrency < 0:
+		return fmt.Errorf("negative multipart sizes and counts are meaningless")
+	case c.ProgressInterval < 0:
+		return fmt.Errorf("a negative progress interval (%s) is meaningless", c.ProgressInterval)
+	case c.IntervalStatsFile != "" && c.ProgressInterval == 0:
+		return fmt.Errorf("interval stats are written every progress interval, so need one")
+	case c.MaxCaptures < 0:
+		return fmt.Errorf("a negative number of captures (%d) is meaningless", c.MaxCaptures)
+	case c.PipeBuffer < 0:
+		return fmt.Errorf("a negative pipe buffer size (%d) is meaningless", c.PipeBuffer)
+	case c.WeightField < 0:
+		return fmt.Errorf("a negative weight field (%d) is meaningless", c.WeightField)
+	case c.DefaultScheme != "" && c.DefaultScheme != "http" && c.DefaultScheme != "https":
+		return fmt.Errorf("a default scheme of %q is not http or https", c.DefaultScheme)
+	case c.ProtocolField < 0:
+		return fmt.Errorf("a negative protocol field (%d) is meaningless", c.ProtocolField)
+	}
+	if err := checkHostTemplate(c.HostTemplate); err != nil {
+		return err
+	}
+	if err := checkThinkTime(c); err != nil {
+		return err
+	}
+	if err := checkRunTags(c.RunTags); err != nil {
+		return err
+	}
+	if err := checkScenarios(c); err != nil {
+		return err
+	}
+	if err := checkClosedModel(c); err != nil {
+		return err
+	}
+	if err := checkResultsDB(c); err != nil {
+		return err
+	}
+	for _, column := range c.IntervalColumns {
+		if !isIntervalColumn(column) {
+			return fmt.Errorf("%q is not an interval stats column, try one of %s",
+				column, strings.Join(IntervalColumns, ", "))
+		}
+	}
+	if c.IfModSince != "" {
+		if _, err := http.ParseTime(c.IfModSince); err != nil {
+			return fmt.Errorf("if-modified-since must be an http date, not %q", c.IfModSince)
+		}
+	}
+	for _, code := range c.SuccessCodes {
+		if code < 100 || code > 999 {
+			return fmt.Errorf("success code %d is not an http code", code)
+		}
+	}
+	for _, p := range []string{c.IncludePattern, c.ExcludePattern} {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("path pattern %q is not a regular expression, %v", p, err)
+		}
+	}
+	for p, w := range c.PathWeights {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("path weight pattern %q is not a regular expression, %v", p, err)
+		}
+		if w < 0 {
+			return fmt.Errorf("path weight %q=%f is negative", p, w)
+		}
+	}
+	for p := range c.ExpectContentTypes {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("content type pattern %q is not a regular expression, %v", p, err)
+		}
+	}
+	for name, value := range c.ExpectHeaders {
+		if name == "" || value == "" {
+			return fmt.Errorf("expected header %q: %q needs both a name and a value, or %q for any", name, value, anyHeaderValue)
+		}
+	}
+	for p := range c.PathRewrites {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("path rewrite pattern %q is not a regular expression, %v", p, err)
+		}
+	}
+	for p, name := range c.PathProtocols {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("path protocol pattern %q is not a regular expression, %v", p, err)
+		}
+		if _, present := protocolNames[strings.ToLower(name)]; !present {
+			return fmt.Errorf("path protocol %q=%s is not a known protocol", p, name)
+		}
+		if strings.ToLower(name) == "s3" && (c.S3Key == "" || c.S3Secret == "") {
+			return fmt.Errorf("the s3 protocol needs both a key and a secret")
+		}
+	}
+	for name := range c.ProtocolURLs {
+		if _, present := protocolNames[name]; !present {
+			return fmt.Errorf("%q has a base URL, but is not a known protocol", name)
+		}
+		if protocolNames[name] == RESTProtocol {
+			if _, err := withScheme(c.ProtocolURLs[name], c.DefaultScheme); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package loadTesting
+
+// VerifyBytes checks that a successful GET returned as many bytes as
+// the input recorded, so truncated or oversized responses, which a 200
+// alone would hide, are counted. Sizes may differ by VerifyTolerance,
+// a fraction of the expected size. Records with a size of zero aren't
+// checked, as a path list has no sizes. With MaxReadBytes, no more
+// than that is expected.
+
+import (
+	"strconv"
+)
+
+// verifyBytes counts and logs a successful GET whose size is wrong
+func (lt *Runner) verifyBytes(path, size string, rc int, got int64) {
+	if !lt.conf.VerifyBytes || rc < 200 || rc >= 300 || rc == 204 {
+		return
+	}
+	expected, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || expected <= 0 {
+		return
+	}
+	if lt.conf.MaxReadBytes > 0 && expected > lt.conf.MaxReadBytes {
+		expected = lt.conf.MaxReadBytes
+	}
+	diff := got - expected
+	if diff < 0 {
+		diff = -diff
+	}
+	if float64(diff) > lt.conf.VerifyTolerance*float64(expected) {
+		lt.results.addMismatch()
+		lt.warnf("GET %s returned %d bytes, expected %d\n", path, got, expected)
+	}
+}